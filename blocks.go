@@ -0,0 +1,236 @@
+package fdiff
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// blockLayout is the layout of the fixed-size blocks of the data when BlockSize of ChunkConfig is
+// set. The whole blocks start at 'alignment' and the bytes before it are the first, shorter, block.
+// The size of the blocks is 0 when the data is split to content-defined chunks.
+type blockLayout struct {
+	size      uint64
+	alignment uint64
+}
+
+// blockLayoutOf return the layout of the blocks of the configuration.
+func blockLayoutOf(cfg ChunkConfig) blockLayout {
+	if cfg.BlockSize == 0 {
+		return blockLayout{}
+	}
+	return blockLayout{size: cfg.BlockSize, alignment: cfg.BlockAlignment % cfg.BlockSize}
+}
+
+// isBoundary return true when a block ends before the byte at offset 'p'.
+func (l blockLayout) isBoundary(p uint64) bool {
+	if p < l.alignment {
+		return false
+	}
+	return (p-l.alignment)%l.size == 0
+}
+
+// index return the index of the block that contains the byte at the offset.
+func (l blockLayout) index(offset uint64) uint64 {
+	if l.alignment == 0 {
+		return offset / l.size
+	}
+	if offset < l.alignment {
+		return 0
+	}
+	return (offset-l.alignment)/l.size + 1
+}
+
+// offset return the offset of the first byte of the block with the index.
+func (l blockLayout) offset(index uint64) uint64 {
+	if l.alignment == 0 {
+		return index * l.size
+	}
+	if index == 0 {
+		return 0
+	}
+	return l.alignment + (index-1)*l.size
+}
+
+// end return the offset after the last byte of the block that contains the byte at the offset.
+func (l blockLayout) end(offset uint64) uint64 {
+	return l.offset(l.index(offset) + 1)
+}
+
+// chunkString return string representation of the block in the format <index>-<signature>. A hole
+// is one or more whole blocks and it is in the format <index>-<number of blocks>-hole.
+func (l blockLayout) chunkString(ch Chunk) string {
+	index := l.index(ch.Offset)
+	if ch.Signature.IsHole() {
+		return fmt.Sprintf("%d-%d-%s", index, l.index(ch.Offset+ch.Length-1)+1-index, ch.Signature)
+	}
+	return fmt.Sprintf("%d-%s", index, ch.Signature)
+}
+
+// createBlockFromString create a new chunk from a string that is created by chunkString. The
+// offset and the length of the chunk are calculated from its index, the layout of the blocks
+// and the size of the file in the header.
+func createBlockFromString(str string, header SignatureHeader) (Chunk, error) {
+	p := strings.Split(str, "-")
+	if len(p) != 2 && (len(p) != 3 || p[2] != holeSignatureText) {
+		return Chunk{}, fmt.Errorf("invalid block %q", str)
+	}
+
+	index, err := strconv.ParseUint(p[0], 10, 64)
+	if err != nil {
+		return Chunk{}, fmt.Errorf("invalid index of block %q: %w", str, err)
+	}
+	blocks := uint64(1)
+	if len(p) == 3 {
+		if blocks, err = strconv.ParseUint(p[1], 10, 64); err != nil || blocks == 0 {
+			return Chunk{}, fmt.Errorf("invalid number of blocks %q", str)
+		}
+	}
+	l := header.blocks()
+	offset, end := l.offset(index), l.offset(index+blocks)
+	if end > header.FileSize {
+		end = header.FileSize
+	}
+	if offset >= end {
+		return Chunk{}, fmt.Errorf("block %q is after the end of the file", str)
+	}
+
+	if len(p) == 3 {
+		return Chunk{Offset: offset, Length: end - offset, Signature: holeSignature(end - offset)}, nil
+	}
+	signature, err := parseSignature(header.StrongHashName(), p[1], header.DigestLength)
+	if err != nil {
+		return Chunk{}, fmt.Errorf("invalid signature of block %q: %w", str, err)
+	}
+	return Chunk{Offset: offset, Length: end - offset, Signature: signature}, nil
+}
+
+// changed return the indexes of the blocks of the new chunks that are different from the blocks of
+// the old chunks with the same indexes. Both must be ordered by their offsets and created with the
+// layout. A block of a hole is not changed if the old block is in a hole too.
+func (l blockLayout) changed(oldChunks, newChunks []Chunk) []uint64 {
+	b := newBlockChanges(l, func() (Chunk, bool) {
+		if len(oldChunks) == 0 {
+			return Chunk{}, false
+		}
+		ch := oldChunks[0]
+		oldChunks = oldChunks[1:]
+		return ch, true
+	})
+	for _, n := range newChunks {
+		b.add(n)
+	}
+	return b.changed
+}
+
+// blockChanges find the changed blocks like blockLayout.changed while the new chunks are added in the
+// order of their offsets. The old chunks are received from 'next' in the order of their offsets, so
+// they can be read from the signature file instead of being kept in memory.
+type blockChanges struct {
+	layout blockLayout
+	next   func() (Chunk, bool)
+
+	// old is the first old chunk that can contain the next added bytes, if ok is true.
+	old Chunk
+	ok  bool
+
+	changed []uint64
+}
+
+// newBlockChanges initialize and return *blockChanges that receives the old chunks from 'next'.
+func newBlockChanges(l blockLayout, next func() (Chunk, bool)) *blockChanges {
+	b := &blockChanges{layout: l, next: next}
+	b.old, b.ok = next()
+	return b
+}
+
+// add compare the blocks of the new chunk with the old blocks with the same indexes.
+func (b *blockChanges) add(n Chunk) {
+	l := b.layout
+	nEnd := n.Offset + n.Length
+	for p := n.Offset; p < nEnd; {
+		end := l.end(p)
+		if end > nEnd {
+			end = nEnd
+		}
+		for b.ok && b.old.Offset+b.old.Length <= p {
+			b.old, b.ok = b.next()
+		}
+		if !b.ok || b.old.Offset > p {
+			b.changed = append(b.changed, l.index(p))
+			p = end
+			continue
+		}
+
+		o := b.old
+		oEnd := o.Offset + o.Length
+		switch {
+		case n.Signature.IsHole() && o.Signature.IsHole() && oEnd >= end:
+			// all blocks that are in both holes are not changed. The old hole can end in
+			// the middle of a block only at the end of the old data.
+			if oEnd >= nEnd {
+				p = nEnd
+			} else if l.isBoundary(oEnd) {
+				p = oEnd
+			} else {
+				p = l.offset(l.index(oEnd))
+			}
+		case !n.Signature.IsHole() && o.Offset == n.Offset && o.Length == n.Length && o.Signature == n.Signature:
+			p = end
+		default:
+			b.changed = append(b.changed, l.index(p))
+			p = end
+		}
+	}
+}
+
+// chunkBlocks split the first 'size' bytes of 'r' to blocks and send them to the hashers. The
+// consecutive blocks that are whole in the holes are sent as one hole. The other blocks are read
+// even if a part of them is in a hole.
+func (pc *ParallelChunker) chunkBlocks(r io.ReaderAt, size int64, extents []extent, hashers *hasherPool) error {
+	l := blockLayoutOf(pc.config)
+	m, _ := r.(*mappedFile)
+	var hole extent
+	for offset := int64(0); offset < size; {
+		end := int64(l.end(uint64(offset)))
+		if end > size {
+			end = size
+		}
+		for len(extents) > 0 && extents[0].offset+extents[0].length <= offset {
+			extents = extents[1:]
+		}
+		if len(extents) > 0 && extents[0].hole && extents[0].offset <= offset && extents[0].offset+extents[0].length >= end {
+			if hole.length == 0 {
+				hole = extent{offset: offset, hole: true}
+			}
+			hole.length += end - offset
+			offset = end
+			continue
+		}
+		if hole.length > 0 {
+			hashers.addHashed(holeChunk(hole))
+			hole = extent{}
+		}
+
+		var data []byte
+		if m != nil && end <= m.Size() {
+			// the mapped file is hashed in place
+			data = m.data[offset:end:end]
+		} else {
+			data = make([]byte, end-offset)
+			if n, err := r.ReadAt(data, offset); int64(n) != end-offset {
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+		}
+		hashers.add(Chunk{Offset: uint64(offset), Length: uint64(end - offset), Data: data})
+		offset = end
+	}
+	if hole.length > 0 {
+		hashers.addHashed(holeChunk(hole))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunker_WithBlockSize(t *testing.T) {
+	cases := map[string]struct {
+		alignment uint64
+		offsets   []uint64
+		lengths   []uint64
+	}{
+		"not aligned":               {alignment: 0, offsets: []uint64{0, 1000, 2000, 3000, 4000, 5000}, lengths: []uint64{1000, 1000, 1000, 1000, 1000, 500}},
+		"aligned":                   {alignment: 300, offsets: []uint64{0, 300, 1300, 2300, 3300, 4300, 5300}, lengths: []uint64{300, 1000, 1000, 1000, 1000, 1000, 200}},
+		"alignment after one block": {alignment: 2300, offsets: []uint64{0, 300, 1300, 2300, 3300, 4300, 5300}, lengths: []uint64{300, 1000, 1000, 1000, 1000, 1000, 200}},
+	}
+	data := randomData(5500, 1)
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			cfg := fdiff.ChunkConfig{WindowSize: 48, BlockSize: 1000, BlockAlignment: c.alignment}
+
+			// Action
+			chunks, err := fdiff.ChunkReader(rollinghash.NewRabinFingerprint, cfg, bytes.NewReader(data))
+
+			// Assert
+			assert.Nil(t, err)
+			var offsets, lengths []uint64
+			for _, ch := range chunks {
+				offsets = append(offsets, ch.Offset)
+				lengths = append(lengths, ch.Length)
+				assert.Equal(t, data[ch.Offset:ch.Offset+ch.Length], ch.Data)
+			}
+			assert.Equal(t, c.offsets, offsets)
+			assert.Equal(t, c.lengths, lengths)
+			for _, jobs := range []int{1, 4} {
+				ch := make(chan fdiff.Chunk, 10)
+				errc := make(chan error, 1)
+				pc := newParallelChunker(t, rollinghash.NewRabinFingerprint, cfg, jobs)
+				go func() {
+					errc <- pc.Chunks(bytes.NewReader(data), int64(len(data)), ch)
+				}()
+				var actual []fdiff.Chunk
+				for chunk := range ch {
+					actual = append(actual, chunk)
+				}
+				assert.Nil(t, <-errc)
+				assert.Equal(t, chunks, actual)
+			}
+		})
+	}
+}
+
+func TestFileSignerDelta_WithBlockSize(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	cfg := fdiff.ChunkConfig{BlockSize: 512, BlockAlignment: 100}
+	oldData := randomData(100*512+100, 1)
+	newData := append([]byte{}, oldData...)
+	copy(newData[100+6*512+10:], "a changed block")
+	copy(newData[100+49*512:], oldData[100+20*512:100+21*512])
+	newData = append(newData, randomData(700, 2)...)
+	writeFile(t, filepath.Join(dir, "old"), oldData)
+	writeFile(t, filepath.Join(dir, "new"), newData)
+	fs := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, cfg, 4)
+
+	// Action
+	signErr := fs.Sign(filepath.Join(dir, "old"), filepath.Join(dir, "old.sig"))
+	d, deltaErr := fs.FindDelta(filepath.Join(dir, "old.sig"), filepath.Join(dir, "new"))
+	header, chunks, decodeErr := fdiff.DecodeSignature(strings.NewReader(readFile(filepath.Join(dir, "old.sig"))))
+
+	// Assert
+	assert.Nil(t, signErr)
+	assert.Nil(t, deltaErr)
+	assert.Nil(t, decodeErr)
+	assert.Equal(t, uint64(512), header.BlockSize)
+	assert.Equal(t, uint64(100), header.BlockAlignment)
+	assert.Equal(t, 101, len(chunks))
+	assert.Equal(t, fdiff.Chunk{Offset: 100 + 6*512, Length: 512, Signature: signatureOf(string(oldData[100+6*512 : 100+7*512]))}, chunks[7])
+	lines := strings.Split(readFile(filepath.Join(dir, "old.sig")), "\n")
+	assert.Contains(t, lines, fmt.Sprintf("7-%s", signatureOf(string(oldData[100+6*512:100+7*512]))))
+	assert.Equal(t, []uint64{7, 50, 101, 102}, d.ChangedBlocks)
+	assert.Equal(t, 3, len(d.NewChunks))
+	assert.Equal(t, newData, applyDelta(t, d, oldData))
+}
+
+func TestFindDelta_WithBlockSizeAndMemoryBudget(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	cfg := fdiff.ChunkConfig{BlockSize: 512, BlockAlignment: 100}
+	oldData := randomData(100*512+100, 1)
+	newData := append([]byte{}, oldData...)
+	copy(newData[100+6*512+10:], "a changed block")
+	copy(newData[100+49*512:], oldData[100+20*512:100+21*512])
+	newData = append(newData, randomData(700, 2)...)
+	writeFile(t, filepath.Join(dir, "old"), oldData)
+	writeFile(t, filepath.Join(dir, "new"), newData)
+	assert.Nil(t, fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, cfg, 4).Sign(filepath.Join(dir, "old"), filepath.Join(dir, "old.sig")))
+	cfg.MemoryBudget = 1000
+
+	// Action
+	d, err := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, cfg, 4).FindDelta(filepath.Join(dir, "old.sig"), filepath.Join(dir, "new"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, d.Edits)
+	assert.Equal(t, []uint64{7, 50, 101, 102}, d.ChangedBlocks)
+	assert.Equal(t, newData, applyDelta(t, d, oldData))
+}
+
+func TestFindDelta_WhenBlocksAreDifferent(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "old"), randomData(5000, 1))
+	blocks := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, fdiff.ChunkConfig{BlockSize: 512}, 1)
+	otherBlocks := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, fdiff.ChunkConfig{BlockSize: 1024}, 1)
+	chunks := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, testChunkConfig, 1)
+	assert.Nil(t, blocks.Sign(filepath.Join(dir, "old"), filepath.Join(dir, "old.sig")))
+
+	// Action
+	_, otherBlocksErr := otherBlocks.FindDelta(filepath.Join(dir, "old.sig"), filepath.Join(dir, "old"))
+	_, chunksErr := chunks.FindDelta(filepath.Join(dir, "old.sig"), filepath.Join(dir, "old"))
+	d, err := blocks.FindDelta(filepath.Join(dir, "old.sig"), filepath.Join(dir, "old"))
+
+	// Assert
+	assert.NotNil(t, otherBlocksErr)
+	assert.NotNil(t, chunksErr)
+	assert.Nil(t, err)
+	assert.Empty(t, d.ChangedBlocks)
+	assert.Empty(t, d.NewChunks)
+}
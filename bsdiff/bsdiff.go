@@ -0,0 +1,255 @@
+// Package bsdiff implements a delta engine for executables and other binary
+// files, based on the algorithm of bsdiff by Colin Percival.
+//
+// A small change of the source code of a program shifts the addresses in the
+// whole compiled binary, so almost no chunk of the old binary is found in the
+// new one. But most of the bytes are still equal or differ by a small value.
+// bsdiff finds approximate matches between the old and the new data with a
+// suffix array over the old data and extends them while at least half of the
+// bytes are equal. For every match a "diff" block is stored: the bytewise
+// difference between the new and the old bytes. It contains mostly zeros, so
+// it is compressed very well. The bytes that are not covered by matches are
+// stored in "extra" blocks.
+//
+// The patch contains three streams that are compressed separately with DEFLATE:
+//
+//	control - triples (length of diff block, length of extra block, seek in the old data)
+//	diff    - the diff blocks
+//	extra   - the extra blocks
+package bsdiff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Magic is written in the beginning of every patch.
+const Magic = "fdiff-bsdiff/1\n"
+
+// Diff write to 'w' a patch that creates 'newData' from 'oldData'.
+func Diff(oldData, newData []byte, w io.Writer) error {
+	I := suffixArray(oldData)
+
+	var control, diff, extra bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	writeControl := func(diffLength, extraLength, seek int) {
+		control.Write(buf[:binary.PutUvarint(buf, uint64(diffLength))])
+		control.Write(buf[:binary.PutUvarint(buf, uint64(extraLength))])
+		control.Write(buf[:binary.PutVarint(buf, int64(seek))])
+	}
+
+	oldSize, newSize := len(oldData), len(newData)
+	var scan, pos, length int
+	var lastScan, lastPos, lastOffset int
+	for scan < newSize {
+		oldScore := 0
+		scan += length
+		for scsc := scan; scan < newSize; scan++ {
+			pos, length = search(I, oldData, newData[scan:], 0, oldSize)
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastOffset < oldSize && oldData[scsc+lastOffset] == newData[scsc] {
+					oldScore++
+				}
+			}
+
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+
+			if scan+lastOffset < oldSize && oldData[scan+lastOffset] == newData[scan] {
+				oldScore--
+			}
+		}
+
+		if length == oldScore && scan != newSize {
+			continue
+		}
+
+		// extend the previous match forward while at least half of the bytes are equal
+		var lenF int
+		for s, sf, i := 0, 0, 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if oldData[lastPos+i] == newData[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lenF {
+				sf, lenF = s, i
+			}
+		}
+
+		// extend the next match backward while at least half of the bytes are equal
+		var lenB int
+		if scan < newSize {
+			for s, sb, i := 0, 0, 1; scan >= lastScan+i && pos >= i; i++ {
+				if oldData[pos-i] == newData[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lenB {
+					sb, lenB = s, i
+				}
+			}
+		}
+
+		// if the extensions overlap, find the best place to split them
+		if lastScan+lenF > scan-lenB {
+			overlap := (lastScan + lenF) - (scan - lenB)
+			s, ss, lenS := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if newData[lastScan+lenF-overlap+i] == oldData[lastPos+lenF-overlap+i] {
+					s++
+				}
+				if newData[scan-lenB+i] == oldData[pos-lenB+i] {
+					s--
+				}
+				if s > ss {
+					ss, lenS = s, i+1
+				}
+			}
+			lenF += lenS - overlap
+			lenB -= lenS
+		}
+
+		for i := 0; i < lenF; i++ {
+			diff.WriteByte(newData[lastScan+i] - oldData[lastPos+i])
+		}
+		extraLength := (scan - lenB) - (lastScan + lenF)
+		extra.Write(newData[lastScan+lenF : lastScan+lenF+extraLength])
+		writeControl(lenF, extraLength, (pos-lenB)-(lastPos+lenF))
+
+		lastScan = scan - lenB
+		lastPos = pos - lenB
+		lastOffset = pos - scan
+	}
+
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString(Magic)
+	_, _ = bw.Write(buf[:binary.PutUvarint(buf, uint64(newSize))])
+	for _, stream := range []*bytes.Buffer{&control, &diff, &extra} {
+		compressed, err := compress(stream.Bytes())
+		if err != nil {
+			return err
+		}
+		_, _ = bw.Write(buf[:binary.PutUvarint(buf, uint64(len(compressed)))])
+		_, _ = bw.Write(compressed)
+	}
+	return bw.Flush()
+}
+
+// Patch apply the patch from 'r' to 'oldData' and write the new data to 'w'.
+func Patch(oldData []byte, r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != Magic {
+		return errors.New("the data is not a bsdiff patch")
+	}
+	newSize, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+
+	var streams [3]*bufio.Reader
+	for i := range streams {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return err
+		}
+		// the length is not trusted, so the buffer grows while the stream is read
+		var compressed bytes.Buffer
+		if m, err := compressed.ReadFrom(io.LimitReader(br, int64(n))); err != nil || n > math.MaxInt64 || uint64(m) != n {
+			return fmt.Errorf("read stream %d of %d bytes: the patch is too short", i, n)
+		}
+		streams[i] = bufio.NewReader(flate.NewReader(&compressed))
+	}
+	control, diff, extra := streams[0], streams[1], streams[2]
+
+	bw := bufio.NewWriter(w)
+	var oldPos int64
+	var newPos uint64
+	for newPos < newSize {
+		diffLength, err := binary.ReadUvarint(control)
+		if err != nil {
+			return fmt.Errorf("read control: %w", err)
+		}
+		extraLength, err := binary.ReadUvarint(control)
+		if err != nil {
+			return fmt.Errorf("read control: %w", err)
+		}
+		seek, err := binary.ReadVarint(control)
+		if err != nil {
+			return fmt.Errorf("read control: %w", err)
+		}
+		if newPos+diffLength+extraLength > newSize {
+			return errors.New("the patch is corrupted")
+		}
+
+		for i := uint64(0); i < diffLength; i++ {
+			b, err := diff.ReadByte()
+			if err != nil {
+				return fmt.Errorf("read diff: %w", err)
+			}
+			if p := oldPos + int64(i); p >= 0 && p < int64(len(oldData)) {
+				b += oldData[p]
+			}
+			_ = bw.WriteByte(b)
+		}
+		if _, err = io.CopyN(bw, extra, int64(extraLength)); err != nil {
+			return fmt.Errorf("read extra: %w", err)
+		}
+
+		newPos += diffLength + extraLength
+		oldPos += int64(diffLength) + seek
+	}
+	return bw.Flush()
+}
+
+// search find the longest match of 'newData' in the suffixes of 'oldData' between
+// 'st' and 'en' in the suffix array. It returns the position and the length of the match.
+func search(I []int, oldData, newData []byte, st, en int) (int, int) {
+	for en-st >= 2 {
+		x := st + (en-st)/2
+		if bytes.Compare(oldData[I[x]:], newData) < 0 {
+			st = x
+		} else {
+			en = x
+		}
+	}
+
+	x := matchLength(oldData[I[st]:], newData)
+	y := matchLength(oldData[I[en]:], newData)
+	if x > y {
+		return I[st], x
+	}
+	return I[en], y
+}
+
+// matchLength return the number of equal bytes in the beginning of 'a' and 'b'.
+func matchLength(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// compress compress the data with DEFLATE.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,172 @@
+package bsdiff_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/bsdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffAndPatch(t *testing.T) {
+	random := randomData(50000, 1)
+	changed := append([]byte{}, random...)
+	for i := 100; i < len(changed); i += 1000 {
+		changed[i]++
+	}
+
+	cases := []struct {
+		name    string
+		oldData []byte
+		newData []byte
+	}{
+		{name: "equal data", oldData: random, newData: random},
+		{name: "changed bytes", oldData: random, newData: changed},
+		{name: "inserted data", oldData: random, newData: concat(random[:20000], []byte("inserted data"), random[20000:])},
+		{name: "removed data", oldData: random, newData: concat(random[:20000], random[30000:])},
+		{name: "moved data", oldData: random, newData: concat(random[30000:], random[:30000])},
+		{name: "repeated bytes", oldData: bytes.Repeat([]byte("ab"), 10000), newData: bytes.Repeat([]byte("abc"), 10000)},
+		{name: "empty old data", oldData: nil, newData: random[:1000]},
+		{name: "empty new data", oldData: random[:1000], newData: nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Action
+			var patch bytes.Buffer
+			err := bsdiff.Diff(c.oldData, c.newData, &patch)
+			var actual bytes.Buffer
+			patchErr := bsdiff.Patch(c.oldData, &patch, &actual)
+
+			// Assert
+			assert.Nil(t, err)
+			assert.Nil(t, patchErr)
+			assert.Equal(t, len(c.newData), actual.Len())
+			assert.True(t, bytes.Equal(c.newData, actual.Bytes()))
+		})
+	}
+}
+
+func TestPatch_WhenDataIsNotPatch(t *testing.T) {
+	// Action
+	err := bsdiff.Patch(nil, bytes.NewReader([]byte("fdiff-patch/1\n")), &bytes.Buffer{})
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func TestPatch_WhenStreamLengthIsMalformed(t *testing.T) {
+	// SetUp
+	patch := binary.AppendUvarint([]byte(bsdiff.Magic), 100)
+	patch = binary.AppendUvarint(patch, 1<<62)
+
+	// Action
+	err := bsdiff.Patch(nil, bytes.NewReader(append(patch, "abc"...)), &bytes.Buffer{})
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+// TestDiff_ComparedWithChunks compare the size of the bsdiff patch with the size
+// of the patch that is created from chunks of the executable-like data.
+func TestDiff_ComparedWithChunks(t *testing.T) {
+	// SetUp
+	oldData, newData := executable(50000, 0), executable(50000, 64)
+
+	// Action
+	var patch bytes.Buffer
+	err := bsdiff.Diff(oldData, newData, &patch)
+	chunksPatch := chunksPatchSize(t, oldData, newData)
+
+	// Assert
+	assert.Nil(t, err)
+	t.Logf("new data: %d bytes, bsdiff patch: %d bytes, chunks patch: %d bytes", len(newData), patch.Len(), chunksPatch)
+	assert.Less(t, patch.Len(), chunksPatch/10)
+}
+
+// executable create data that look like a compiled program. It contains
+// instructions with absolute addresses of other instructions. If 'inserted'
+// is not zero, new instructions are inserted in the beginning of the program
+// and all addresses after them are shifted, like after a small code change.
+func executable(instructions int, inserted int) []byte {
+	r := rand.New(rand.NewSource(1))
+	shift := uint32(inserted * 8)
+	var buf bytes.Buffer
+	for i := 0; i < instructions; i++ {
+		if i == instructions/10 {
+			for j := 0; j < inserted; j++ {
+				buf.Write([]byte{0x90, 0x90, 0x90, 0x90, 0x90, 0x90, 0x90, 0x90})
+			}
+		}
+		opcode := []byte{0x48, 0xe8, 0xe9, 0x8b}[r.Intn(4)]
+		target := uint32(r.Intn(instructions) * 8)
+		if target >= uint32(instructions/10*8) {
+			target += shift
+		}
+		var instruction [8]byte
+		instruction[0], instruction[1] = opcode, byte(r.Intn(16))
+		binary.LittleEndian.PutUint32(instruction[2:], target)
+		buf.Write(instruction[:])
+	}
+	return buf.Bytes()
+}
+
+// chunksPatchSize return the size of the patch that is created by FindDelta.
+func chunksPatchSize(t *testing.T, oldData, newData []byte) int {
+	dir := t.TempDir()
+	oldFile, newFile, signFile := filepath.Join(dir, "old"), filepath.Join(dir, "new"), filepath.Join(dir, "sign")
+	if err := os.WriteFile(oldFile, oldData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newFile, newData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// chunks of 1 KB on average, so the boundaries depend on the content
+	cfg := fdiff.ChunkConfig{WindowSize: 16, MinSizeChunk: 256, MaxSizeChunk: 32768, Discriminator: 1024, FingerprintBreakPoint: 1023}
+
+	d, ch := make(chan byte, 100), make(chan fdiff.Chunk, 100)
+	c, err := fdiff.NewChunker(rollinghash.NewRabinFingerprint, cfg, d, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	if err = fdiff.NewFileSignerDelta(d, ch).Sign(oldFile, signFile); err != nil {
+		t.Fatal(err)
+	}
+
+	d, ch = make(chan byte, 100), make(chan fdiff.Chunk, 100)
+	if c, err = fdiff.NewChunker(rollinghash.NewRabinFingerprint, cfg, d, ch); err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	delta, err := fdiff.NewFileSignerDelta(d, ch).FindDelta(signFile, newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var patch bytes.Buffer
+	if err = fdiff.NewPatch(delta, fdiff.CodecDeflate).Encode(&patch); err != nil {
+		t.Fatal(err)
+	}
+	return patch.Len()
+}
+
+func randomData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func concat(parts ...[]byte) []byte {
+	var data []byte
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	return data
+}
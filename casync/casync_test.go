@@ -0,0 +1,92 @@
+package casync_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/casync"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunk(t *testing.T) {
+	// SetUp
+	data := readFile(t, "chunker.input")
+	expected, err := casync.ReadIndex(bytes.NewReader(readFile(t, "chunker.index")))
+	assert.NoError(t, err)
+
+	// Action
+	chunks, err := casync.Chunk(bytes.NewReader(data), casync.DefaultChunkSizeMin, casync.DefaultChunkSizeAvg, casync.DefaultChunkSizeMax)
+
+	// Assert
+	assert.NoError(t, err)
+	actual, err := casync.NewIndex(chunks, casync.DefaultChunkSizeMin, casync.DefaultChunkSizeAvg, casync.DefaultChunkSizeMax)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestIndex_WriteTo(t *testing.T) {
+	// SetUp
+	expected := readFile(t, "chunker.index")
+	idx, err := casync.ReadIndex(bytes.NewReader(expected))
+	assert.NoError(t, err)
+
+	// Action
+	var actual bytes.Buffer
+	n, err := idx.WriteTo(&actual)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(expected)), n)
+	assert.Equal(t, expected, actual.Bytes())
+}
+
+func TestIndex_ChunkList(t *testing.T) {
+	// SetUp
+	idx, err := casync.ReadIndex(bytes.NewReader(readFile(t, "chunker.index")))
+	assert.NoError(t, err)
+
+	// Action
+	chunks := idx.ChunkList()
+
+	// Assert
+	assert.Len(t, chunks, 20)
+	assert.Equal(t, fdiff.Chunk{Offset: 0, Length: 81590, Signature: parseSHA512_256("ad951d7f65c27828ce390f3c81c41d75f80e4527169ad072ad720b56220f5be4")}, chunks[0])
+	assert.Equal(t, fdiff.Chunk{Offset: 982644, Length: 65932, Signature: parseSHA512_256("a8bfdadaecbee1ed16ce23d8bf771d1b3fbca2e631fc71b5adb3846c1bb2d542")}, chunks[19])
+}
+
+func TestReadIndex_WhenDataIsNotIndex(t *testing.T) {
+	// Action
+	_, err := casync.ReadIndex(bytes.NewReader(readFile(t, "chunker.input")[:1000]))
+
+	// Assert
+	assert.EqualError(t, err, "the data is not a casync index")
+}
+
+func TestReadIndex_WhenTableIsTruncated(t *testing.T) {
+	// SetUp
+	data := readFile(t, "chunker.index")
+
+	// Action
+	_, err := casync.ReadIndex(bytes.NewReader(data[:100]))
+
+	// Assert
+	assert.EqualError(t, err, "the table of the index is truncated")
+}
+
+func readFile(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	assert.NoError(t, err)
+	return data
+}
+
+// parseSHA512_256 parse the SHA-512/256 signature in hex.
+func parseSHA512_256(s string) fdiff.Signature {
+	signature, err := fdiff.ParseSignature(fdiff.StrongHashSHA512_256, s)
+	if err != nil {
+		panic(err)
+	}
+	return signature
+}
@@ -0,0 +1,49 @@
+package casync
+
+import (
+	"io"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+)
+
+// parameters of the chunker of casync.
+const (
+	// WindowSize is the size of the window of the buzhash.
+	WindowSize = 48
+
+	// DefaultChunkSizeAvg is the default average size of the chunks.
+	DefaultChunkSizeAvg = 64 * 1024
+
+	// DefaultChunkSizeMin is the default minimum size of the chunks.
+	DefaultChunkSizeMin = DefaultChunkSizeAvg / 4
+
+	// DefaultChunkSizeMax is the default maximum size of the chunks.
+	DefaultChunkSizeMax = DefaultChunkSizeAvg * 4
+)
+
+// ChunkConfig return the configuration of the Chunker, with which it creates the same chunks
+// as casync with the minimum, the average and the maximum size of the chunks. The Chunker
+// must use rollinghash.NewBuzhash.
+func ChunkConfig(min, avg, max uint64) fdiff.ChunkConfig {
+	d := discriminatorFromAvg(avg)
+	return fdiff.ChunkConfig{
+		WindowSize:            WindowSize,
+		MinSizeChunk:          int(min),
+		MaxSizeChunk:          int(max),
+		FingerprintBreakPoint: d - 1,
+		Discriminator:         d,
+		RollingHash:           rollinghash.Buzhash,
+	}
+}
+
+// discriminatorFromAvg return the discriminator of casync. A chunk ends when
+// hash % discriminator == discriminator - 1, so the chunks have the average size.
+func discriminatorFromAvg(avg uint64) uint64 {
+	return uint64(float64(avg) / (-1.42888852e-7*float64(avg) + 1.33237515))
+}
+
+// Chunk split the data in chunks with the chunker of casync.
+func Chunk(r io.Reader, min, avg, max uint64) ([]fdiff.Chunk, error) {
+	return fdiff.ChunkReader(rollinghash.NewBuzhash, ChunkConfig(min, avg, max), r)
+}
@@ -0,0 +1,187 @@
+// Package casync reads and writes the index files of casync (.caibx) and creates
+// chunks with the same boundaries as casync and desync.
+//
+// An index file contains the parameters of the chunker and a table with the end
+// offset and the ID of every chunk. The ID of a chunk is the SHA512/256 digest of
+// its data. All integers are little-endian uint64:
+//
+//	size (48) | FormatIndex | feature flags | min size | avg size | max size
+//	size (MaxUint64) | FormatTable | [end offset | ID (32 bytes)]... | tail
+//
+// The tail is: 0 | 0 | offset of the table (48) | size of the table | FormatTableTailMarker.
+package casync
+
+import (
+	"bufio"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/EmilGeorgiev/fdiff"
+)
+
+// types of the elements of an index file.
+const (
+	// FormatIndex is the type of the header of the index.
+	FormatIndex = 0x96824d9c7b129ff9
+
+	// FormatTable is the type of the table with the chunks.
+	FormatTable = 0xe75b9e112f17417d
+
+	// FormatTableTailMarker is the type of the end of the table.
+	FormatTableTailMarker = 0x4b4f050e5549ecd1
+)
+
+// feature flags of an index file.
+const (
+	// FlagSHA512256 show that the IDs of the chunks are SHA512/256 digests. Without it they are SHA256.
+	FlagSHA512256 = 0x2000000000000000
+
+	// FlagExcludeNoDump is set by casync by default.
+	FlagExcludeNoDump = 0x8000000000000000
+
+	// DefaultFeatureFlags are the feature flags of the index files that casync creates.
+	DefaultFeatureFlags = FlagSHA512256 | FlagExcludeNoDump
+)
+
+// indexHeaderSize is the size of the header of the index.
+const indexHeaderSize = 48
+
+// ChunkID is the SHA512/256 digest of the data of a chunk.
+type ChunkID [32]byte
+
+// String return the ID in hex.
+func (id ChunkID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IndexChunk is a chunk in the index.
+type IndexChunk struct {
+	ID    ChunkID
+	Start uint64
+	Size  uint64
+}
+
+// Index is the content of an index file.
+type Index struct {
+	FeatureFlags uint64
+	ChunkSizeMin uint64
+	ChunkSizeAvg uint64
+	ChunkSizeMax uint64
+	Chunks       []IndexChunk
+}
+
+// NewIndex create an index of the chunks. The chunks must contain their data and
+// must be ordered by their offsets. 'min', 'avg' and 'max' are the parameters of the
+// chunker that created them.
+func NewIndex(chunks []fdiff.Chunk, min, avg, max uint64) (Index, error) {
+	idx := Index{FeatureFlags: DefaultFeatureFlags, ChunkSizeMin: min, ChunkSizeAvg: avg, ChunkSizeMax: max}
+	var start uint64
+	for _, c := range chunks {
+		if c.Offset != start || uint64(len(c.Data)) != c.Length {
+			return Index{}, fmt.Errorf("the chunk with offset %d and length %d doesn't follow the previous chunk or has no data", c.Offset, c.Length)
+		}
+		idx.Chunks = append(idx.Chunks, IndexChunk{ID: sha512.Sum512_256(c.Data), Start: c.Offset, Size: c.Length})
+		start += c.Length
+	}
+	return idx, nil
+}
+
+// ChunkList return the chunks of the index. The signatures of the chunks are the IDs, which
+// are SHA-512/256 digests. The chunks don't contain data.
+func (idx Index) ChunkList() []fdiff.Chunk {
+	chunks := make([]fdiff.Chunk, 0, len(idx.Chunks))
+	for _, c := range idx.Chunks {
+		// the size of the ID is the size of SHA-512/256, so the signature is always valid
+		signature, _ := fdiff.NewSignature(fdiff.StrongHashSHA512_256, c.ID[:])
+		chunks = append(chunks, fdiff.Chunk{Offset: c.Start, Length: c.Size, Signature: signature})
+	}
+	return chunks
+}
+
+// WriteTo write the index in the format of casync.
+func (idx Index) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	write := func(values ...uint64) {
+		b := make([]byte, 8)
+		for _, v := range values {
+			binary.LittleEndian.PutUint64(b, v)
+			m, _ := bw.Write(b)
+			n += int64(m)
+		}
+	}
+
+	write(indexHeaderSize, FormatIndex, idx.FeatureFlags, idx.ChunkSizeMin, idx.ChunkSizeAvg, idx.ChunkSizeMax)
+	write(math.MaxUint64, FormatTable)
+	var end uint64
+	for _, c := range idx.Chunks {
+		end += c.Size
+		write(end)
+		m, _ := bw.Write(c.ID[:])
+		n += int64(m)
+	}
+	tableSize := uint64(16 + len(idx.Chunks)*40 + 40)
+	write(0, 0, indexHeaderSize, tableSize, FormatTableTailMarker)
+	return n, bw.Flush()
+}
+
+// ReadIndex read an index file of casync.
+func ReadIndex(r io.Reader) (Index, error) {
+	br := bufio.NewReader(r)
+	header, err := readUint64s(br, 6)
+	if err != nil || header[0] != indexHeaderSize || header[1] != FormatIndex {
+		return Index{}, errors.New("the data is not a casync index")
+	}
+	idx := Index{FeatureFlags: header[2], ChunkSizeMin: header[3], ChunkSizeAvg: header[4], ChunkSizeMax: header[5]}
+	if idx.FeatureFlags&FlagSHA512256 == 0 {
+		return Index{}, errors.New("only indexes with SHA512/256 IDs are supported")
+	}
+
+	table, err := readUint64s(br, 2)
+	if err != nil || table[0] != math.MaxUint64 || table[1] != FormatTable {
+		return Index{}, errors.New("the table of the index is not found")
+	}
+	var start uint64
+	for {
+		end, err := readUint64s(br, 1)
+		if err != nil {
+			return Index{}, errors.New("the table of the index is truncated")
+		}
+		if end[0] == 0 {
+			break
+		}
+		if end[0] <= start || end[0]-start > idx.ChunkSizeMax {
+			return Index{}, fmt.Errorf("invalid end offset %d of the chunk that starts at %d", end[0], start)
+		}
+		c := IndexChunk{Start: start, Size: end[0] - start}
+		if _, err = io.ReadFull(br, c.ID[:]); err != nil {
+			return Index{}, errors.New("the table of the index is truncated")
+		}
+		idx.Chunks = append(idx.Chunks, c)
+		start = end[0]
+	}
+
+	tail, err := readUint64s(br, 4)
+	if err != nil || tail[0] != 0 || tail[3] != FormatTableTailMarker {
+		return Index{}, errors.New("the tail of the table is not found")
+	}
+	return idx, nil
+}
+
+// readUint64s read 'n' little-endian uint64.
+func readUint64s(br *bufio.Reader, n int) ([]uint64, error) {
+	b := make([]byte, 8*n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, err
+	}
+	values := make([]uint64, n)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return values, nil
+}
@@ -1,8 +1,10 @@
 package fdiff
 
 import (
-	"crypto/sha1"
-	"fmt"
+	"bufio"
+	"hash"
+	"io"
+	"runtime"
 
 	"github.com/EmilGeorgiev/fdiff/rollinghash"
 )
@@ -23,6 +25,58 @@ type ChunkConfig struct {
 	// hash value of the bytes in window are equal to FingerprintBreakPoint
 	// this means that the Chuncker should create a new chunk
 	FingerprintBreakPoint uint64 `yaml:"fingerprint_break_point"`
+
+	// Discriminator divides the hash value before it is compared with the
+	// FingerprintBreakPoint. When it is 0 the hash value is compared as it is.
+	// For example casync creates a new chunk when hash % discriminator == discriminator - 1.
+	Discriminator uint64 `yaml:"discriminator"`
+
+	// RollingHash is the name of the rolling hash (rabin, buzhash or rabin64). The Chunker
+	// doesn't use it, the rolling hash is given to NewChunker. See RollingHashOf.
+	RollingHash string `yaml:"rolling_hash"`
+
+	// Polynomial is the irreducible polynomial of rabin64 in hex.
+	Polynomial string `yaml:"polynomial"`
+
+	// StrongHash is the name of the hash that creates the signatures of the chunks (sha1,
+	// sha256, sha512-256, blake2b-256 or a registered one). The empty name is DefaultStrongHash.
+	// See RegisterStrongHash.
+	StrongHash string `yaml:"strong_hash"`
+
+	// DigestLength is the number of bytes of the digests in the signature files. When it is 0
+	// the digests are not truncated, unless CollisionBits is set. See DigestLengthOf.
+	DigestLength int `yaml:"digest_length"`
+
+	// CollisionBits set the target probability 2^-CollisionBits that two different chunks have equal
+	// truncated digests in a delta. The length of the digests is calculated from it and the size of the
+	// file. When it and DigestLength are 0 the digests are not truncated.
+	CollisionBits int `yaml:"collision_bits"`
+
+	// MemoryBudget is the number of bytes of memory for the chunks of a signature when a delta
+	// is found. When the chunks of the signature need more memory, they are stored in an index
+	// on the disk. When it is 0 the chunks are always kept in memory.
+	MemoryBudget uint64 `yaml:"memory_budget"`
+
+	// BlockSize is the number of bytes of the blocks when the data is split to fixed-size blocks
+	// instead of content-defined chunks, for example the blocks of disk images and databases. The
+	// signatures of the blocks are stored by their indexes. When it is 0 the rolling hash is used.
+	BlockSize uint64 `yaml:"block_size"`
+
+	// BlockAlignment is the offset of the first whole block, for example the offset of a partition
+	// in a disk image. The bytes before it are the first block. It is used only with BlockSize.
+	BlockAlignment uint64 `yaml:"block_alignment"`
+}
+
+// RollingHashOf return the function that creates the rolling hash of the configuration.
+func RollingHashOf(cfg ChunkConfig) (func([]byte) rollinghash.Hash, error) {
+	if cfg.RollingHash != rollinghash.Rabin64 {
+		return rollinghash.ByName(cfg.RollingHash)
+	}
+	pol, err := rollinghash.ParsePol(cfg.Polynomial)
+	if err != nil {
+		return nil, err
+	}
+	return rollinghash.NewRabin64(pol)
 }
 
 // Chunker split data to chunks. It read data from a channel and
@@ -35,6 +89,12 @@ type Chunker struct {
 	// newRollingHash is creating a new rolling hash
 	newRollingHash func([]byte) rollinghash.Hash
 
+	// newStrongHash is creating a new hash for the signatures of the chunks
+	newStrongHash func() hash.Hash
+
+	// strongHashTag is the tag of the strong hash in the signatures of the chunks
+	strongHashTag byte
+
 	// bytesOfTheChunk contains current bytes that will be included in the next Chunk.
 	bytesOfTheChunk []byte
 
@@ -46,40 +106,46 @@ type Chunker struct {
 
 	// offset points from where a Chunk started.
 	offset uint64
+
+	// Hashers is the number of goroutines that calculate the signatures of the chunks. The
+	// chunks are still sent in the order of their offsets. When it is 0, it is GOMAXPROCS.
+	Hashers int
+
+	// hashers calculate the signatures of the created chunks and send them through 'chunks'.
+	hashers *hasherPool
+
+	// blocks is the layout of the blocks when the data is split to fixed-size blocks.
+	blocks blockLayout
 }
 
-// NewChunker initialize and return *Chunker.
-func NewChunker(new func([]byte) rollinghash.Hash, cfg ChunkConfig, b chan byte, ch chan Chunk) *Chunker {
+// NewChunker initialize and return *Chunker. It return an error if the strong hash of the
+// configuration is not registered.
+func NewChunker(new func([]byte) rollinghash.Hash, cfg ChunkConfig, b chan byte, ch chan Chunk) (*Chunker, error) {
+	strongHash, err := getStrongHash(cfg.StrongHash)
+	if err != nil {
+		return nil, err
+	}
 	return &Chunker{
 		config:         cfg,
 		newRollingHash: new,
+		newStrongHash:  strongHash.new,
+		strongHashTag:  strongHash.tag,
 		bytes:          b,
 		chunks:         ch,
-	}
+		blocks:         blockLayoutOf(cfg),
+	}, nil
 }
 
 // Start a goroutine that listen for a new bytes that should be split in chunks.
 // and send
 func (ch *Chunker) Start() {
+	ch.startHashers()
 	go func() {
 		var h rollinghash.Hash
+		var end bool
 		for b := range ch.bytes {
 			ch.bytesOfTheChunk = append(ch.bytesOfTheChunk, b)
-			if h == nil {
-				if uint64(len(ch.bytesOfTheChunk)) < ch.config.WindowSize {
-					// the number of bytes should be equal to the windows, then we
-					// can calculate the hash/sign of the first window
-					continue
-				}
-				h = ch.newRollingHash(ch.bytesOfTheChunk)
-				if ch.shouldCreateAChunk(h) {
-					ch.createChunk()
-					continue
-				}
-
-			}
-			h.Next(b)
-			if ch.shouldCreateAChunk(h) {
+			if h, end = ch.next(h, ch.bytesOfTheChunk); end {
 				ch.createChunk()
 			}
 		}
@@ -89,28 +155,144 @@ func (ch *Chunker) Start() {
 			// is created and send it. This will be the last chunk.
 			ch.createChunk()
 		}
-		close(ch.chunks)
+		ch.hashers.close()
+	}()
+}
+
+// StartInPlace start a goroutine that split the data to chunks instead of the data that is received
+// from the channel of the bytes. The data is scanned in place and the data of the chunks are subslices
+// of it, so it must not be changed until all chunks are received.
+func (ch *Chunker) StartInPlace(data []byte) {
+	ch.startHashers()
+	go func() {
+		var h rollinghash.Hash
+		var end bool
+		start := 0
+		for i := range data {
+			if h, end = ch.next(h, data[start:i+1]); end {
+				ch.bytesOfTheChunk = data[start : i+1 : i+1]
+				ch.createChunk()
+				start = i + 1
+			}
+		}
+
+		if start < len(data) {
+			ch.bytesOfTheChunk = data[start:len(data):len(data)]
+			ch.createChunk()
+		}
+		ch.hashers.close()
 	}()
 }
 
-func (ch *Chunker) shouldCreateAChunk(h rollinghash.Hash) bool {
-	if (h.Value() == ch.config.FingerprintBreakPoint) && (len(ch.bytesOfTheChunk) >= ch.config.MinSizeChunk) {
+// startHashers start the goroutines that calculate the signatures of the chunks.
+func (ch *Chunker) startHashers() {
+	hashers := ch.Hashers
+	if hashers == 0 {
+		hashers = runtime.GOMAXPROCS(0)
+	}
+	ch.hashers = newHasherPool(ch.newStrongHash, ch.strongHashTag, hashers, ch.chunks)
+}
+
+// next move the rolling hash with the last byte of the current chunk and return whether the chunk
+// should end with it. The rolling hash is nil until the first window of the data is received.
+// The fixed-size blocks end at the boundaries of the blocks without the rolling hash.
+func (ch *Chunker) next(h rollinghash.Hash, chunk []byte) (rollinghash.Hash, bool) {
+	if ch.blocks.size > 0 {
+		return nil, ch.blocks.isBoundary(ch.offset + uint64(len(chunk)))
+	}
+	if h == nil {
+		if uint64(len(chunk)) < ch.config.WindowSize {
+			// the number of bytes should be equal to the windows, then we
+			// can calculate the hash/sign of the first window
+			return nil, false
+		}
+		h = ch.newRollingHash(chunk)
+		if ch.shouldCreateAChunk(h, len(chunk)) {
+			return h, true
+		}
+	}
+	h.Next(chunk[len(chunk)-1])
+	return h, ch.shouldCreateAChunk(h, len(chunk))
+}
+
+func (ch *Chunker) shouldCreateAChunk(h rollinghash.Hash, size int) bool {
+	v := h.Value()
+	if ch.config.Discriminator != 0 {
+		v %= ch.config.Discriminator
+	}
+	if (v == ch.config.FingerprintBreakPoint) && (size >= ch.config.MinSizeChunk) {
 		return true
 	}
-	return len(ch.bytesOfTheChunk) >= ch.config.MaxSizeChunk
+	return size >= ch.config.MaxSizeChunk
 }
 
+// createChunk send the bytes of the chunk to the hashers, which calculate its signature
+// and send it through the channel of the chunks.
 func (ch *Chunker) createChunk() {
-	sum := fmt.Sprintf("%s", sha1.Sum(ch.bytesOfTheChunk))
-	sign := fmt.Sprintf("%x", sum)
-	c := Chunk{
-		Offset:    ch.offset,
-		Length:    uint64(len(ch.bytesOfTheChunk)),
-		Data:      ch.bytesOfTheChunk,
-		Signature: sign,
-	}
-	ch.chunks <- c
+	ch.hashers.add(Chunk{
+		Offset: ch.offset,
+		Length: uint64(len(ch.bytesOfTheChunk)),
+		Data:   ch.bytesOfTheChunk,
+	})
 	ch.offset += uint64(len(ch.bytesOfTheChunk))
 	// reset bytes of the chunk because next byte will be part of the next chunk
 	ch.bytesOfTheChunk = []byte{}
 }
+
+// ChunkReader split the data of the reader to chunks with a new Chunker and
+// return all created chunks in the order of their offsets.
+func ChunkReader(new func([]byte) rollinghash.Hash, cfg ChunkConfig, r io.Reader) ([]Chunk, error) {
+	b := make(chan byte, 1000)
+	ch := make(chan Chunk, 1000)
+	c, err := NewChunker(new, cfg, b, ch)
+	if err != nil {
+		return nil, err
+	}
+	c.Start()
+
+	go func() {
+		defer close(b)
+		br := bufio.NewReader(r)
+		for {
+			c, e := br.ReadByte()
+			if e != nil {
+				if e != io.EOF {
+					err = e
+				}
+				return
+			}
+			b <- c
+		}
+	}()
+
+	var chunks []Chunk
+	for c := range ch {
+		chunks = append(chunks, c)
+	}
+	return chunks, err
+}
+
+// chunkFile split the file to chunks like the SignerDeltas and return all created chunks in
+// the order of their offsets. The holes of a sparse file are chunks with the signatures of
+// holes. When the file is mapped to the memory, the data of the chunks are subslices of it
+// and can be used until the returned file is closed. Otherwise, the returned file is nil.
+func chunkFile(new func([]byte) rollinghash.Hash, cfg ChunkConfig, file string) ([]Chunk, *mappedFile, error) {
+	pc, err := NewParallelChunker(new, cfg, runtime.GOMAXPROCS(0))
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, errc, m, err := pc.chunksOfFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var chunks []Chunk
+	for c := range ch {
+		chunks = append(chunks, c)
+	}
+	if err = <-errc; err != nil {
+		m.Close()
+		return nil, nil, err
+	}
+	return chunks, m, nil
+}
@@ -1,8 +1,7 @@
 package fdiff_test
 
 import (
-	"crypto/sha1"
-	"fmt"
+	"bytes"
 	"testing"
 
 	"github.com/EmilGeorgiev/fdiff"
@@ -22,7 +21,7 @@ func TestNewChunker(t *testing.T) {
 		MaxSizeChunk:          50,
 		FingerprintBreakPoint: 3194, // this is the hash fingerprint of "abcd"
 	}
-	c := fdiff.NewChunker(rollinghash.NewRabinFingerprint, cfg, b, ch)
+	c := newChunker(t, rollinghash.NewRabinFingerprint, cfg, b, ch)
 
 	// Action
 	c.Start()
@@ -43,25 +42,25 @@ func TestNewChunker(t *testing.T) {
 			Offset:    0,
 			Data:      []byte("If you abcd want to draw abcd"),
 			Length:    29,
-			Signature: fmt.Sprintf("%x", sha1.Sum([]byte("If you abcd want to draw abcd"))),
+			Signature: signatureOf("If you abcd want to draw abcd"),
 		},
 		{
 			Offset:    29,
 			Data:      []byte(" readers to a story, you need abcd"),
 			Length:    34,
-			Signature: fmt.Sprintf("%x", sha1.Sum([]byte(" readers to a story, you need abcd"))),
+			Signature: signatureOf(" readers to a story, you need abcd"),
 		},
 		{
 			Offset:    63,
 			Data:      []byte(" to make them want to choose abc ABCD it. Hello Wo"),
 			Length:    50,
-			Signature: fmt.Sprintf("%x", sha1.Sum([]byte(" to make them want to choose abc ABCD it. Hello Wo"))),
+			Signature: signatureOf(" to make them want to choose abc ABCD it. Hello Wo"),
 		},
 		{
 			Offset:    113,
 			Data:      []byte("rld!!! abc d"),
 			Length:    12,
-			Signature: fmt.Sprintf("%x", sha1.Sum([]byte("rld!!! abc d"))),
+			Signature: signatureOf("rld!!! abc d"),
 		},
 	}
 
@@ -79,7 +78,7 @@ func TestNewChunker_WhenTheFirstWindowIsTheFirstChunk(t *testing.T) {
 		MaxSizeChunk:          50,
 		FingerprintBreakPoint: 2245, // this is the hash fingerprint of "If you want to draw "
 	}
-	c := fdiff.NewChunker(rollinghash.NewRabinFingerprint, cfg, b, ch)
+	c := newChunker(t, rollinghash.NewRabinFingerprint, cfg, b, ch)
 
 	// Action
 	c.Start()
@@ -100,9 +99,97 @@ func TestNewChunker_WhenTheFirstWindowIsTheFirstChunk(t *testing.T) {
 			Offset:    0,
 			Data:      []byte("If you want to draw "),
 			Length:    20,
-			Signature: fmt.Sprintf("%x", sha1.Sum([]byte("If you want to draw "))),
+			Signature: signatureOf("If you want to draw "),
 		},
 	}
 
 	assert.Equal(t, expected, actual)
 }
+
+func TestNewChunker_WithManyHashers(t *testing.T) {
+	// SetUp
+	data := randomData(20000, 4)
+	cfg := fdiff.ChunkConfig{
+		WindowSize:            16,
+		MinSizeChunk:          1,
+		MaxSizeChunk:          64,
+		Discriminator:         16,
+		FingerprintBreakPoint: 3,
+	}
+	b := make(chan byte, 1000)
+	ch := make(chan fdiff.Chunk)
+	c := newChunker(t, rollinghash.NewBuzhash, cfg, b, ch)
+	c.Hashers = 8
+
+	// Action
+	c.Start()
+	go func() {
+		for _, d := range data {
+			b <- d
+		}
+		close(b)
+	}()
+
+	// Assert
+	var actual []fdiff.Chunk
+	for chunk := range ch {
+		actual = append(actual, chunk)
+	}
+	var offset uint64
+	for _, chunk := range actual {
+		assert.Equal(t, offset, chunk.Offset)
+		assert.Equal(t, signatureOf(string(chunk.Data)), chunk.Signature)
+		offset += chunk.Length
+	}
+	assert.Equal(t, uint64(len(data)), offset)
+	assert.Greater(t, len(actual), 300)
+}
+
+func TestChunker_StartInPlace(t *testing.T) {
+	// SetUp
+	data := textData(300, 5)
+	cfg := fdiff.ChunkConfig{
+		WindowSize:            16,
+		MinSizeChunk:          64,
+		MaxSizeChunk:          1024,
+		Discriminator:         128,
+		FingerprintBreakPoint: 7,
+	}
+	expected, err := fdiff.ChunkReader(rollinghash.NewRabinFingerprint, cfg, bytes.NewReader(data))
+	assert.Nil(t, err)
+	ch := make(chan fdiff.Chunk, 10)
+	c := newChunker(t, rollinghash.NewRabinFingerprint, cfg, nil, ch)
+
+	// Action
+	c.StartInPlace(data)
+
+	// Assert
+	var actual []fdiff.Chunk
+	for chunk := range ch {
+		actual = append(actual, chunk)
+	}
+	assert.Equal(t, expected, actual)
+	for _, chunk := range actual {
+		// the data of the chunks is not copied
+		assert.True(t, &chunk.Data[0] == &data[chunk.Offset])
+		assert.Equal(t, len(chunk.Data), cap(chunk.Data))
+	}
+}
+
+// newChunker create a Chunker and fail the test when the configuration is invalid.
+func newChunker(tb testing.TB, new func([]byte) rollinghash.Hash, cfg fdiff.ChunkConfig, b chan byte, ch chan fdiff.Chunk) *fdiff.Chunker {
+	c, err := fdiff.NewChunker(new, cfg, b, ch)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// newParallelChunker create a ParallelChunker and fail the test when the configuration is invalid.
+func newParallelChunker(tb testing.TB, new func([]byte) rollinghash.Hash, cfg fdiff.ChunkConfig, jobs int) *fdiff.ParallelChunker {
+	pc, err := fdiff.NewParallelChunker(new, cfg, jobs)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return pc
+}
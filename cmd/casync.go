@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/EmilGeorgiev/fdiff/casync"
+)
+
+// runCasync create and read index files of casync (.caibx):
+//
+//	fdiff casync make [-min N] [-avg N] [-max N] <file> <index-file>
+//	fdiff casync list <index-file>
+func runCasync(args []string) {
+	if len(args) == 0 {
+		printHelp()
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "make":
+		err = casyncMake(args[1:])
+	case "list":
+		err = casyncList(args[1:])
+	default:
+		printHelp()
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// casyncMake split the file to chunks with the chunker of casync and write the index file.
+func casyncMake(args []string) error {
+	fs := flag.NewFlagSet("casync make", flag.ExitOnError)
+	min := fs.Uint64("min", casync.DefaultChunkSizeMin, "the minimum size of the chunks.")
+	avg := fs.Uint64("avg", casync.DefaultChunkSizeAvg, "the average size of the chunks.")
+	max := fs.Uint64("max", casync.DefaultChunkSizeMax, "the maximum size of the chunks.")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		printHelp()
+		return nil
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chunks, err := casync.Chunk(f, *min, *avg, *max)
+	if err != nil {
+		return err
+	}
+	idx, err := casync.NewIndex(chunks, *min, *avg, *max)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err = idx.WriteTo(out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// casyncList print the chunks of an index file.
+func casyncList(args []string) error {
+	if len(args) != 1 {
+		printHelp()
+		return nil
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	idx, err := casync.ReadIndex(f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Chunk sizes: min %d, avg %d, max %d\n", idx.ChunkSizeMin, idx.ChunkSizeAvg, idx.ChunkSizeMax)
+	for _, c := range idx.ChunkList() {
+		fmt.Printf("	- offset: %d, length: %d, hash: %s\n", c.Offset, c.Length, c.Signature)
+	}
+	return nil
+}
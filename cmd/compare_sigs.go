@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/EmilGeorgiev/fdiff"
+)
+
+// runCompareSigs compare two signature files without the data of the files:
+//
+//	fdiff compare-sigs [-show-chunks] <old-signature-file> <new-signature-file>
+func runCompareSigs(args []string) {
+	fs := flag.NewFlagSet("compare-sigs", flag.ExitOnError)
+	showChunks := fs.Bool("show-chunks", false, "print the shared, the added and the removed chunks and the different ranges of chunks.")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		printHelp()
+		return
+	}
+
+	if err := compareSigs(fs.Arg(0), fs.Arg(1), *showChunks); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// compareSigs print the shared, the added and the removed chunks and bytes of the signatures and their similarity.
+// When the roots of the Merkle trees of the signatures are equal, the chunks are not compared. The
+// signatures must be created with the same strong hash.
+func compareSigs(oldSignatureFile, newSignatureFile string, showChunks bool) error {
+	oldHeader, oldChunks, err := readSignature(oldSignatureFile)
+	if err != nil {
+		return err
+	}
+	newHeader, newChunks, err := readSignature(newSignatureFile)
+	if err != nil {
+		return err
+	}
+
+	if oldHeader.StrongHashName() != newHeader.StrongHashName() {
+		return fmt.Errorf("the signatures are created with different strong hashes: %s and %s", oldHeader.StrongHashName(), newHeader.StrongHashName())
+	}
+
+	if oldHeader.DigestLength != newHeader.DigestLength {
+		// the digests are compared with the length of the shorter ones and the merkle roots of the headers can't be compared
+		length := oldHeader.DigestLength
+		if length == 0 || (newHeader.DigestLength != 0 && newHeader.DigestLength < length) {
+			length = newHeader.DigestLength
+		}
+		oldChunks, newChunks = truncateChunks(oldChunks, length), truncateChunks(newChunks, length)
+		oldHeader.MerkleRoot, newHeader.MerkleRoot = "", ""
+	}
+
+	oldTree, newTree := fdiff.NewMerkleTree(oldChunks), fdiff.NewMerkleTree(newChunks)
+	if oldHeader.MerkleRoot == "" {
+		oldHeader.MerkleRoot = oldTree.Root()
+	}
+	if newHeader.MerkleRoot == "" {
+		newHeader.MerkleRoot = newTree.Root()
+	}
+	if oldHeader.MerkleRoot == newHeader.MerkleRoot {
+		fmt.Printf("The signatures are equal, merkle root: %s\n", oldHeader.MerkleRoot)
+		fmt.Printf("Similarity: %.2f%%\n", float64(100))
+		return nil
+	}
+
+	c := fdiff.CompareSignatures(oldChunks, newChunks)
+	fmt.Printf("Shared chunks: %d, bytes: %d\n", len(c.SharedChunks), c.SharedBytes)
+	printChunks(c.SharedChunks, showChunks)
+	fmt.Printf("Added chunks: %d, bytes: %d\n", len(c.AddedChunks), c.AddedBytes)
+	printChunks(c.AddedChunks, showChunks)
+	fmt.Printf("Removed chunks: %d, bytes: %d\n", len(c.RemovedChunks), c.RemovedBytes)
+	printChunks(c.RemovedChunks, showChunks)
+	fmt.Printf("Similarity: %.2f%%\n", c.Similarity())
+
+	ranges, err := fdiff.DiffMerkle(oldTree, newTree)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Different ranges of chunks by their positions: %d\n", len(ranges))
+	if showChunks {
+		for _, r := range ranges {
+			fmt.Printf("	- from: %d, to: %d\n", r.From, r.To)
+		}
+	}
+	return nil
+}
+
+// truncateChunks truncate the digests of the signatures of the chunks to 'length' bytes.
+func truncateChunks(chunks []fdiff.Chunk, length int) []fdiff.Chunk {
+	for i := range chunks {
+		chunks[i].Signature = chunks[i].Signature.Truncate(length)
+	}
+	return chunks
+}
+
+// readSignature return the header and the chunks of the signature file.
+func readSignature(file string) (fdiff.SignatureHeader, []fdiff.Chunk, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return fdiff.SignatureHeader{}, nil, err
+	}
+	defer f.Close()
+
+	return fdiff.DecodeSignature(f)
+}
+
+// printChunks print the offsets, the lengths and the hashes of the chunks if 'show' is true.
+func printChunks(chunks []fdiff.Chunk, show bool) {
+	if !show {
+		return
+	}
+	for _, c := range chunks {
+		fmt.Printf("	- offset: %d, length: %d, hash: %s\n", c.Offset, c.Length, c.Signature)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/EmilGeorgiev/fdiff"
+)
+
+// runDiff find the difference between two local files without a signature file:
+//
+//	fdiff diff [-show-data] [-show-edits] [-patch-file <file>] [-format <format>] [-codec <codec>] [-refine] [-dictionary] <old-file> <new-file>
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	showData := fs.Bool("show-data", false, "print the data in the new chunks")
+	showEdits := fs.Bool("show-edits", false, "print the edit script (KEEP, INSERT, DELETE and MOVE of chunks) that transforms the old file to the new file.")
+	patchFile := fs.String("patch-file", "", "where the patch will be written. By default, no patch is written.")
+	format := fs.String("format", formatFdiff, "the format of the patch: fdiff, vcdiff (RFC 3284) or rdiff (librsync delta).")
+	codec := fs.String("codec", fdiff.CodecDeflate, "with which codec the literal data in the patch is compressed.")
+	refine := fs.Bool("refine", false, "compare the new chunks byte by byte with the old file, so the patch contains only the changed bytes.")
+	dictionary := fs.Bool("dictionary", false, "compress the data in the patch by using the old file as a dictionary. Only the fdiff format supports it.")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		printHelp()
+		return
+	}
+	oldFile, newFile := fs.Arg(0), fs.Arg(1)
+
+	cfg := getConfig()
+	newHash, err := fdiff.RollingHashOf(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := fdiff.DiffFiles(newHash, cfg, oldFile, newFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *refine {
+		if d, err = refineDelta(d, oldFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	printDelta(d, *showData, *showEdits)
+
+	if *patchFile != "" {
+		if err = writePatch(d, *patchFile, *format, *codec, *dictionary, oldFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Patch file is created")
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/EmilGeorgiev/fdiff"
-	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/EmilGeorgiev/fdiff/bsdiff"
+	"github.com/EmilGeorgiev/fdiff/librsync"
+	"github.com/EmilGeorgiev/fdiff/vcdiff"
 	"gopkg.in/yaml.v3"
 )
 
@@ -18,9 +25,59 @@ var oldFile = flag.String("old-file", "", "show for which file the signature wil
 var signatureFile = flag.String("signature-file", "", "show what will be the name of the signature file.")
 var newFile = flag.String("new-file", "", "show the version of the file or the new file for which the command will find the delta.")
 var showDelta = flag.Bool("show-data", false, "print the data in the new chunks")
+var showEdits = flag.Bool("show-edits", false, "print the edit script (KEEP, INSERT, DELETE and MOVE of chunks) that transforms the old file to the new file.")
+var patch = flag.Bool("patch", false, "apply a patch file to the old file and create the new file.")
+var patchFile = flag.String("patch-file", "", "show where the delta will write the patch or from which file the patch will be applied.")
+var refine = flag.Bool("refine", false, "compare the new chunks byte by byte with the old file, so the patch contains only the changed bytes. The old file is set with old-file.")
+var dictionary = flag.Bool("dictionary", false, "compress the data in the patch by using the old file as a dictionary. The old file is set with old-file. Only the fdiff format supports it.")
+var engine = flag.String("engine", engineCDC, "show how the delta is found: cdc (chunks of the signature) or bsdiff (suffix array over the old file, better for executables).")
+var format = flag.String("format", formatFdiff, "show the format of the patch: fdiff, vcdiff (RFC 3284) or rdiff (librsync delta).")
+var codec = flag.String("codec", fdiff.CodecDeflate, "show with which codec the literal data in the patch is compressed.")
+var inPlace = flag.Bool("in-place", false, "apply the patch to the old file in place instead of creating the new file. The progress is recorded in the journal file, so an interrupted patch can be resumed.")
+var journalFile = flag.String("journal-file", "", "show where the progress of an in-place patch is recorded. By default, it is <old-file>.journal.")
+var jobs = flag.Int("jobs", 1, "show how many goroutines split the files to chunks when the signature or the delta is created.")
 var help = flag.Bool("help", false, "describe how to use the tool")
 
+// engines of the delta.
+const (
+	// engineCDC find the delta by comparing the content-defined chunks with the signature.
+	engineCDC = "cdc"
+
+	// engineBsdiff find the delta with bsdiff. It needs the old file instead of the signature.
+	engineBsdiff = "bsdiff"
+)
+
+// formats of the patch.
+const (
+	// formatFdiff is the format of fdiff that supports codecs and dictionary compression.
+	formatFdiff = "fdiff"
+
+	// formatVCDIFF is the format of RFC 3284. It is supported by xdelta3 and open-vcdiff.
+	formatVCDIFF = "vcdiff"
+
+	// formatRdiff is the format of the deltas of rdiff (librsync).
+	formatRdiff = "rdiff"
+)
+
+// commands contains the sub-commands of the tool. They are
+// called with the arguments after the name of the command.
+var commands = map[string]func(args []string){
+	"fetch":        runFetch,
+	"diff":         runDiff,
+	"compare-sigs": runCompareSigs,
+	"rdiff":        runRdiff,
+	"casync":       runCasync,
+	"restic":       runRestic,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if *help {
@@ -28,16 +85,14 @@ func main() {
 		return
 	}
 
-	b := make(chan byte, 1000)
-	ch := make(chan fdiff.Chunk, 1000)
-
 	cfg := getConfig()
-	newHash := rollinghash.NewRabinFingerprint
-
-	chuncker := fdiff.NewChunker(newHash, cfg, b, ch)
-	chuncker.Start()
+	newHash, err := fdiff.RollingHashOf(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	fs := fdiff.NewFileSignerDelta(b, ch)
+	// the files are mapped to the memory and split in place
+	fs := fdiff.NewParallelFileSignerDelta(newHash, cfg, *jobs)
 	if *signature {
 		fmt.Println("Creating a signature of the file: ", *signatureFile)
 		if err := fs.Sign(*oldFile, *signatureFile); err != nil {
@@ -45,26 +100,268 @@ func main() {
 		}
 		fmt.Println("Signature file is created")
 		return
+	} else if *delta && *engine == engineBsdiff {
+		if err := writeBsdiffPatch(*oldFile, *newFile, *patchFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Patch file is created")
 	} else if *delta {
 		d, err := fs.FindDelta(*signatureFile, *newFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println("Old chunks that are updated or removed:")
-		for _, c := range d.OldChunks {
-			fmt.Printf("	- offset: %d, length: %d, hash: %s\n", c.Offset, c.Length, c.Signature)
+		if *oldFile != "" {
+			err = verifyDelta(d, *oldFile)
+			if errors.Is(err, fdiff.ErrChecksumMismatch) {
+				fmt.Println("The delta doesn't create the new file (the truncated digests collide), it is found again with full-length digests")
+				d, err = fdiff.DiffFiles(newHash, cfg, *oldFile, *newFile)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *refine {
+			if d, err = refineDelta(d, *oldFile); err != nil {
+				log.Fatal(err)
+			}
 		}
+		printDelta(d, *showDelta, *showEdits)
 
-		fmt.Println("New chunks that replace the old ones:")
-		for _, c := range d.NewChunks {
-			fmt.Printf("	- offset: %d, length: %d, hash: %s\n", c.Offset, c.Length, c.Signature)
-			if *showDelta {
-				fmt.Printf("	- %s\n", c.Data)
+		if *patchFile != "" {
+			if err = writePatch(d, *patchFile, *format, *codec, *dictionary, *oldFile); err != nil {
+				log.Fatal(err)
 			}
+			fmt.Println("Patch file is created")
 		}
+	} else if *patch && *inPlace {
+		journal := *journalFile
+		if journal == "" {
+			journal = *oldFile + ".journal"
+		}
+		if err := applyPatchInPlace(*oldFile, *patchFile, journal); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Patch is applied")
+	} else if *patch {
+		if err := applyPatch(*oldFile, *patchFile, *newFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Patch is applied")
 	}
 }
 
+// printDelta print the removed and the new chunks of the delta and the changed blocks. If 'showData'
+// is true the data of the new chunks is printed too and if 'showEdits' is true the edit script is printed.
+func printDelta(d fdiff.Delta, showData, showEdits bool) {
+	fmt.Println("Old chunks that are updated or removed:")
+	for _, c := range d.OldChunks {
+		fmt.Printf("	- offset: %d, length: %d, hash: %s\n", c.Offset, c.Length, c.Signature)
+	}
+
+	fmt.Println("New chunks that replace the old ones:")
+	for _, c := range d.NewChunks {
+		fmt.Printf("	- offset: %d, length: %d, hash: %s\n", c.Offset, c.Length, c.Signature)
+		if showData {
+			fmt.Printf("	- %s\n", c.Data)
+		}
+	}
+
+	if len(d.ChangedBlocks) > 0 {
+		fmt.Println("Indexes of the changed blocks:")
+		for _, i := range d.ChangedBlocks {
+			fmt.Printf("	- %d\n", i)
+		}
+	}
+
+	if showEdits {
+		fmt.Println("Edit script:")
+		for _, e := range d.Edits {
+			fmt.Printf("	- %s\n", e)
+		}
+	}
+}
+
+// verifyDelta apply the delta to the old file and compare the result with the checksum of the delta.
+func verifyDelta(d fdiff.Delta, oldFile string) error {
+	old, err := os.Open(oldFile)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	return fdiff.VerifyDelta(d, old)
+}
+
+// refineDelta compare the new chunks of the delta byte by byte with the old file.
+func refineDelta(d fdiff.Delta, oldFile string) (fdiff.Delta, error) {
+	old, err := os.Open(oldFile)
+	if err != nil {
+		return fdiff.Delta{}, err
+	}
+	defer old.Close()
+
+	return fdiff.RefineDelta(d, old)
+}
+
+// writePatch encode the delta as a patch in the file with the format. If 'dictionary' is
+// true the old file is used as a dictionary for the compression of the data in the patch,
+// which is supported only by the fdiff format.
+func writePatch(d fdiff.Delta, file, format, codec string, dictionary bool, oldFile string) error {
+	switch format {
+	case formatFdiff:
+	case formatVCDIFF, formatRdiff:
+		if dictionary {
+			return fmt.Errorf("the format %q doesn't support a dictionary, only %q does", format, formatFdiff)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch {
+	case format == formatVCDIFF:
+		err = vcdiff.Encode(f, d.Ops)
+	case format == formatRdiff:
+		err = librsync.EncodeDelta(f, d.Ops)
+	case dictionary:
+		err = encodeWithDictionary(fdiff.NewPatch(d, codec), f, oldFile)
+	default:
+		err = fdiff.NewPatch(d, codec).Encode(f)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// encodeWithDictionary encode the patch by using the old file as a dictionary.
+func encodeWithDictionary(p fdiff.Patch, w io.Writer, oldFile string) error {
+	old, err := os.Open(oldFile)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	return p.EncodeWithDictionary(w, old)
+}
+
+// writeBsdiffPatch find the delta between the old and the new file with bsdiff and write it in the patch file.
+func writeBsdiffPatch(oldFile, newFile, patchFile string) error {
+	oldData, err := os.ReadFile(oldFile)
+	if err != nil {
+		return err
+	}
+	newData, err := os.ReadFile(newFile)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(patchFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = bsdiff.Diff(oldData, newData, f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// applyPatch apply the patch to the old file and write the result in the new file.
+// The format of the patch (fdiff, bsdiff, vcdiff or rdiff) is recognized by its beginning.
+func applyPatch(oldFile, patchFile, newFile string) error {
+	pf, err := os.Open(patchFile)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	r := bufio.NewReader(pf)
+
+	old, err := os.Open(oldFile)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	f, err := os.Create(newFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if magic, _ := r.Peek(len(bsdiff.Magic)); string(magic) == bsdiff.Magic {
+		oldData, err := io.ReadAll(old)
+		if err != nil {
+			return err
+		}
+		err = bsdiff.Patch(oldData, r, f)
+	} else if magic, _ = r.Peek(len(vcdiff.Magic)); bytes.Equal(magic, vcdiff.Magic) {
+		var ops []fdiff.Op
+		if ops, err = vcdiff.Decode(r, old); err == nil {
+			err = fdiff.Patch{Ops: ops}.Apply(old, f)
+		}
+	} else if magic, _ = r.Peek(4); bytes.Equal(magic, rdiffDeltaMagic) {
+		var ops []fdiff.Op
+		if ops, err = librsync.DecodeDelta(r); err == nil {
+			err = fdiff.Patch{Ops: ops}.Apply(old, f)
+		}
+	} else {
+		var p fdiff.Patch
+		if p, err = fdiff.DecodePatchWithDictionary(r, old); err == nil {
+			err = p.Apply(old, f)
+		}
+		if errors.Is(err, fdiff.ErrChecksumMismatch) {
+			err = fmt.Errorf("%w (create the signature with full-length digests: digest_length and collision_bits 0)", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// applyPatchInPlace apply the patch to the old file in place. The progress is recorded in the journal file. The
+// format of the patch (fdiff, vcdiff or rdiff) is recognized by its beginning; bsdiff patches are not supported.
+func applyPatchInPlace(oldFile, patchFile, journalFile string) error {
+	pf, err := os.Open(patchFile)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	r := bufio.NewReader(pf)
+
+	// the patch is decoded before the old file is changed, because its data can be compressed with the old file
+	old, err := os.Open(oldFile)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	var p fdiff.Patch
+	if magic, _ := r.Peek(len(bsdiff.Magic)); string(magic) == bsdiff.Magic {
+		return errors.New("bsdiff patches can't be applied in place")
+	} else if magic, _ = r.Peek(len(vcdiff.Magic)); bytes.Equal(magic, vcdiff.Magic) {
+		p.Ops, err = vcdiff.Decode(r, old)
+	} else if magic, _ = r.Peek(4); bytes.Equal(magic, rdiffDeltaMagic) {
+		p.Ops, err = librsync.DecodeDelta(r)
+	} else {
+		p, err = fdiff.DecodePatchWithDictionary(r, old)
+	}
+	if err != nil {
+		return err
+	}
+	return p.ApplyInPlace(oldFile, journalFile)
+}
+
+// rdiffDeltaMagic is the beginning of an rdiff delta.
+var rdiffDeltaMagic = []byte{0x72, 0x73, 0x02, 0x36}
+
 func getConfig() fdiff.ChunkConfig {
 	data, err := os.ReadFile("config.yaml")
 	if err != nil {
@@ -76,21 +373,73 @@ func getConfig() fdiff.ChunkConfig {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if _, err = fdiff.GetStrongHash(config.StrongHash); err != nil {
+		log.Fatal(err)
+	}
 
 	return config
 }
 
 func printHelp() {
 	fmt.Println("Usage:")
-	fmt.Println("	fdiff -signature=true -old-file <name-of-file> -signature-file <name-of-sign-file>")
-	fmt.Println("	fdiff -delta=true -signature-file <name-of-sign-file> -new-file <name-of_new-file>")
+	fmt.Println("	fdiff -signature=true -old-file <name-of-file> -signature-file <name-of-sign-file> [-jobs N]")
+	fmt.Println("	fdiff -delta=true -signature-file <name-of-sign-file> -new-file <name-of_new-file> [-show-edits=true] [-patch-file <name-of-patch-file>] [-format <format>] [-codec <codec>] [-refine=true] [-dictionary=true] [-old-file <name-of-file>] [-jobs N]")
+	fmt.Println("	fdiff -delta=true -engine bsdiff -old-file <name-of-file> -new-file <name-of_new-file> -patch-file <name-of-patch-file>")
+	fmt.Println("	fdiff -patch=true -old-file <name-of-file> -patch-file <name-of-patch-file> -new-file <name-of_new-file>")
+	fmt.Println("	fdiff -patch=true -in-place=true -old-file <name-of-file> -patch-file <name-of-patch-file> [-journal-file <name-of-journal-file>]")
+	fmt.Println("	fdiff diff [-show-data=true] [-show-edits=true] [-patch-file <name-of-patch-file>] [-format <format>] [-codec <codec>] [-refine=true] [-dictionary=true] <old-file> <new-file>")
+	fmt.Println("	fdiff compare-sigs [-show-chunks=true] <old-signature-file> <new-signature-file>")
+	fmt.Println("	fdiff rdiff signature [-block-size N] [-sum-size N] [-hash md4|blake2] [-rollsum rollsum|rabinkarp] <old-file> <signature-file>")
+	fmt.Println("	fdiff rdiff delta <signature-file> <new-file> <delta-file>")
+	fmt.Println("	fdiff rdiff patch <old-file> <delta-file> <new-file>")
+	fmt.Println("	fdiff casync make [-min N] [-avg N] [-max N] <file> <index-file>")
+	fmt.Println("	fdiff casync list <index-file>")
+	fmt.Println("	fdiff restic list -polynomial <hex> <file>")
+	fmt.Println("	fdiff fetch [-local-file <name-of-file>] [-out-file <name-of-file>] [-signature-url <url>] [-seed <file-or-dir>]... <url>")
 
 	fmt.Println("Flags:")
 	fmt.Println("	- signature - create a signature file of a file.")
 	fmt.Println("	- delta - find the difference between two files or two versions of the file.")
-	fmt.Println("	- old-file - show for which file the signature will be created.")
+	fmt.Println("	- old-file - show for which file the signature will be created. With delta the delta is verified with the old file and when the truncated digests of the signature collide, it is found again with full-length digests.")
 	fmt.Println("	- signature-file - show what will be the name of the signature file.")
 	fmt.Println("	- new-file - show the version of the file or the new file for which the command will find the delta.")
 	fmt.Println("	- show-data - print the data in the new chunks.")
+	fmt.Println("	- show-edits - print the edit script (KEEP, INSERT, DELETE and MOVE of chunks) that transforms the old file to the new file.")
+	fmt.Println("	- patch - apply a patch file to the old file and create the new file.")
+	fmt.Println("	- patch-file - show where the delta will write the patch or from which file the patch will be applied.")
+	fmt.Println("	- format - show the format of the patch: fdiff, vcdiff (RFC 3284) or rdiff (librsync delta).")
+	fmt.Println("	- engine - show how the delta is found: cdc (chunks of the signature) or bsdiff (suffix array over the old file, better for executables).")
+	fmt.Println("	- refine - compare the new chunks byte by byte with the old file, so the patch contains only the changed bytes. The old file is set with old-file.")
+	fmt.Println("	- dictionary - compress the data in the patch by using the old file as a dictionary. The old file is set with old-file. Only the fdiff format supports it.")
+	fmt.Println("	- in-place - apply the patch to the old file in place instead of creating the new file. The progress is recorded in the journal file, so an interrupted patch can be resumed.")
+	fmt.Println("	- journal-file - show where the progress of an in-place patch is recorded. By default, it is <old-file>.journal.")
+	fmt.Printf("	- codec - show with which codec the literal data in the patch is compressed (%s).\n", strings.Join(fdiff.CodecNames(), ", "))
+	fmt.Println("	- jobs - show how many goroutines split the files to chunks when the signature or the delta is created. The chunks are the same for any number of jobs. By default, it is 1.")
 	fmt.Println("	- help - describe how to use the tool.")
+
+	fmt.Println("Flags of diff:")
+	fmt.Println("	- show-data, show-edits, patch-file, format, codec, refine and dictionary - the same as the flags of delta.")
+
+	fmt.Println("Flags of compare-sigs:")
+	fmt.Println("	- show-chunks - print the shared, the added and the removed chunks and the different ranges of chunks.")
+
+	fmt.Println("Flags of rdiff signature:")
+	fmt.Println("	- block-size - the length of the blocks. By default, it is 2048.")
+	fmt.Println("	- sum-size - the length of the strong checksums. By default, it is the full length of the hash.")
+	fmt.Println("	- hash - the strong hash: md4 or blake2. By default, it is blake2.")
+	fmt.Println("	- rollsum - the weak rolling checksum: rollsum or rabinkarp. By default, it is rabinkarp.")
+
+	fmt.Println("Flags of casync make:")
+	fmt.Println("	- min - the minimum size of the chunks. By default, it is 16384.")
+	fmt.Println("	- avg - the average size of the chunks. By default, it is 65536.")
+	fmt.Println("	- max - the maximum size of the chunks. By default, it is 262144.")
+
+	fmt.Println("Flags of restic list:")
+	fmt.Println("	- polynomial - the chunker polynomial of the repository in hex (chunker_polynomial in the config of the repository).")
+
+	fmt.Println("Flags of fetch:")
+	fmt.Println("	- local-file - the local stale copy of the file. By default, it is the last element of the url.")
+	fmt.Println("	- out-file - where the fetched file will be stored. By default, it is the local file.")
+	fmt.Println("	- signature-url - the url of the signature of the file. By default, it is <url>.sig.")
+	fmt.Println("	- seed - a file or a directory with files from which chunks can be reused. It can be set several times.")
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/EmilGeorgiev/fdiff"
+)
+
+// runFetch download a file from an HTTP server by reusing the chunks of the local stale copy.
+func runFetch(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	localFile := fs.String("local-file", "", "the local stale copy of the file. By default, it is the last element of the url.")
+	outFile := fs.String("out-file", "", "where the fetched file will be stored. By default, it is the local file.")
+	signatureURL := fs.String("signature-url", "", "the url of the signature of the file. By default, it is <url>.sig.")
+	var seeds stringList
+	fs.Var(&seeds, "seed", "a file or a directory with files from which chunks can be reused. It can be set several times.")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		printHelp()
+		return
+	}
+	url := fs.Arg(0)
+	if *localFile == "" {
+		*localFile = path.Base(url)
+	}
+	if *outFile == "" {
+		*outFile = *localFile
+	}
+	if *signatureURL == "" {
+		*signatureURL = url + ".sig"
+	}
+
+	cfg := getConfig()
+	newHash, err := fdiff.RollingHashOf(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f := fdiff.NewFetcher(http.DefaultClient, newHash, cfg)
+	stats, err := f.Fetch(url, *signatureURL, *outFile, append([]string{*localFile}, seeds...))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if stats.Retried {
+		fmt.Println("The truncated digests of the signature collide with local chunks, the file is fetched again with the full-length signature <signature-url>.full or downloaded again when it doesn't exist")
+	}
+	fmt.Printf("Reused bytes: %d, downloaded bytes: %d with %d requests\n", stats.ReusedBytes, stats.DownloadedBytes, stats.Requests)
+	if stats.HoleBytes > 0 {
+		fmt.Printf("Bytes of holes that are recreated without downloading: %d\n", stats.HoleBytes)
+	}
+}
+
+// stringList is a flag that can be set several times. Every value is added to the list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/librsync"
+)
+
+// runRdiff create signatures and deltas and apply deltas in the formats of rdiff (librsync).
+// The arguments are the same as the arguments of rdiff:
+//
+//	fdiff rdiff signature [-block-size N] [-sum-size N] [-hash md4|blake2] [-rollsum rollsum|rabinkarp] <old-file> <signature-file>
+//	fdiff rdiff delta <signature-file> <new-file> <delta-file>
+//	fdiff rdiff patch <old-file> <delta-file> <new-file>
+func runRdiff(args []string) {
+	if len(args) == 0 {
+		printHelp()
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "signature":
+		err = rdiffSignature(args[1:])
+	case "delta":
+		err = rdiffDelta(args[1:])
+	case "patch":
+		err = rdiffPatch(args[1:])
+	default:
+		printHelp()
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// rdiffSignature create an rdiff signature of a file.
+func rdiffSignature(args []string) error {
+	fs := flag.NewFlagSet("rdiff signature", flag.ExitOnError)
+	blockSize := fs.Uint("block-size", librsync.DefaultBlockLength, "the length of the blocks.")
+	sumSize := fs.Uint("sum-size", 0, "the length of the strong checksums. By default, it is the full length of the hash.")
+	hash := fs.String("hash", "blake2", "the strong hash: md4 or blake2.")
+	rollsum := fs.String("rollsum", "rabinkarp", "the weak rolling checksum: rollsum or rabinkarp.")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		printHelp()
+		return nil
+	}
+
+	magic, err := rdiffMagic(*hash, *rollsum)
+	if err != nil {
+		return err
+	}
+	old, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	sig, err := librsync.Sign(old, magic, uint32(*blockSize), uint32(*sumSize))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = sig.WriteTo(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// rdiffMagic return the type of the signature with the strong hash and the weak checksum.
+func rdiffMagic(hash, rollsum string) (librsync.Magic, error) {
+	switch {
+	case hash == "md4" && rollsum == "rollsum":
+		return librsync.MD4SigMagic, nil
+	case hash == "md4" && rollsum == "rabinkarp":
+		return librsync.RKMD4SigMagic, nil
+	case hash == "blake2" && rollsum == "rollsum":
+		return librsync.Blake2SigMagic, nil
+	case hash == "blake2" && rollsum == "rabinkarp":
+		return librsync.RKBlake2SigMagic, nil
+	default:
+		return 0, fmt.Errorf("unknown hash %q or rollsum %q", hash, rollsum)
+	}
+}
+
+// rdiffDelta create an rdiff delta from an rdiff signature and a new file.
+func rdiffDelta(args []string) error {
+	if len(args) != 3 {
+		printHelp()
+		return nil
+	}
+
+	sf, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	sig, err := librsync.ReadSignature(sf)
+	if err != nil {
+		return err
+	}
+
+	nf, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer nf.Close()
+	ops, err := librsync.ComputeDelta(sig, nf)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(args[2])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = librsync.EncodeDelta(f, ops); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// rdiffPatch apply an rdiff delta to the old file and write the result in the new file.
+func rdiffPatch(args []string) error {
+	if len(args) != 3 {
+		printHelp()
+		return nil
+	}
+
+	df, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	ops, err := librsync.DecodeDelta(bufio.NewReader(df))
+	if err != nil {
+		return err
+	}
+
+	old, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	f, err := os.Create(args[2])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = (fdiff.Patch{Ops: ops}).Apply(old, f); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/EmilGeorgiev/fdiff/restic"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+)
+
+// runRestic print the chunks that restic creates from a file with the polynomial of a repository:
+//
+//	fdiff restic list -polynomial <hex> <file>
+func runRestic(args []string) {
+	if len(args) == 0 || args[0] != "list" {
+		printHelp()
+		return
+	}
+
+	fs := flag.NewFlagSet("restic list", flag.ExitOnError)
+	polynomial := fs.String("polynomial", "", "the chunker polynomial of the repository in hex (chunker_polynomial in the config of the repository).")
+	_ = fs.Parse(args[1:])
+	if fs.NArg() != 1 || *polynomial == "" {
+		printHelp()
+		return
+	}
+
+	if err := resticList(*polynomial, fs.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// resticList print the offsets, the lengths and the IDs of the blobs of the chunks of the file.
+func resticList(polynomial, file string) error {
+	pol, err := rollinghash.ParsePol(polynomial)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chunks, err := restic.Chunk(f, pol)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		fmt.Printf("	- offset: %d, length: %d, id: %s\n", c.Offset, c.Length, restic.ID(c))
+	}
+	return nil
+}
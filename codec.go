@@ -0,0 +1,148 @@
+package fdiff
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Codec compresses and decompresses the literal data of a patch.
+type Codec interface {
+	// Name return the name of the codec. It is stored in the header of
+	// the patch, so the patch can be decompressed when it is applied.
+	Name() string
+
+	// Compress compress the data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress decompress the data. The parameter 'size' is the number
+	// of bytes of the data before it was compressed.
+	Decompress(data []byte, size int) ([]byte, error)
+}
+
+const (
+	// CodecNone is the name of the codec that does not compress the data.
+	CodecNone = "none"
+
+	// CodecDeflate is the name of the codec that compress the data with DEFLATE.
+	CodecDeflate = "deflate"
+
+	// CodecGzip is the name of the codec that compress the data with gzip.
+	CodecGzip = "gzip"
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(noneCodec{})
+	RegisterCodec(deflateCodec{})
+	RegisterCodec(gzipCodec{})
+}
+
+// RegisterCodec make the codec available for creating and applying patches.
+// If a codec with the same name is already registered it is replaced.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// GetCodec return the registered codec with the name.
+func GetCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// CodecNames return the names of all registered codecs sorted alphabetically.
+func CodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	var names []string
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// noneCodec does not compress the data.
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return CodecNone }
+
+func (noneCodec) Compress(data []byte) ([]byte, error) { return data, nil }
+
+func (noneCodec) Decompress(data []byte, _ int) ([]byte, error) { return data, nil }
+
+// deflateCodec compress the data with DEFLATE (RFC 1951).
+type deflateCodec struct{}
+
+func (deflateCodec) Name() string { return CodecDeflate }
+
+func (deflateCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCodec) Decompress(data []byte, size int) ([]byte, error) {
+	return readAll(flate.NewReader(bytes.NewReader(data)), size)
+}
+
+// gzipCodec compress the data with gzip (RFC 1952).
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return CodecGzip }
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte, size int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return readAll(r, size)
+}
+
+// readAll read exactly 'size' bytes from the reader and close it.
+func readAll(r io.ReadCloser, size int) ([]byte, error) {
+	defer r.Close()
+	if size < 0 {
+		return nil, fmt.Errorf("the size of the data %d is negative", size)
+	}
+	return readFull(r, uint64(size))
+}
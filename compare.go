@@ -0,0 +1,74 @@
+package fdiff
+
+// SignatureComparison is the difference between two signatures. It is found only from the chunks
+// of the signatures, without the data of the files. A chunk that is found several times is counted
+// as many times as it is found, so for example when a chunk is found 3 times in the old signature and
+// 2 times in the new one, 2 of its occurrences are shared and 1 is removed.
+type SignatureComparison struct {
+	// SharedChunks contains the chunks of the new signature that are found in the old signature too.
+	SharedChunks []Chunk
+
+	// AddedChunks contains the chunks of the new signature that are not found in the old signature.
+	AddedChunks []Chunk
+
+	// RemovedChunks contains the chunks of the old signature that are not found in the new signature.
+	RemovedChunks []Chunk
+
+	// SharedBytes, AddedBytes and RemovedBytes are the numbers of bytes in the chunks.
+	SharedBytes  uint64
+	AddedBytes   uint64
+	RemovedBytes uint64
+}
+
+// Similarity return how similar are the signatures in percent. It is the number of the shared bytes
+// in both signatures divided by the number of all bytes in both signatures (Sørensen–Dice coefficient).
+// Two empty signatures are equal.
+func (c SignatureComparison) Similarity() float64 {
+	total := 2*c.SharedBytes + c.AddedBytes + c.RemovedBytes
+	if total == 0 {
+		return 100
+	}
+	return float64(2*c.SharedBytes) * 100 / float64(total)
+}
+
+// CompareSignatures compare the chunks of an old and a new signature. The chunks are matched by their
+// signatures and lengths. The shared and the added chunks are in the order of the new signature and
+// the removed chunks are in the order of the old signature.
+func CompareSignatures(oldChunks, newChunks []Chunk) SignatureComparison {
+	type key struct {
+		signature Signature
+		length    uint64
+	}
+	available := map[key]int{}
+	for _, ch := range oldChunks {
+		available[key{ch.Signature, ch.Length}]++
+	}
+
+	var c SignatureComparison
+	for _, ch := range newChunks {
+		k := key{ch.Signature, ch.Length}
+		if available[k] > 0 {
+			available[k]--
+			c.SharedChunks = append(c.SharedChunks, ch)
+			c.SharedBytes += ch.Length
+			continue
+		}
+		c.AddedChunks = append(c.AddedChunks, ch)
+		c.AddedBytes += ch.Length
+	}
+
+	// the last occurrences of the old chunks that are not shared are removed
+	for i := len(oldChunks) - 1; i >= 0; i-- {
+		ch := oldChunks[i]
+		k := key{ch.Signature, ch.Length}
+		if available[k] > 0 {
+			available[k]--
+			c.RemovedChunks = append(c.RemovedChunks, ch)
+			c.RemovedBytes += ch.Length
+		}
+	}
+	for i, j := 0, len(c.RemovedChunks)-1; i < j; i, j = i+1, j-1 {
+		c.RemovedChunks[i], c.RemovedChunks[j] = c.RemovedChunks[j], c.RemovedChunks[i]
+	}
+	return c
+}
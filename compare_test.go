@@ -0,0 +1,83 @@
+package fdiff_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareSignatures(t *testing.T) {
+	// SetUp
+	oldChunks := []fdiff.Chunk{
+		{Offset: 0, Length: 10, Signature: signatureOf("a")},
+		{Offset: 10, Length: 20, Signature: signatureOf("b")},
+		{Offset: 30, Length: 10, Signature: signatureOf("a")},
+		{Offset: 40, Length: 30, Signature: signatureOf("c")},
+	}
+	newChunks := []fdiff.Chunk{
+		{Offset: 0, Length: 20, Signature: signatureOf("b")},
+		{Offset: 20, Length: 10, Signature: signatureOf("a")},
+		{Offset: 30, Length: 40, Signature: signatureOf("d")},
+		{Offset: 70, Length: 20, Signature: signatureOf("b")},
+	}
+
+	// Action
+	actual := fdiff.CompareSignatures(oldChunks, newChunks)
+
+	// Assert
+	expected := fdiff.SignatureComparison{
+		SharedChunks:  []fdiff.Chunk{newChunks[0], newChunks[1]},
+		AddedChunks:   []fdiff.Chunk{newChunks[2], newChunks[3]},
+		RemovedChunks: []fdiff.Chunk{oldChunks[2], oldChunks[3]},
+		SharedBytes:   30,
+		AddedBytes:    60,
+		RemovedBytes:  40,
+	}
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, 37.5, actual.Similarity())
+}
+
+func TestCompareSignatures_WhenSignaturesAreEqual(t *testing.T) {
+	// SetUp
+	chunks := []fdiff.Chunk{
+		{Offset: 0, Length: 10, Signature: signatureOf("a")},
+		{Offset: 10, Length: 10, Signature: signatureOf("a")},
+	}
+
+	// Action
+	actual := fdiff.CompareSignatures(chunks, chunks)
+
+	// Assert
+	assert.Equal(t, chunks, actual.SharedChunks)
+	assert.Empty(t, actual.AddedChunks)
+	assert.Empty(t, actual.RemovedChunks)
+	assert.Equal(t, float64(100), actual.Similarity())
+	assert.Equal(t, float64(100), fdiff.CompareSignatures(nil, nil).Similarity())
+}
+
+func TestCompareSignatures_OfSignatureFiles(t *testing.T) {
+	// SetUp
+	cfg := testChunkConfig
+	cfg.Discriminator = 1024
+	dir := t.TempDir()
+	oldData := textData(3000, 1)
+	newData := append(oldData[:40000:40000], append(textData(500, 9), oldData[40000:]...)...)
+	writeFile(t, filepath.Join(dir, "old"), oldData)
+	writeFile(t, filepath.Join(dir, "new"), newData)
+	signWithChunker(t, filepath.Join(dir, "old"), filepath.Join(dir, "old.sig"), cfg)
+	signWithChunker(t, filepath.Join(dir, "new"), filepath.Join(dir, "new.sig"), cfg)
+	_, oldChunks, _ := fdiff.DecodeSignature(strings.NewReader(readFile(filepath.Join(dir, "old.sig"))))
+	_, newChunks, _ := fdiff.DecodeSignature(strings.NewReader(readFile(filepath.Join(dir, "new.sig"))))
+
+	// Action
+	actual := fdiff.CompareSignatures(oldChunks, newChunks)
+
+	// Assert
+	assert.Equal(t, uint64(len(oldData)), actual.SharedBytes+actual.RemovedBytes)
+	assert.Equal(t, uint64(len(newData)), actual.SharedBytes+actual.AddedBytes)
+	assert.Less(t, actual.AddedBytes, uint64(len(newData)-len(oldData))*2)
+	assert.Greater(t, actual.Similarity(), float64(80))
+}
@@ -0,0 +1,91 @@
+package fdiff
+
+import (
+	"os"
+
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+)
+
+// DiffFiles find the difference between two local files without a signature file. Both files
+// are split to chunks at the same time by two Chunkers and the chunks are matched in memory
+// like FindDelta matches the chunks of the new file with the chunks of the signature.
+//
+// First the sizes and the checksums of the files are compared. When the files are equal they
+// are not split to chunks and the delta contains only one operation that copies the whole old
+// file, without NewChunks, OldChunks, Edits and ChangedBlocks. The signatures of the chunks are never
+// truncated.
+func DiffFiles(new func([]byte) rollinghash.Hash, cfg ChunkConfig, oldFile, newFile string) (Delta, error) {
+	header, equal, err := equalFiles(oldFile, newFile)
+	if err != nil {
+		return Delta{}, err
+	}
+	if equal {
+		d := Delta{Checksum: header.FileChecksum}
+		if header.FileSize > 0 {
+			d.addOp(Op{Type: OpCopy, SourceOffset: 0, Length: header.FileSize})
+		}
+		return d, nil
+	}
+
+	type result struct {
+		chunks []Chunk
+		mapped *mappedFile
+		err    error
+	}
+	oldResult := make(chan result, 1)
+	newResult := make(chan result, 1)
+	go func() {
+		chunks, mapped, err := chunkFile(new, cfg, oldFile)
+		oldResult <- result{chunks: chunks, mapped: mapped, err: err}
+	}()
+	go func() {
+		chunks, mapped, err := chunkFile(new, cfg, newFile)
+		newResult <- result{chunks: chunks, mapped: mapped, err: err}
+	}()
+	o, n := <-oldResult, <-newResult
+	defer o.mapped.Close()
+	defer n.mapped.Close()
+	if o.err != nil {
+		return Delta{}, o.err
+	}
+	if n.err != nil {
+		return Delta{}, n.err
+	}
+
+	// the data of the old chunks is not needed, like it is not stored in the signature
+	for i := range o.chunks {
+		o.chunks[i].Data = nil
+	}
+	chunks := make(chan Chunk, len(n.chunks))
+	for _, ch := range n.chunks {
+		chunks <- ch
+	}
+	close(chunks)
+	return n.mapped.release(deltaOfChunks(o.chunks, chunks, 0, blockLayoutOf(cfg))), nil
+}
+
+// equalFiles return whether the files have the same sizes and checksums. When they are
+// equal the header of the files with their size and checksum is returned too.
+func equalFiles(oldFile, newFile string) (SignatureHeader, bool, error) {
+	oldInfo, err := os.Stat(oldFile)
+	if err != nil {
+		return SignatureHeader{}, false, err
+	}
+	newInfo, err := os.Stat(newFile)
+	if err != nil {
+		return SignatureHeader{}, false, err
+	}
+	if oldInfo.Size() != newInfo.Size() {
+		return SignatureHeader{}, false, nil
+	}
+
+	oldHeader, err := createSignatureHeader(oldFile)
+	if err != nil {
+		return SignatureHeader{}, false, err
+	}
+	newHeader, err := createSignatureHeader(newFile)
+	if err != nil {
+		return SignatureHeader{}, false, err
+	}
+	return oldHeader, oldHeader == newHeader, nil
+}
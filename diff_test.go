@@ -0,0 +1,85 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffFiles(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	oldData := textData(3000, 1)
+	newData := append(oldData[:40000:40000], append([]byte(strings.Repeat("a new line of the log\n", 500)), oldData[60000:]...)...)
+	writeFile(t, filepath.Join(dir, "old"), oldData)
+	writeFile(t, filepath.Join(dir, "new"), newData)
+
+	// Action
+	actual, err := fdiff.DiffFiles(rollinghash.NewRabinFingerprint, testChunkConfig, filepath.Join(dir, "old"), filepath.Join(dir, "new"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, findDeltaWithChunker(t, oldData, newData, testChunkConfig), actual)
+	var patched bytes.Buffer
+	assert.Nil(t, fdiff.Patch{Ops: actual.Ops}.Apply(bytes.NewReader(oldData), &patched))
+	assert.Equal(t, newData, patched.Bytes())
+}
+
+func TestDiffFiles_WhenFilesAreEqual(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data := textData(3000, 2)
+	writeFile(t, filepath.Join(dir, "old"), data)
+	writeFile(t, filepath.Join(dir, "new"), data)
+
+	// Action
+	actual, err := fdiff.DiffFiles(rollinghash.NewRabinFingerprint, testChunkConfig, filepath.Join(dir, "old"), filepath.Join(dir, "new"))
+
+	// Assert
+	expected := fdiff.Delta{
+		Ops:      []fdiff.Op{{Type: fdiff.OpCopy, SourceOffset: 0, Length: uint64(len(data))}},
+		Checksum: fmt.Sprintf("%x", sha1.Sum(data)),
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestDiffFiles_WhenFilesHaveTheSameSize(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	oldData := textData(3000, 3)
+	newData := append([]byte{}, oldData...)
+	newData[50000] ^= 0xff
+	writeFile(t, filepath.Join(dir, "old"), oldData)
+	writeFile(t, filepath.Join(dir, "new"), newData)
+
+	// Action
+	actual, err := fdiff.DiffFiles(rollinghash.NewRabinFingerprint, testChunkConfig, filepath.Join(dir, "old"), filepath.Join(dir, "new"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.NotEmpty(t, actual.NewChunks)
+	var patched bytes.Buffer
+	assert.Nil(t, fdiff.Patch{Ops: actual.Ops}.Apply(bytes.NewReader(oldData), &patched))
+	assert.Equal(t, newData, patched.Bytes())
+}
+
+func TestDiffFiles_WhenFileDoesNotExist(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "old"), []byte("old data"))
+
+	// Action
+	_, err := fdiff.DiffFiles(rollinghash.NewRabinFingerprint, testChunkConfig, filepath.Join(dir, "old"), filepath.Join(dir, "new"))
+
+	// Assert
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,281 @@
+package fdiff
+
+import "fmt"
+
+// EditType is the type of an edit of the edit script.
+type EditType int
+
+const (
+	// EditKeep means that the chunk of the old data is at the same relative position in the new data.
+	EditKeep EditType = iota + 1
+
+	// EditInsert means that the chunk of the new data is not found in the old data.
+	EditInsert
+
+	// EditDelete means that the chunk of the old data is not found in the new data.
+	EditDelete
+
+	// EditMove means that the chunk of the old data is found in the new data, but at another
+	// relative position. For example, when two chunks are swapped, one of them is kept and the
+	// other is moved.
+	EditMove
+)
+
+// String return the name of the edit.
+func (t EditType) String() string {
+	switch t {
+	case EditKeep:
+		return "KEEP"
+	case EditInsert:
+		return "INSERT"
+	case EditDelete:
+		return "DELETE"
+	case EditMove:
+		return "MOVE"
+	default:
+		return fmt.Sprintf("EditType(%d)", int(t))
+	}
+}
+
+// Edit is one edit of the edit script. Every edit is for one chunk.
+type Edit struct {
+	Type EditType
+
+	// OldOffset is the offset of the chunk in the old data. It is used by KEEP, DELETE and MOVE.
+	OldOffset uint64
+
+	// NewOffset is the offset of the chunk in the new data. It is used by KEEP, INSERT and MOVE.
+	NewOffset uint64
+
+	// Length is the number of bytes in the chunk.
+	Length uint64
+
+	// Signature is the signature of the chunk.
+	Signature Signature
+}
+
+// String return string representation of the edit.
+func (e Edit) String() string {
+	switch e.Type {
+	case EditInsert:
+		return fmt.Sprintf("%s new offset: %d, length: %d, hash: %s", e.Type, e.NewOffset, e.Length, e.Signature)
+	case EditDelete:
+		return fmt.Sprintf("%s old offset: %d, length: %d, hash: %s", e.Type, e.OldOffset, e.Length, e.Signature)
+	default:
+		return fmt.Sprintf("%s old offset: %d, new offset: %d, length: %d, hash: %s", e.Type, e.OldOffset, e.NewOffset, e.Length, e.Signature)
+	}
+}
+
+// maxLCSCells limits the size of the table of the longest common subsequence of two regions
+// without unique chunks. When a region is bigger, its chunks are not aligned, but the equal
+// chunks in it are still found as moved.
+const maxLCSCells = 1 << 22
+
+// EditScript return the edits that transform the sequence of the old chunks to the sequence
+// of the new chunks. The chunks are aligned with patience diff: the common prefix and suffix
+// are kept, the chunks that are unique in both sequences are aligned with the longest increasing
+// subsequence and the regions between them are aligned recursively. The regions without unique
+// chunks are aligned with the longest common subsequence. The new chunks that are not aligned,
+// but are equal to old chunks that are not aligned either, are moved.
+//
+// The edits are in the order of the new chunks. The deleted old chunks are placed before
+// the new chunks that replace them.
+func EditScript(oldChunks, newChunks []Chunk) []Edit {
+	pairs := alignChunks(oldChunks, newChunks, 0, len(oldChunks), 0, len(newChunks), nil)
+
+	matchedOld := make([]bool, len(oldChunks))
+	for _, p := range pairs {
+		matchedOld[p.old] = true
+	}
+
+	// the old chunks that are not aligned, in their order, by signature
+	unmatched := map[Signature][]int{}
+	for i, c := range oldChunks {
+		if !matchedOld[i] {
+			unmatched[c.Signature] = append(unmatched[c.Signature], i)
+		}
+	}
+
+	// the old chunks that are moved and the new chunk to which every one of them is moved
+	moved := make([]bool, len(oldChunks))
+	moveOf := map[int]int{}
+	p := 0
+	for j, c := range newChunks {
+		if p < len(pairs) && pairs[p].new == j {
+			p++
+			continue
+		}
+		if olds := unmatched[c.Signature]; len(olds) > 0 {
+			unmatched[c.Signature] = olds[1:]
+			moved[olds[0]] = true
+			moveOf[j] = olds[0]
+		}
+	}
+
+	var edits []Edit
+	i, j := 0, 0
+	for _, p := range append(pairs, chunkPair{old: len(oldChunks), new: len(newChunks)}) {
+		for ; i < p.old; i++ {
+			if !moved[i] {
+				c := oldChunks[i]
+				edits = append(edits, Edit{Type: EditDelete, OldOffset: c.Offset, Length: c.Length, Signature: c.Signature})
+			}
+		}
+		for ; j < p.new; j++ {
+			c := newChunks[j]
+			if o, ok := moveOf[j]; ok {
+				edits = append(edits, Edit{Type: EditMove, OldOffset: oldChunks[o].Offset, NewOffset: c.Offset, Length: c.Length, Signature: c.Signature})
+				continue
+			}
+			edits = append(edits, Edit{Type: EditInsert, NewOffset: c.Offset, Length: c.Length, Signature: c.Signature})
+		}
+		if p.old < len(oldChunks) {
+			c := newChunks[p.new]
+			edits = append(edits, Edit{Type: EditKeep, OldOffset: oldChunks[p.old].Offset, NewOffset: c.Offset, Length: c.Length, Signature: c.Signature})
+			i, j = p.old+1, p.new+1
+		}
+	}
+	return edits
+}
+
+// chunkPair is an old and a new chunk that are aligned. They are indexes in the sequences.
+type chunkPair struct {
+	old, new int
+}
+
+// alignChunks add to 'pairs' the aligned chunks of the regions [oLo, oHi) of the old chunks
+// and [nLo, nHi) of the new chunks in increasing order.
+func alignChunks(oldChunks, newChunks []Chunk, oLo, oHi, nLo, nHi int, pairs []chunkPair) []chunkPair {
+	for oLo < oHi && nLo < nHi && oldChunks[oLo].Signature == newChunks[nLo].Signature {
+		pairs = append(pairs, chunkPair{old: oLo, new: nLo})
+		oLo, nLo = oLo+1, nLo+1
+	}
+	suffix := 0
+	for oLo < oHi-suffix && nLo < nHi-suffix && oldChunks[oHi-suffix-1].Signature == newChunks[nHi-suffix-1].Signature {
+		suffix++
+	}
+	oHi, nHi = oHi-suffix, nHi-suffix
+
+	if oLo < oHi && nLo < nHi {
+		anchors := uniqueAnchors(oldChunks, newChunks, oLo, oHi, nLo, nHi)
+		if len(anchors) == 0 {
+			pairs = append(pairs, lcsChunks(oldChunks, newChunks, oLo, oHi, nLo, nHi)...)
+		} else {
+			for _, a := range anchors {
+				pairs = alignChunks(oldChunks, newChunks, oLo, a.old, nLo, a.new, pairs)
+				pairs = append(pairs, a)
+				oLo, nLo = a.old+1, a.new+1
+			}
+			pairs = alignChunks(oldChunks, newChunks, oLo, oHi, nLo, nHi, pairs)
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		pairs = append(pairs, chunkPair{old: oHi + k, new: nHi + k})
+	}
+	return pairs
+}
+
+// uniqueAnchors return the longest sequence of chunks that are unique in both regions and are in
+// the same order in them. It is the longest increasing subsequence of their old indexes found with
+// patience sorting.
+func uniqueAnchors(oldChunks, newChunks []Chunk, oLo, oHi, nLo, nHi int) []chunkPair {
+	type occurrence struct {
+		count, index int
+	}
+	inOld := map[Signature]occurrence{}
+	for i := oLo; i < oHi; i++ {
+		o := inOld[oldChunks[i].Signature]
+		inOld[oldChunks[i].Signature] = occurrence{count: o.count + 1, index: i}
+	}
+	inNew := map[Signature]occurrence{}
+	for j := nLo; j < nHi; j++ {
+		o := inNew[newChunks[j].Signature]
+		inNew[newChunks[j].Signature] = occurrence{count: o.count + 1, index: j}
+	}
+
+	var candidates []chunkPair
+	for j := nLo; j < nHi; j++ {
+		s := newChunks[j].Signature
+		if o, ok := inOld[s]; ok && o.count == 1 && inNew[s].count == 1 {
+			candidates = append(candidates, chunkPair{old: o.index, new: j})
+		}
+	}
+
+	// tops[k] is the index in candidates of the top of the k-th pile and prev links every
+	// candidate to the top of the previous pile at the time when it is placed.
+	var tops []int
+	prev := make([]int, len(candidates))
+	for c, p := range candidates {
+		lo, hi := 0, len(tops)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if candidates[tops[mid]].old < p.old {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		prev[c] = -1
+		if lo > 0 {
+			prev[c] = tops[lo-1]
+		}
+		if lo == len(tops) {
+			tops = append(tops, c)
+		} else {
+			tops[lo] = c
+		}
+	}
+
+	anchors := make([]chunkPair, len(tops))
+	for k, c := len(tops)-1, -1; k >= 0; k-- {
+		if c == -1 {
+			c = tops[k]
+		} else {
+			c = prev[c]
+		}
+		anchors[k] = candidates[c]
+	}
+	return anchors
+}
+
+// lcsChunks return the aligned chunks of the longest common subsequence of the regions. If the
+// regions are too big, no chunks are aligned.
+func lcsChunks(oldChunks, newChunks []Chunk, oLo, oHi, nLo, nHi int) []chunkPair {
+	n, m := oHi-oLo, nHi-nLo
+	if n*m > maxLCSCells {
+		return nil
+	}
+
+	// lengths[i][j] is the length of the longest common subsequence of old[oLo+i:oHi] and new[nLo+j:nHi]
+	lengths := make([][]int32, n+1)
+	for i := range lengths {
+		lengths[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case oldChunks[oLo+i].Signature == newChunks[nLo+j].Signature:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	var pairs []chunkPair
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case oldChunks[oLo+i].Signature == newChunks[nLo+j].Signature:
+			pairs = append(pairs, chunkPair{old: oLo + i, new: nLo + j})
+			i, j = i+1, j+1
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,163 @@
+package fdiff_test
+
+import (
+	"crypto/sha1"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditScript(t *testing.T) {
+	cases := []struct {
+		name     string
+		old      string
+		new      string
+		expected []string
+	}{
+		{
+			name:     "equal chunks",
+			old:      "ABC",
+			new:      "ABC",
+			expected: []string{"KEEP A 0 0", "KEEP B 10 10", "KEEP C 20 20"},
+		},
+		{
+			name:     "inserted chunk",
+			old:      "AC",
+			new:      "ABC",
+			expected: []string{"KEEP A 0 0", "INSERT B - 10", "KEEP C 10 20"},
+		},
+		{
+			name:     "deleted chunk",
+			old:      "ABC",
+			new:      "AC",
+			expected: []string{"KEEP A 0 0", "DELETE B 10 -", "KEEP C 20 10"},
+		},
+		{
+			name:     "replaced chunk",
+			old:      "ABC",
+			new:      "AXC",
+			expected: []string{"KEEP A 0 0", "DELETE B 10 -", "INSERT X - 10", "KEEP C 20 20"},
+		},
+		{
+			name:     "moved chunk",
+			old:      "ABCDE",
+			new:      "ACDBE",
+			expected: []string{"KEEP A 0 0", "KEEP C 20 10", "KEEP D 30 20", "MOVE B 10 30", "KEEP E 40 40"},
+		},
+		{
+			name:     "swapped halves",
+			old:      "ABCDEF",
+			new:      "DEFABC",
+			expected: []string{"MOVE D 30 0", "MOVE E 40 10", "MOVE F 50 20", "KEEP A 0 30", "KEEP B 10 40", "KEEP C 20 50"},
+		},
+		{
+			name:     "duplicated chunk",
+			old:      "ABC",
+			new:      "ABCB",
+			expected: []string{"KEEP A 0 0", "KEEP B 10 10", "KEEP C 20 20", "INSERT B - 30"},
+		},
+		{
+			name:     "chunks without unique ones",
+			old:      "ABAB",
+			new:      "BABA",
+			expected: []string{"KEEP B 10 0", "KEEP A 20 10", "KEEP B 30 20", "MOVE A 0 30"},
+		},
+		{
+			name:     "empty old",
+			old:      "",
+			new:      "AB",
+			expected: []string{"INSERT A - 0", "INSERT B - 10"},
+		},
+		{
+			name:     "empty new",
+			old:      "AB",
+			new:      "",
+			expected: []string{"DELETE A 0 -", "DELETE B 10 -"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// SetUp
+			oldChunks, newChunks := letterChunks(c.old), letterChunks(c.new)
+
+			// Action
+			edits := fdiff.EditScript(oldChunks, newChunks)
+
+			// Assert
+			assert.Equal(t, c.expected, describeEdits(edits))
+		})
+	}
+}
+
+func TestEditScript_ReconstructNewChunks(t *testing.T) {
+	// SetUp
+	oldChunks := letterChunks("ABCDEFGHIJKLMNOP")
+	newChunks := letterChunks("AXCDLMNBEFGHZIJOPQ")
+
+	// Action
+	edits := fdiff.EditScript(oldChunks, newChunks)
+
+	// Assert
+	var actual []string
+	deleted := 0
+	for _, e := range edits {
+		switch e.Type {
+		case fdiff.EditDelete:
+			deleted++
+		default:
+			actual = append(actual, letterOf(e.Signature))
+		}
+	}
+	assert.Equal(t, "AXCDLMNBEFGHZIJOPQ", strings.Join(actual, ""))
+	assert.Equal(t, 1, deleted)
+}
+
+func TestEdit_String(t *testing.T) {
+	// SetUp
+	e := fdiff.Edit{Type: fdiff.EditMove, OldOffset: 10, NewOffset: 30, Length: 10, Signature: parseSHA1("9d23da68e8d2e7b42b1e021b1a4c2912a827f285")}
+
+	// Action
+	actual := e.String()
+
+	// Assert
+	assert.Equal(t, "MOVE old offset: 10, new offset: 30, length: 10, hash: 9d23da68e8d2e7b42b1e021b1a4c2912a827f285", actual)
+}
+
+// letterChunks return a chunk with length 10 for every letter. The first byte of the digest
+// of the signature of the chunk is the letter, see letterOf.
+func letterChunks(letters string) []fdiff.Chunk {
+	var chunks []fdiff.Chunk
+	for i, l := range letters {
+		digest := make([]byte, sha1.Size)
+		digest[0] = byte(l)
+		signature, _ := fdiff.NewSignature(fdiff.StrongHashSHA1, digest)
+		chunks = append(chunks, fdiff.Chunk{Offset: uint64(i * 10), Length: 10, Signature: signature})
+	}
+	return chunks
+}
+
+// letterOf return the letter of the signature of a chunk created by letterChunks.
+func letterOf(signature fdiff.Signature) string {
+	return string(signature.Digest()[:1])
+}
+
+// describeEdits return every edit as "<type> <signature> <old offset> <new offset>". The offsets that
+// are not used by the edit are "-".
+func describeEdits(edits []fdiff.Edit) []string {
+	var result []string
+	for _, e := range edits {
+		oldOffset, newOffset := "-", "-"
+		if e.Type != fdiff.EditInsert {
+			oldOffset = strconv.FormatUint(e.OldOffset, 10)
+		}
+		if e.Type != fdiff.EditDelete {
+			newOffset = strconv.FormatUint(e.NewOffset, 10)
+		}
+		result = append(result, e.Type.String()+" "+letterOf(e.Signature)+" "+oldOffset+" "+newOffset)
+	}
+	return result
+}
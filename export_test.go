@@ -0,0 +1,8 @@
+package fdiff
+
+// SetInPlaceInterrupt set the function that can interrupt ApplyInPlace before every step
+// and return a function that removes it.
+func SetInPlaceInterrupt(interrupt func(done, steps int) error) func() {
+	interruptInPlace = interrupt
+	return func() { interruptInPlace = nil }
+}
@@ -0,0 +1,274 @@
+package fdiff
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+)
+
+// Fetcher download a file that is published on an HTTP server together with
+// its signature. This is the inverse of the rsync: the server does not compute
+// anything. The client split its local stale copy of the file to chunks, compare
+// them with the remote signature and download only the missing chunks with HTTP
+// Range requests.
+type Fetcher struct {
+	client *http.Client
+
+	// newRollingHash is creating a new rolling hash. It MUST be the same
+	// as the one that is used for the creation of the remote signature.
+	newRollingHash func([]byte) rollinghash.Hash
+
+	// config MUST be the same as the one that is used for
+	// the creation of the remote signature.
+	config ChunkConfig
+}
+
+// FetchStats contains information about how a file was reconstructed by the Fetcher.
+type FetchStats struct {
+	// ReusedBytes is the number of bytes that are copied from the local file.
+	ReusedBytes uint64
+
+	// DownloadedBytes is the number of bytes that are downloaded from the server.
+	DownloadedBytes uint64
+
+	// Requests is the number of HTTP Range requests sent to the server.
+	Requests int
+
+	// HoleBytes is the number of bytes of the holes of the remote file. They are
+	// neither downloaded nor copied, but recreated as holes.
+	HoleBytes uint64
+
+	// Retried is true when the digests of the signature are truncated and the checksum of the
+	// reconstructed file didn't match, so the file was reconstructed again with the full-length
+	// signature (see Fetch) or downloaded again without reusing local chunks. The other stats are
+	// of the second reconstruction.
+	Retried bool
+}
+
+// NewFetcher initialize and return *Fetcher.
+func NewFetcher(c *http.Client, new func([]byte) rollinghash.Hash, cfg ChunkConfig) *Fetcher {
+	return &Fetcher{
+		client:         c,
+		newRollingHash: new,
+		config:         cfg,
+	}
+}
+
+// fetchSegment is one part of the remote file. The bytes of the segment are
+// either found in a seed file (location) or must be downloaded from the server.
+type fetchSegment struct {
+	offset   uint64
+	length   uint64
+	location chunkLocation
+	local    bool
+	hole     bool
+}
+
+// Fetch reconstruct the remote file 'url' in 'outFile'. The signature of the remote
+// file is downloaded from 'signatureURL' and all chunks that exist in the seeds are
+// reused, regardless of the seed file in which they are found. A seed can be a file
+// (usually the local stale copy of the remote file) or a directory. Seeds that do not
+// exist are skipped, if none exist the whole file is downloaded. The adjacent missing
+// chunks are coalesced and downloaded with one request. When all bytes are written,
+// the checksum of the whole file is verified and only then 'outFile' is replaced. If the
+// signature contains a Merkle root, the chunks of the signature are verified with it first.
+// The signature must be created with the strong hash of the configuration of the Fetcher.
+//
+// When the digests of the signature are truncated, a local chunk can be reused by mistake
+// instead of a remote chunk with the same truncated digest. Then the checksum of the file
+// doesn't match and the file is reconstructed again with the signature of the same file with
+// full-length digests, which is downloaded from 'signatureURL' + ".full" (for example
+// file.sig.full next to file.sig). When the server doesn't have it, the full-length digests
+// of the remote chunks are not known and the whole file is downloaded again.
+//
+// The holes of a sparse remote file are recreated as holes in 'outFile' without downloading them.
+func (f *Fetcher) Fetch(url, signatureURL, outFile string, seeds []string) (FetchStats, error) {
+	header, remoteChunks, err := f.fetchVerifiedSignature(signatureURL)
+	if err != nil {
+		return FetchStats{}, err
+	}
+
+	idx, err := newSeedIndex(f.newRollingHash, f.config, seeds, header.DigestLength)
+	if err != nil {
+		return FetchStats{}, err
+	}
+	defer idx.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(outFile), filepath.Base(outFile)+".fetch-*")
+	if err != nil {
+		return FetchStats{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	stats, err := f.reconstruct(tmp, url, header, planFetch(remoteChunks, idx), idx)
+	if errors.Is(err, ErrChecksumMismatch) && header.DigestLength > 0 && stats.ReusedBytes > 0 {
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return FetchStats{}, err
+		}
+		if err = tmp.Truncate(0); err != nil {
+			return FetchStats{}, err
+		}
+		var retryChunks []Chunk
+		var retryIdx *seedIndex
+		if retryChunks, retryIdx, err = f.fullSignature(signatureURL, header, remoteChunks, seeds); err != nil {
+			return FetchStats{}, err
+		}
+		defer retryIdx.Close()
+		stats, err = f.reconstruct(tmp, url, header, planFetch(retryChunks, retryIdx), retryIdx)
+		stats.Retried = true
+	}
+	if err != nil {
+		return FetchStats{}, err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return FetchStats{}, err
+	}
+	return stats, os.Rename(tmp.Name(), outFile)
+}
+
+// reconstruct write the segments of the remote file to 'w' and verify the size and the checksum
+// of the written bytes with the header. If the checksum is different ErrChecksumMismatch is returned
+// together with the stats.
+func (f *Fetcher) reconstruct(w io.Writer, url string, header SignatureHeader, segments []fetchSegment, idx *seedIndex) (FetchStats, error) {
+	h := sha1.New()
+	hw := newHoleWriter(w, h)
+	w = hw
+	var stats FetchStats
+	for _, s := range segments {
+		if s.hole {
+			if err := hw.writeHole(s.length); err != nil {
+				return FetchStats{}, err
+			}
+			stats.HoleBytes += s.length
+			continue
+		}
+		if s.local {
+			if err := idx.copyTo(w, s.location); err != nil {
+				return FetchStats{}, err
+			}
+			stats.ReusedBytes += s.length
+			continue
+		}
+
+		if err := f.downloadRange(w, url, s.offset, s.length); err != nil {
+			return FetchStats{}, err
+		}
+		stats.DownloadedBytes += s.length
+		stats.Requests++
+	}
+
+	if err := hw.close(); err != nil {
+		return FetchStats{}, err
+	}
+	if size := stats.ReusedBytes + stats.DownloadedBytes + stats.HoleBytes; size != header.FileSize {
+		return FetchStats{}, fmt.Errorf("the size of the fetched file is %d, expected %d", size, header.FileSize)
+	}
+	if sum := fmt.Sprintf("%x", h.Sum(nil)); sum != header.FileChecksum {
+		return stats, fmt.Errorf("%w: the checksum of the fetched file is %s, expected %s", ErrChecksumMismatch, sum, header.FileChecksum)
+	}
+	return stats, nil
+}
+
+// planFetch return the segments from which the remote file will be reconstructed.
+// The remote chunks that are missing in the seeds and are next to each other are
+// merged in one segment, so they can be downloaded with one request.
+func planFetch(remoteChunks []Chunk, idx *seedIndex) []fetchSegment {
+	var segments []fetchSegment
+	for _, rc := range remoteChunks {
+		if rc.Signature.IsHole() {
+			segments = append(segments, fetchSegment{offset: rc.Offset, length: rc.Length, hole: true})
+			continue
+		}
+		if loc, ok := idx.find(rc.Signature, rc.Length); ok {
+			segments = append(segments, fetchSegment{offset: rc.Offset, length: rc.Length, location: loc, local: true})
+			continue
+		}
+
+		if n := len(segments); n > 0 && !segments[n-1].local && !segments[n-1].hole && segments[n-1].offset+segments[n-1].length == rc.Offset {
+			segments[n-1].length += rc.Length
+			continue
+		}
+		segments = append(segments, fetchSegment{offset: rc.Offset, length: rc.Length})
+	}
+	return segments
+}
+
+// fetchVerifiedSignature download the signature and verify that it contains the checksum of the
+// file, that it is created with the strong hash of the configuration and that its chunks have
+// its Merkle root.
+func (f *Fetcher) fetchVerifiedSignature(url string) (SignatureHeader, []Chunk, error) {
+	header, chunks, err := f.fetchSignature(url)
+	if err != nil {
+		return SignatureHeader{}, nil, err
+	}
+	if header.FileChecksum == "" {
+		return SignatureHeader{}, nil, errors.New("the signature does not contain a checksum of the file")
+	}
+	if err = checkStrongHash(header, f.config.StrongHash); err != nil {
+		return SignatureHeader{}, nil, err
+	}
+	if root := NewMerkleTree(chunks).Root(); header.MerkleRoot != "" && root != header.MerkleRoot {
+		return SignatureHeader{}, nil, fmt.Errorf("the merkle root of the chunks of the signature is %s, expected %s", root, header.MerkleRoot)
+	}
+	return header, chunks, nil
+}
+
+// fullSignature return the chunks of the full-length signature of the file, which is downloaded
+// from 'signatureURL' + ".full", and the index of the seeds with full-length digests. When the
+// server doesn't have a valid full-length signature of the same file, the truncated chunks and
+// an empty index are returned, so the whole file is downloaded.
+func (f *Fetcher) fullSignature(signatureURL string, header SignatureHeader, chunks []Chunk, seeds []string) ([]Chunk, *seedIndex, error) {
+	full, fullChunks, err := f.fetchVerifiedSignature(signatureURL + ".full")
+	if err != nil || full.DigestLength != 0 || full.FileChecksum != header.FileChecksum || full.FileSize != header.FileSize {
+		return chunks, &seedIndex{}, nil
+	}
+	idx, err := newSeedIndex(f.newRollingHash, f.config, seeds, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fullChunks, idx, nil
+}
+
+// fetchSignature download and decode the signature of the remote file.
+func (f *Fetcher) fetchSignature(url string) (SignatureHeader, []Chunk, error) {
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return SignatureHeader{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return SignatureHeader{}, nil, fmt.Errorf("download signature %s: unexpected status %q", url, resp.Status)
+	}
+	return DecodeSignature(resp.Body)
+}
+
+// downloadRange download 'length' bytes of the remote file
+// that start from 'offset' and write them to 'w'.
+func (f *Fetcher) downloadRange(w io.Writer, url string, offset, length uint64) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("download bytes %d-%d of %s: unexpected status %q", offset, offset+length-1, url, resp.Status)
+	}
+	_, err = io.CopyN(w, resp.Body, int64(length))
+	return err
+}
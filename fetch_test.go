@@ -0,0 +1,276 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+var testChunkConfig = fdiff.ChunkConfig{
+	WindowSize:            16,
+	MinSizeChunk:          256,
+	MaxSizeChunk:          32768,
+	FingerprintBreakPoint: 0,
+}
+
+func TestFetch(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	newData := randomData(200000, 1)
+	oldData := append([]byte{}, newData[:70000]...)
+	oldData = append(oldData, []byte("a few bytes that were changed")...)
+	oldData = append(oldData, newData[70100:]...)
+	writeFile(t, filepath.Join(dir, "local"), oldData)
+	server, requests := newArtifactServer(t, newData, newData)
+
+	f := fdiff.NewFetcher(server.Client(), rollinghash.NewRabinFingerprint, testChunkConfig)
+
+	// Action
+	stats, err := f.Fetch(server.URL+"/artifact", server.URL+"/artifact.sig", filepath.Join(dir, "out"), []string{filepath.Join(dir, "local")})
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, newData, readBytes(t, filepath.Join(dir, "out")))
+	assert.EqualValues(t, len(newData), stats.ReusedBytes+stats.DownloadedBytes)
+	assert.Less(t, stats.DownloadedBytes, uint64(len(newData)/4))
+	assert.Equal(t, 1, stats.Requests)
+	assert.Equal(t, 1, len(requests.ranges()))
+}
+
+func TestFetch_WithSeeds(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data := randomData(200000, 4)
+	writeFile(t, filepath.Join(dir, "first-half"), data[:100000])
+	if err := os.MkdirAll(filepath.Join(dir, "seeds", "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "seeds", "nested", "second-half"), data[100000:])
+	writeFile(t, filepath.Join(dir, "seeds", "unrelated"), randomData(50000, 5))
+	server, _ := newArtifactServer(t, data, data)
+	f := fdiff.NewFetcher(server.Client(), rollinghash.NewRabinFingerprint, testChunkConfig)
+
+	// Action
+	seeds := []string{filepath.Join(dir, "missing"), filepath.Join(dir, "first-half"), filepath.Join(dir, "seeds")}
+	stats, err := f.Fetch(server.URL+"/artifact", server.URL+"/artifact.sig", filepath.Join(dir, "out"), seeds)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, data, readBytes(t, filepath.Join(dir, "out")))
+	assert.EqualValues(t, len(data), stats.ReusedBytes+stats.DownloadedBytes)
+	assert.Less(t, stats.DownloadedBytes, uint64(len(data)/4))
+}
+
+func TestFetch_WhenLocalFileDoesNotExist(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data := randomData(5000, 2)
+	server, requests := newArtifactServer(t, data, data)
+	f := fdiff.NewFetcher(server.Client(), rollinghash.NewRabinFingerprint, testChunkConfig)
+
+	// Action
+	stats, err := f.Fetch(server.URL+"/artifact", server.URL+"/artifact.sig", filepath.Join(dir, "out"), []string{filepath.Join(dir, "missing")})
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, data, readBytes(t, filepath.Join(dir, "out")))
+	assert.Equal(t, fdiff.FetchStats{DownloadedBytes: 5000, Requests: 1}, stats)
+	assert.Equal(t, []string{"bytes=0-4999"}, requests.ranges())
+}
+
+func TestFetch_WhenChecksumDoesNotMatch(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	signed := randomData(5000, 3)
+	served := append([]byte{}, signed...)
+	served[100] ^= 0xff
+	server, _ := newArtifactServer(t, signed, served)
+	f := fdiff.NewFetcher(server.Client(), rollinghash.NewRabinFingerprint, testChunkConfig)
+
+	// Action
+	_, err := f.Fetch(server.URL+"/artifact", server.URL+"/artifact.sig", filepath.Join(dir, "out"), []string{filepath.Join(dir, "missing")})
+
+	// Assert
+	assert.NotNil(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "out"))
+}
+
+func TestFetch_WhenTruncatedDigestsCollide(t *testing.T) {
+	// the full-length signature is served by the server next to the signature with truncated digests
+	cases := map[string]bool{
+		"without full-length signature": false,
+		"with full-length signature":    true,
+	}
+
+	for name, fullSignature := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			cfg := testChunkConfig
+			cfg.DigestLength = 2
+			data := randomData(50000, 8)
+			local := append([]byte{}, data...)
+			local[25000] ^= 0xff
+			writeFile(t, filepath.Join(dir, "artifact"), data)
+			writeFile(t, filepath.Join(dir, "local"), local)
+			signWithChunker(t, filepath.Join(dir, "artifact"), filepath.Join(dir, "artifact.sig"), cfg)
+			signWithChunker(t, filepath.Join(dir, "local"), filepath.Join(dir, "local.sig"), cfg)
+			fullCfg := cfg
+			fullCfg.DigestLength = 0
+			signWithChunker(t, filepath.Join(dir, "artifact"), filepath.Join(dir, "artifact.sig.full"), fullCfg)
+			header, chunks, _ := fdiff.DecodeSignature(bytes.NewReader(readBytes(t, filepath.Join(dir, "artifact.sig"))))
+			_, localChunks, _ := fdiff.DecodeSignature(bytes.NewReader(readBytes(t, filepath.Join(dir, "local.sig"))))
+			// the changed local chunk gets the truncated digest of the remote chunk, like in a collision
+			var collided []fdiff.Chunk
+			for i, ch := range chunks {
+				for _, lc := range localChunks {
+					if lc.Offset == ch.Offset && lc.Length == ch.Length && lc.Signature != ch.Signature {
+						chunks[i].Signature = lc.Signature
+						collided = append(collided, ch)
+					}
+				}
+			}
+			if len(collided) != 1 {
+				t.Fatal("the changed local chunk is not found")
+			}
+			header.MerkleRoot = fdiff.NewMerkleTree(chunks).Root()
+			signature := header.String()
+			for _, ch := range chunks {
+				signature += ch.String() + "\n"
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, ".sig"):
+					http.ServeContent(w, r, "artifact.sig", time.Time{}, strings.NewReader(signature))
+				case strings.HasSuffix(r.URL.Path, ".sig.full") && fullSignature:
+					http.ServeContent(w, r, "artifact.sig.full", time.Time{}, bytes.NewReader(readBytes(t, filepath.Join(dir, "artifact.sig.full"))))
+				case strings.HasSuffix(r.URL.Path, ".sig.full"):
+					http.NotFound(w, r)
+				default:
+					http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(data))
+				}
+			}))
+			t.Cleanup(server.Close)
+			f := fdiff.NewFetcher(server.Client(), rollinghash.NewRabinFingerprint, cfg)
+			expected := len(data)
+			if fullSignature {
+				expected = int(collided[0].Length)
+			}
+
+			// Action
+			stats, err := f.Fetch(server.URL+"/artifact", server.URL+"/artifact.sig", filepath.Join(dir, "out"), []string{filepath.Join(dir, "local")})
+
+			// Assert
+			assert.Nil(t, err)
+			assert.Equal(t, 2, header.DigestLength)
+			assert.True(t, stats.Retried)
+			assert.EqualValues(t, expected, stats.DownloadedBytes)
+			assert.Equal(t, data, readBytes(t, filepath.Join(dir, "out")))
+		})
+	}
+}
+
+func TestFetch_WhenMerkleRootDoesNotMatch(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data := randomData(50000, 7)
+	writeFile(t, filepath.Join(dir, "artifact"), data)
+	signWithChunker(t, filepath.Join(dir, "artifact"), filepath.Join(dir, "artifact.sig"), testChunkConfig)
+	header, chunks, _ := fdiff.DecodeSignature(bytes.NewReader(readBytes(t, filepath.Join(dir, "artifact.sig"))))
+	chunks[0], chunks[1] = chunks[1], chunks[0]
+	signature := header.String()
+	for _, ch := range chunks {
+		signature += ch.String() + "\n"
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sig") {
+			http.ServeContent(w, r, "artifact.sig", time.Time{}, strings.NewReader(signature))
+			return
+		}
+		http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	f := fdiff.NewFetcher(server.Client(), rollinghash.NewRabinFingerprint, testChunkConfig)
+
+	// Action
+	_, err := f.Fetch(server.URL+"/artifact", server.URL+"/artifact.sig", filepath.Join(dir, "out"), []string{filepath.Join(dir, "artifact")})
+
+	// Assert
+	assert.ErrorContains(t, err, "merkle root")
+	assert.NoFileExists(t, filepath.Join(dir, "out"))
+}
+
+// rangeRecorder records the Range headers of the requests to the server.
+type rangeRecorder struct {
+	mu     sync.Mutex
+	values []string
+}
+
+func (r *rangeRecorder) ranges() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.values...)
+}
+
+// newArtifactServer start a server that publishes 'served' data and the signature of 'signed' data.
+func newArtifactServer(t *testing.T, signed, served []byte) (*httptest.Server, *rangeRecorder) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "artifact"), signed)
+	signWithChunker(t, filepath.Join(dir, "artifact"), filepath.Join(dir, "artifact.sig"), testChunkConfig)
+	signature := readBytes(t, filepath.Join(dir, "artifact.sig"))
+
+	requests := &rangeRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sig") {
+			http.ServeContent(w, r, "artifact.sig", time.Time{}, bytes.NewReader(signature))
+			return
+		}
+		requests.mu.Lock()
+		requests.values = append(requests.values, r.Header.Get("Range"))
+		requests.mu.Unlock()
+		http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(served))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// signWithChunker sign the file with the real Chunker and Rabin fingerprint.
+func signWithChunker(t *testing.T, file, signatureFile string, cfg fdiff.ChunkConfig) {
+	d := make(chan byte, 100)
+	ch := make(chan fdiff.Chunk, 100)
+	newChunker(t, rollinghash.NewRabinFingerprint, cfg, d, ch).Start()
+	if err := fdiff.NewFileSignerDeltaWithConfig(d, ch, cfg).Sign(file, signatureFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func randomData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func writeFile(t *testing.T, file string, data []byte) {
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readBytes(t *testing.T, file string) []byte {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,89 @@
+package fdiff
+
+import "hash"
+
+// hasherPool calculate the strong hashes of the chunks with several goroutines, so the goroutine
+// that finds the boundaries of the chunks doesn't wait for them. The chunks are sent through the
+// channel in the order in which they are added, even if their hashes are calculated in another order.
+type hasherPool struct {
+	// newStrongHash is creating a new hash for the signatures of the chunks
+	newStrongHash func() hash.Hash
+
+	// strongHashTag is the tag of the strong hash in the signatures of the chunks
+	strongHashTag byte
+
+	// jobs is the channel through which the chunks are sent to the hashers.
+	jobs chan hashJob
+
+	// queue contains the results of the added chunks in their order. Its capacity
+	// limits the number of chunks that are hashed or waiting to be sent.
+	queue chan chan Chunk
+
+	// done is closed when the last chunk is sent.
+	done chan struct{}
+}
+
+// hashJob is a chunk whose signature should be calculated.
+type hashJob struct {
+	chunk  Chunk
+	result chan<- Chunk
+}
+
+// newHasherPool start 'hashers' goroutines that calculate the signatures of the chunks and
+// a goroutine that sends the chunks through the channel. The channel is closed by close.
+func newHasherPool(newStrongHash func() hash.Hash, strongHashTag byte, hashers int, chunks chan<- Chunk) *hasherPool {
+	if hashers < 1 {
+		hashers = 1
+	}
+	p := &hasherPool{
+		newStrongHash: newStrongHash,
+		strongHashTag: strongHashTag,
+		jobs:          make(chan hashJob),
+		queue:         make(chan chan Chunk, 2*hashers),
+		done:          make(chan struct{}),
+	}
+	for i := 0; i < hashers; i++ {
+		go p.hash()
+	}
+	go func() {
+		defer close(p.done)
+		defer close(chunks)
+		for result := range p.queue {
+			chunks <- <-result
+		}
+	}()
+	return p
+}
+
+// hash calculate the signatures of the chunks until the pool is closed.
+func (p *hasherPool) hash() {
+	h := p.newStrongHash()
+	var sum [MaxSignatureSize]byte
+	for job := range p.jobs {
+		h.Reset()
+		h.Write(job.chunk.Data)
+		job.chunk.Signature = newSignature(p.strongHashTag, h.Sum(sum[:0]))
+		job.result <- job.chunk
+	}
+}
+
+// add send the chunk to the hashers. The data of the chunk must not be changed after that.
+func (p *hasherPool) add(c Chunk) {
+	result := make(chan Chunk, 1)
+	p.queue <- result
+	p.jobs <- hashJob{chunk: c, result: result}
+}
+
+// addHashed send the chunk in its order without calculating its signature.
+func (p *hasherPool) addHashed(c Chunk) {
+	result := make(chan Chunk, 1)
+	result <- c
+	p.queue <- result
+}
+
+// close stop the hashers and wait until all added chunks are sent and the channel is closed.
+func (p *hasherPool) close() {
+	close(p.jobs)
+	close(p.queue)
+	<-p.done
+}
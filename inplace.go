@@ -0,0 +1,654 @@
+package fdiff
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// inPlaceStepSize is the maximum number of bytes that one step of an in-place patch copies. The
+// copies are split in steps, so the data of a step is read in memory and a cycle of copies is
+// broken by saving only one step in the journal.
+const inPlaceStepSize = 4 << 20
+
+// stepKind is the kind of a step of an in-place patch.
+type stepKind byte
+
+const (
+	// stepCopy copies bytes of the file to another place of the file.
+	stepCopy stepKind = iota
+
+	// stepData writes the literal data of the patch.
+	stepData
+
+	// stepZero writes zeros, unless the bytes are already a hole of the file.
+	stepZero
+
+	// stepSaved writes the data of a copy that is saved in the journal before the file is changed.
+	stepSaved
+)
+
+// inPlaceStep is one write of an in-place patch.
+type inPlaceStep struct {
+	kind   stepKind
+	source uint64
+	target uint64
+	length uint64
+	data   []byte
+}
+
+// overlaps return true when the step copies bytes from the bytes that it overwrites.
+func (s inPlaceStep) overlaps() bool {
+	return s.kind == stepCopy && s.source < s.target+s.length && s.target < s.source+s.length
+}
+
+// planInPlace split the operations in steps and return them with the order in which they are
+// executed. A copy must be executed before the steps that overwrite its source, so the steps are
+// sorted topologically by these dependencies. When the dependencies have a cycle, a copy of the
+// cycle is changed to a stepSaved: its source is saved in the journal before the file is changed,
+// so it doesn't depend on the steps that overwrite its source anymore.
+//
+// The copies are split in the order of memmove, so a copy that overlaps its target doesn't depend
+// on itself, except inside one step. The copies that are already in place are skipped. The plan
+// depends only on the operations, so a resumed patch executes the same steps in the same order.
+func planInPlace(ops []Op) ([]inPlaceStep, []int, error) {
+	var steps []inPlaceStep
+	var target uint64
+	for _, op := range ops {
+		switch op.Type {
+		case OpCopy:
+			if op.SourceOffset != target {
+				for offset := uint64(0); offset < op.Length; offset += inPlaceStepSize {
+					length := op.Length - offset
+					if length > inPlaceStepSize {
+						length = inPlaceStepSize
+					}
+					steps = append(steps, inPlaceStep{kind: stepCopy, source: op.SourceOffset + offset, target: target + offset, length: length})
+				}
+			}
+		case OpData:
+			steps = append(steps, inPlaceStep{kind: stepData, target: target, length: op.Length, data: op.Data})
+		case OpZero:
+			steps = append(steps, inPlaceStep{kind: stepZero, target: target, length: op.Length})
+		default:
+			return nil, nil, fmt.Errorf("unknown type of operation %d", op.Type)
+		}
+		target += op.Length
+	}
+
+	// the copies by the offsets of their sources
+	var readers []int
+	for i, s := range steps {
+		if s.kind == stepCopy {
+			readers = append(readers, i)
+		}
+	}
+	sort.SliceStable(readers, func(a, b int) bool { return steps[readers[a]].source < steps[readers[b]].source })
+
+	// after[i] contains the steps that overwrite the source of the copy i
+	after := make([][]int, len(steps))
+	dependencies := make([]int, len(steps))
+	for i, s := range steps {
+		// the sources are not longer than a step, so the first one that can overlap the
+		// target starts less than a step before it
+		k := sort.Search(len(readers), func(k int) bool { return steps[readers[k]].source+inPlaceStepSize > s.target })
+		for ; k < len(readers) && steps[readers[k]].source < s.target+s.length; k++ {
+			r := readers[k]
+			if r != i && steps[r].source+steps[r].length > s.target {
+				after[r] = append(after[r], i)
+				dependencies[i]++
+			}
+		}
+	}
+
+	order := make([]int, 0, len(steps))
+	var ready []int
+	for i := range steps {
+		if dependencies[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	release := func(i int) {
+		for _, k := range after[i] {
+			if dependencies[k]--; dependencies[k] == 0 {
+				ready = append(ready, k)
+			}
+		}
+		after[i] = nil
+	}
+	executed := make([]bool, len(steps))
+	next := 0
+	for len(order) < len(steps) {
+		if len(ready) == 0 {
+			// all remaining steps wait for each other. The next copy that blocks a step is saved. Its
+			// steps only get fewer, so the copies before it never block a step again.
+			for ; next < len(steps); next++ {
+				if !executed[next] && steps[next].kind == stepCopy && blocks(after[next], executed) {
+					break
+				}
+			}
+			steps[next].kind = stepSaved
+			release(next)
+			continue
+		}
+		i := ready[0]
+		ready = ready[1:]
+		order = append(order, i)
+		executed[i] = true
+		release(i)
+	}
+	return steps, order, nil
+}
+
+// blocks return true when any of the steps is not executed.
+func blocks(steps []int, executed []bool) bool {
+	for _, k := range steps {
+		if !executed[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// journalMagic is written in the beginning of every journal of an in-place patch.
+const journalMagic = "fdiff-journal/1\n"
+
+// records of the journal of an in-place patch.
+const (
+	// journalSaved is the source of a stepSaved: <index of the step> <data>.
+	journalSaved byte = iota + 1
+
+	// journalReady is written when all stepSaved are saved and the file can be changed.
+	journalReady
+
+	// journalRedo is the state when the source of the copy at the position in the order, which
+	// overlaps its target, is saved in the redo area: <position> <length> <CRC-32 of the data>.
+	journalRedo
+
+	// journalDone is the state when the step at the position in the order is written: <position>.
+	journalDone
+)
+
+// journalSlotSize is the size of a slot of the state of the journal.
+const journalSlotSize = 64
+
+// inPlaceJournal records the progress of an in-place patch, so an interrupted patch can be resumed.
+//
+// The sources of the stepSaved are appended and synced before the file is changed, and an incomplete
+// record at the end of them (written when the patch is interrupted) is ignored. After them the journal
+// contains two slots for the state of the patch and one redo area for the source of a copy that
+// overlaps its target, so the journal is never longer than the saved sources and one step. A new state
+// is written in the slot that doesn't contain the current one, so when the writing is interrupted the
+// current state is still valid. The states contain their CRC-32, so an incomplete one is ignored.
+type inPlaceJournal struct {
+	f *os.File
+
+	// size is the size of the saved sources. The slots of the state start after them.
+	size int64
+
+	// saved contains the offsets of the data of the stepSaved in the journal by their indexes.
+	saved map[int]int64
+	ready bool
+
+	// done is the number of executed steps in the order of the plan.
+	done int
+
+	// redo is true when the source of the step at the position 'done' is in the redo area.
+	redo bool
+
+	// slot is the slot that contains the current state, or -1 when no step is executed.
+	slot int
+}
+
+// openJournal open the journal of the plan or create it if it doesn't exist. When the journal is
+// created for another plan an error is returned.
+func openJournal(file string, plan []byte) (*inPlaceJournal, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j := &inPlaceJournal{f: f, saved: map[int]int64{}, slot: -1}
+	header := append([]byte(journalMagic), plan...)
+	if err = j.read(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if j.size == 0 {
+		if err = j.append(header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// read the records of the journal until the first incomplete one.
+func (j *inPlaceJournal) read(header []byte) error {
+	br := bufio.NewReader(j.f)
+	actual := make([]byte, len(header))
+	if n, err := io.ReadFull(br, actual); err != nil {
+		if n == 0 || bytes.HasPrefix(header, actual[:n]) {
+			// the journal is new or its header is incomplete
+			return j.f.Truncate(0)
+		}
+		return errors.New("the journal is not a journal of an in-place patch")
+	}
+	if !bytes.Equal(header, actual) {
+		return errors.New("the journal is created by another patch")
+	}
+
+	offset := int64(len(header))
+	j.size = offset
+	for !j.ready {
+		n, err := j.readRecord(br, offset)
+		if err != nil {
+			// the rest is an incomplete record
+			return j.f.Truncate(j.size)
+		}
+		offset += n
+		j.size = offset
+	}
+	return j.readState()
+}
+
+// readState read the slots of the state and use the latest valid one.
+func (j *inPlaceJournal) readState() error {
+	// order is the order of the states: the source of a step is saved after the previous step is written
+	var latest uint64
+	for slot := 0; slot < 2; slot++ {
+		kind, position, length, sum, ok := j.readSlot(slot)
+		if !ok {
+			continue
+		}
+		order := 2*position + 2
+		if kind == journalRedo {
+			order--
+			// the state is written after the redo area, so only an older state can describe other data
+			data, err := j.readData(j.redoOffset(), length)
+			if err != nil || crc32.ChecksumIEEE(data) != sum {
+				continue
+			}
+		}
+		if order > latest {
+			latest, j.slot = order, slot
+			j.done, j.redo = int(position)+1, false
+			if kind == journalRedo {
+				j.done, j.redo = int(position), true
+			}
+		}
+	}
+	return nil
+}
+
+// readSlot read the state in the slot. It return false when the slot doesn't contain a valid state.
+func (j *inPlaceJournal) readSlot(slot int) (kind byte, position, length uint64, sum uint32, ok bool) {
+	b := make([]byte, journalSlotSize)
+	n, _ := j.f.ReadAt(b, j.slotOffset(slot))
+	b = b[:n]
+	br := bytes.NewReader(b)
+	var err error
+	if kind, err = br.ReadByte(); err != nil || (kind != journalRedo && kind != journalDone) {
+		return 0, 0, 0, 0, false
+	}
+	if position, err = binary.ReadUvarint(br); err != nil {
+		return 0, 0, 0, 0, false
+	}
+	if kind == journalRedo {
+		if length, err = binary.ReadUvarint(br); err != nil || length > inPlaceStepSize {
+			return 0, 0, 0, 0, false
+		}
+		var buf [4]byte
+		if _, err = io.ReadFull(br, buf[:]); err != nil {
+			return 0, 0, 0, 0, false
+		}
+		sum = binary.BigEndian.Uint32(buf[:])
+	}
+	end := len(b) - br.Len()
+	if br.Len() < 4 || binary.BigEndian.Uint32(b[end:end+4]) != crc32.ChecksumIEEE(b[:end]) {
+		return 0, 0, 0, 0, false
+	}
+	return kind, position, length, sum, true
+}
+
+// slotOffset return the offset of the slot of the state in the journal.
+func (j *inPlaceJournal) slotOffset(slot int) int64 {
+	return j.size + int64(slot)*journalSlotSize
+}
+
+// redoOffset return the offset of the redo area in the journal.
+func (j *inPlaceJournal) redoOffset() int64 {
+	return j.size + 2*journalSlotSize
+}
+
+// writeState write the state in the slot that doesn't contain the current state and sync it.
+func (j *inPlaceJournal) writeState(kind byte, position uint64, data []byte) error {
+	record := []byte{kind}
+	record = binary.AppendUvarint(record, position)
+	if kind == journalRedo {
+		record = binary.AppendUvarint(record, uint64(len(data)))
+		record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(data))
+	}
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+
+	slot := 0
+	if j.slot == 0 {
+		slot = 1
+	}
+	if _, err := j.f.WriteAt(record, j.slotOffset(slot)); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.slot = slot
+	return nil
+}
+
+// saveRedo save the source of the step at the position 'done' in the redo area before it is written.
+func (j *inPlaceJournal) saveRedo(data []byte) error {
+	if _, err := j.f.WriteAt(data, j.redoOffset()); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	if err := j.writeState(journalRedo, uint64(j.done), data); err != nil {
+		return err
+	}
+	j.redo = true
+	return nil
+}
+
+// readRecord read the record that starts at the offset and return its length.
+func (j *inPlaceJournal) readRecord(br *bufio.Reader, offset int64) (int64, error) {
+	cr := &countingReader{r: br}
+	kind, err := cr.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return 0, err
+	}
+	switch kind {
+	case journalSaved:
+		length, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return 0, err
+		}
+		dataOffset := offset + cr.n
+		if _, err = io.CopyN(io.Discard, cr, int64(length)); err != nil {
+			return 0, err
+		}
+		j.saved[int(v)] = dataOffset
+	case journalReady:
+		j.ready = true
+	default:
+		return 0, fmt.Errorf("unknown record %d", kind)
+	}
+	return cr.n, nil
+}
+
+// countingReader count the bytes that are read.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// append write the bytes at the end of the journal and sync it.
+func (j *inPlaceJournal) append(b []byte) error {
+	if _, err := j.f.WriteAt(b, j.size); err != nil {
+		return err
+	}
+	j.size += int64(len(b))
+	return j.f.Sync()
+}
+
+// appendRecord append a record with the value and the data if it is not nil. It return the offset of the data.
+func (j *inPlaceJournal) appendRecord(kind byte, v uint64, data []byte) (int64, error) {
+	record := []byte{kind}
+	record = binary.AppendUvarint(record, v)
+	if data != nil {
+		record = binary.AppendUvarint(record, uint64(len(data)))
+	}
+	offset := j.size + int64(len(record))
+	return offset, j.append(append(record, data...))
+}
+
+// interruptInPlace is called by ApplyInPlace before every step with the number of the executed
+// steps and of all steps. When it return an error, the patch is interrupted. It is set only by
+// the tests.
+var interruptInPlace func(done, steps int) error
+
+// ApplyInPlace apply the patch to the old file in place, like rsync --inplace, so the new file doesn't
+// need more disk space than the old file. The steps that copy bytes are ordered so every source is read
+// before it is overwritten, see planInPlace. When copies depend on each other in a cycle, the source of
+// one of them is saved in the journal first, and a copy that overlaps its own target is saved in the
+// journal before it is written.
+//
+// Every executed step is recorded in the journal file, so when the patch is interrupted ApplyInPlace
+// can be called again with the same patch and journal to resume it. Before the first write every copy
+// is checked to read only bytes of the file. When the patch has a checksum, the new file is verified with
+// it at the end. The journal is removed only when the patch is applied and verified.
+func (p Patch) ApplyInPlace(file, journalFile string) error {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	j, err := openJournal(journalFile, p.planID())
+	if err != nil {
+		return err
+	}
+	defer j.f.Close()
+	if !j.ready {
+		// nothing is written to the file before the journal is ready, so it must still be the old
+		// file. The sources are checked before the copies are split into steps.
+		err = checkSources(p.Ops, info.Size())
+	}
+	var steps []inPlaceStep
+	var order []int
+	if err == nil {
+		steps, order, err = planInPlace(p.Ops)
+	}
+	if err != nil {
+		if !j.ready {
+			j.f.Close()
+			_ = os.Remove(journalFile)
+		}
+		return err
+	}
+	if !j.ready {
+		if err = j.save(f, steps); err != nil {
+			return err
+		}
+	}
+
+	// only the zero step writes its target, so the holes of the file are the same until it is executed
+	extents, err := extentsOf(f, info.Size())
+	if err != nil {
+		return err
+	}
+	for ; j.done < len(order); j.done++ {
+		if interruptInPlace != nil {
+			if err = interruptInPlace(j.done, len(order)); err != nil {
+				return err
+			}
+		}
+		if err = j.execute(f, steps[order[j.done]], order[j.done], extents); err != nil {
+			return err
+		}
+		if err = f.Sync(); err != nil {
+			return err
+		}
+		if err = j.writeState(journalDone, uint64(j.done), nil); err != nil {
+			return err
+		}
+		j.redo = false
+	}
+
+	var size uint64
+	for _, op := range p.Ops {
+		size += op.Length
+	}
+	if err = f.Truncate(int64(size)); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	if p.Checksum != "" {
+		// the journal is kept when the checksum doesn't match, so the patch can be diagnosed or redone
+		h := sha1.New()
+		if _, err = io.Copy(h, io.NewSectionReader(f, 0, int64(size))); err != nil {
+			return err
+		}
+		if sum := fmt.Sprintf("%x", h.Sum(nil)); sum != p.Checksum {
+			return fmt.Errorf("%w: the checksum of the new data is %s, expected %s", ErrChecksumMismatch, sum, p.Checksum)
+		}
+	}
+	j.f.Close()
+	return os.Remove(journalFile)
+}
+
+// checkSources return an error when a copy of the operations reads bytes after the end of
+// the old file, so a patch is not applied in place to a wrong or truncated file.
+func checkSources(ops []Op, size int64) error {
+	for _, op := range ops {
+		if op.Type == OpCopy && (op.Length > uint64(size) || op.SourceOffset > uint64(size)-op.Length) {
+			return fmt.Errorf("copy %d bytes from offset %d: the old data is too short", op.Length, op.SourceOffset)
+		}
+	}
+	return nil
+}
+
+// planID return the SHA-1 checksum of the operations of the patch. The journal is used only with the same patch.
+func (p Patch) planID() []byte {
+	h := sha1.New()
+	_, _ = io.WriteString(h, p.Checksum)
+	for _, op := range p.Ops {
+		var b []byte
+		b = append(b, byte(op.Type))
+		b = binary.AppendUvarint(b, op.SourceOffset)
+		b = binary.AppendUvarint(b, op.Length)
+		h.Write(b)
+		h.Write(op.Data)
+	}
+	return h.Sum(nil)
+}
+
+// save write the sources of the stepSaved in the journal before the file is changed.
+func (j *inPlaceJournal) save(f *os.File, steps []inPlaceStep) error {
+	for i, s := range steps {
+		if s.kind != stepSaved {
+			continue
+		}
+		data, err := readSource(f, s)
+		if err != nil {
+			return err
+		}
+		if j.saved[i], err = j.appendRecord(journalSaved, uint64(i), data); err != nil {
+			return err
+		}
+	}
+	if _, err := j.appendRecord(journalReady, 0, nil); err != nil {
+		return err
+	}
+	j.ready = true
+	return nil
+}
+
+// execute write the step with the index in the file.
+func (j *inPlaceJournal) execute(f *os.File, s inPlaceStep, i int, extents []extent) error {
+	var data []byte
+	var err error
+	switch s.kind {
+	case stepCopy:
+		if j.redo {
+			// the step was interrupted and its source may be overwritten
+			data, err = j.readData(j.redoOffset(), s.length)
+			break
+		}
+		if data, err = readSource(f, s); err == nil && s.overlaps() {
+			err = j.saveRedo(data)
+		}
+	case stepData:
+		data = s.data
+	case stepZero:
+		if isHole(extents, s.target, s.length) {
+			return nil
+		}
+		for offset := uint64(0); offset < s.length; offset += uint64(len(zeros)) {
+			n := s.length - offset
+			if n > uint64(len(zeros)) {
+				n = uint64(len(zeros))
+			}
+			if _, err = f.WriteAt(zeros[:n], int64(s.target+offset)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case stepSaved:
+		data, err = j.readData(j.saved[i], s.length)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, int64(s.target))
+	return err
+}
+
+// readData read the data at the offset of the journal.
+func (j *inPlaceJournal) readData(offset int64, length uint64) ([]byte, error) {
+	data, err := readFull(io.NewSectionReader(j.f, offset, int64(length)), length)
+	if err != nil {
+		return nil, fmt.Errorf("read the journal: %w", err)
+	}
+	return data, nil
+}
+
+// readSource read the source of the copy from the file.
+func readSource(f *os.File, s inPlaceStep) ([]byte, error) {
+	data := make([]byte, s.length)
+	if n, _ := f.ReadAt(data, int64(s.source)); uint64(n) != s.length {
+		return nil, fmt.Errorf("copy %d bytes from offset %d: the old data is too short", s.length, s.source)
+	}
+	return data, nil
+}
+
+// isHole return true when the bytes are in a hole of the file or after its end.
+func isHole(extents []extent, offset, length uint64) bool {
+	for _, e := range extents {
+		if uint64(e.offset) < offset+length && uint64(e.offset+e.length) > offset && !e.hole {
+			return false
+		}
+	}
+	return true
+}
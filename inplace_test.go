@@ -0,0 +1,290 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatch_ApplyInPlace(t *testing.T) {
+	oldData := randomData(300000, 1)
+	bigData := randomData(10<<20, 2)
+	text := textData(3000, 1)
+	newText := append(text[:40000:40000], append([]byte(strings.Repeat("a new line of the log\n", 500)), text[45000:]...)...)
+	cases := map[string]struct {
+		old []byte
+		ops []fdiff.Op
+	}{
+		"delta": {old: text, ops: findDeltaWithChunker(t, text, newText, testChunkConfig).Ops},
+		"inserted bytes": {old: oldData, ops: []fdiff.Op{
+			{Type: fdiff.OpData, Length: 3, Data: []byte("abc")},
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 300000},
+		}},
+		"removed bytes": {old: oldData, ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 100, Length: 299900},
+		}},
+		"swapped halves": {old: oldData, ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 150000, Length: 150000},
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 150000},
+		}},
+		"rotated blocks": {old: oldData, ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 100000, Length: 100000},
+			{Type: fdiff.OpCopy, SourceOffset: 200000, Length: 100000},
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 100000},
+		}},
+		"repeated blocks": {old: oldData, ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 200000, Length: 100000},
+			{Type: fdiff.OpCopy, SourceOffset: 200000, Length: 100000},
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 100000},
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 100000},
+		}},
+		"big shift": {old: bigData, ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 1000, Length: 5 << 20},
+			{Type: fdiff.OpData, Length: 5, Data: []byte("12345")},
+			{Type: fdiff.OpCopy, SourceOffset: 5 << 20, Length: 5 << 20},
+		}},
+		"zeros and shorter file": {old: oldData, ops: []fdiff.Op{
+			{Type: fdiff.OpZero, Length: 50000},
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 50000},
+			{Type: fdiff.OpZero, Length: 10},
+		}},
+		"longer file": {old: oldData, ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 300000},
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 300000},
+			{Type: fdiff.OpZero, Length: 100000},
+		}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "file"), c.old)
+			var expected bytes.Buffer
+			assert.Nil(t, fdiff.Patch{Ops: c.ops}.Apply(bytes.NewReader(c.old), &expected))
+			p := fdiff.Patch{Ops: c.ops, Checksum: fmt.Sprintf("%x", sha1.Sum(expected.Bytes()))}
+
+			// Action
+			err := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+			// Assert
+			assert.Nil(t, err)
+			assert.Equal(t, expected.Bytes(), readBytes(t, filepath.Join(dir, "file")))
+			assert.NoFileExists(t, filepath.Join(dir, "journal"))
+		})
+	}
+}
+
+func TestPatch_ApplyInPlace_WhenItIsResumed(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	x, y, w, z := randomData(50000, 1), randomData(50000, 2), randomData(50000, 3), randomData(50000, 4)
+	writeFile(t, filepath.Join(dir, "file"), append(append(append(append([]byte{}, x...), y...), w...), z...))
+	expected := append(append(append([]byte{}, y...), w...), z...)
+	// y is moved before it is overwritten by w, w before it is overwritten by z, and the
+	// patch is interrupted before z is moved
+	p := fdiff.Patch{
+		Ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 50000, Length: 50000},
+			{Type: fdiff.OpCopy, SourceOffset: 100000, Length: 50000},
+			{Type: fdiff.OpCopy, SourceOffset: 150000, Length: 50000},
+		},
+		Checksum: fmt.Sprintf("%x", sha1.Sum(expected)),
+	}
+
+	// Action
+	restore := interruptAt(func(steps int) int { return steps - 1 })
+	interruptedErr := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+	restore()
+	err := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+	// Assert
+	assert.ErrorIs(t, interruptedErr, errInterrupted)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, readBytes(t, filepath.Join(dir, "file")))
+	assert.NoFileExists(t, filepath.Join(dir, "journal"))
+}
+
+func TestPatch_ApplyInPlace_WhenJournalIsOfAnotherPatch(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data := randomData(100000, 1)
+	writeFile(t, filepath.Join(dir, "file"), data)
+	interrupted := fdiff.Patch{Ops: []fdiff.Op{{Type: fdiff.OpCopy, SourceOffset: 2000, Length: 1000}}}
+	other := fdiff.Patch{Ops: []fdiff.Op{{Type: fdiff.OpCopy, SourceOffset: 1000, Length: 1000}}}
+
+	// Action
+	restore := interruptAt(func(int) int { return 0 })
+	interruptedErr := interrupted.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+	restore()
+	err := other.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+	// Assert
+	assert.ErrorIs(t, interruptedErr, errInterrupted)
+	assert.EqualError(t, err, "the journal is created by another patch")
+	assert.Equal(t, data, readBytes(t, filepath.Join(dir, "file")))
+}
+
+func TestPatch_ApplyInPlace_WhenChecksumDoesNotMatch(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "file"), randomData(100000, 1))
+	p := fdiff.Patch{
+		Ops:      []fdiff.Op{{Type: fdiff.OpCopy, SourceOffset: 1000, Length: 1000}},
+		Checksum: fmt.Sprintf("%x", sha1.Sum([]byte("other data"))),
+	}
+
+	// Action
+	err := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+	// Assert
+	assert.ErrorIs(t, err, fdiff.ErrChecksumMismatch)
+	assert.FileExists(t, filepath.Join(dir, "journal"))
+}
+
+func TestPatch_ApplyInPlace_WhenFileIsNotTheOldFile(t *testing.T) {
+	cases := map[string]fdiff.Op{
+		"source after the end": {Type: fdiff.OpCopy, SourceOffset: 100000, Length: 1},
+		"source overflows":     {Type: fdiff.OpCopy, SourceOffset: 1000, Length: ^uint64(0)},
+		"source is too long":   {Type: fdiff.OpCopy, SourceOffset: 0, Length: 100001},
+	}
+
+	for name, op := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			data := randomData(100000, 1)
+			writeFile(t, filepath.Join(dir, "file"), data)
+			p := fdiff.Patch{Ops: []fdiff.Op{{Type: fdiff.OpData, Length: 3, Data: []byte("abc")}, op}}
+
+			// Action
+			err := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+			// Assert
+			assert.EqualError(t, err, fmt.Sprintf("copy %d bytes from offset %d: the old data is too short", op.Length, op.SourceOffset))
+			assert.Equal(t, data, readBytes(t, filepath.Join(dir, "file")))
+			assert.NoFileExists(t, filepath.Join(dir, "journal"))
+		})
+	}
+}
+
+func TestPatch_ApplyInPlace_WhenCopiesOverlapTheirTargets(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data, tail := randomData(13<<20, 1), randomData(1000, 2)
+	writeFile(t, filepath.Join(dir, "file"), append(append(append([]byte{}, data...), make([]byte, 7<<20)...), tail...))
+	// the last copy overwrites the source of the last step of the shift, so it is executed last
+	// and the patch is interrupted before it
+	p := fdiff.Patch{Ops: []fdiff.Op{
+		{Type: fdiff.OpData, Length: 5, Data: []byte("12345")},
+		{Type: fdiff.OpCopy, SourceOffset: 1 << 20, Length: 12 << 20},
+		{Type: fdiff.OpCopy, SourceOffset: 20 << 20, Length: 1000},
+	}}
+	expected := append(append([]byte("12345"), data[1<<20:]...), tail...)
+
+	// Action
+	restore := interruptAt(func(steps int) int { return steps - 1 })
+	interruptedErr := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+	restore()
+	journal, err := os.Stat(filepath.Join(dir, "journal"))
+	assert.Nil(t, err)
+	err = p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+	// Assert
+	assert.ErrorIs(t, interruptedErr, errInterrupted)
+	assert.Greater(t, journal.Size(), int64(4<<20))
+	assert.Less(t, journal.Size(), int64(4<<20+1000))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, readBytes(t, filepath.Join(dir, "file")))
+}
+
+func TestPatch_ApplyInPlace_WhenItIsResumedFromRedo(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data := randomData(101000, 1)
+	p := fdiff.Patch{Ops: []fdiff.Op{{Type: fdiff.OpCopy, SourceOffset: 1000, Length: 100000}}}
+	// the patch is interrupted before its first step, when the journal is ready
+	writeFile(t, filepath.Join(dir, "file"), data)
+	restore := interruptAt(func(int) int { return 0 })
+	assert.ErrorIs(t, p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal")), errInterrupted)
+	restore()
+	journal := readBytes(t, filepath.Join(dir, "journal"))
+	assert.Equal(t, []byte{2, 0}, journal[len(journal)-2:])
+	// the source of the copy is saved in the redo area and the copy is half-written
+	redo := binary.AppendUvarint([]byte{3, 0}, 100000)
+	redo = binary.BigEndian.AppendUint32(redo, crc32.ChecksumIEEE(data[1000:]))
+	redo = binary.BigEndian.AppendUint32(redo, crc32.ChecksumIEEE(redo))
+	journal = append(append(append(journal, redo...), make([]byte, 128-len(redo))...), data[1000:]...)
+	writeFile(t, filepath.Join(dir, "journal"), journal)
+	halfWritten := append([]byte{}, data...)
+	copy(halfWritten, data[1000:51000])
+	writeFile(t, filepath.Join(dir, "file"), halfWritten)
+
+	// Action
+	err := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, data[1000:], readBytes(t, filepath.Join(dir, "file")))
+	assert.NoFileExists(t, filepath.Join(dir, "journal"))
+}
+
+func TestPatch_ApplyInPlace_WhenItIsResumedWithSavedStep(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data, tail := randomData(300000, 1), randomData(1000, 2)
+	writeFile(t, filepath.Join(dir, "file"), append(append(append([]byte{}, data...), make([]byte, 100000)...), tail...))
+	// the swapped halves depend on each other, so the source of one of them is saved in the journal
+	// before the patch is interrupted
+	p := fdiff.Patch{Ops: []fdiff.Op{
+		{Type: fdiff.OpCopy, SourceOffset: 150000, Length: 150000},
+		{Type: fdiff.OpCopy, SourceOffset: 0, Length: 150000},
+		{Type: fdiff.OpCopy, SourceOffset: 400000, Length: 1000},
+	}}
+	expected := append(append(append([]byte{}, data[150000:]...), data[:150000]...), tail...)
+
+	// Action
+	restore := interruptAt(func(int) int { return 0 })
+	interruptedErr := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+	restore()
+	journal := readBytes(t, filepath.Join(dir, "journal"))
+	// the source of the saved copy is changed in the file, so it can be read only from the journal
+	saved := readBytes(t, filepath.Join(dir, "file"))
+	source := 150000
+	if bytes.Contains(journal, data[:150000]) {
+		source = 0
+	}
+	copy(saved[source:source+150000], randomData(150000, 3))
+	writeFile(t, filepath.Join(dir, "file"), saved)
+	err := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+	// Assert
+	assert.ErrorIs(t, interruptedErr, errInterrupted)
+	assert.Equal(t, []byte{2, 0}, journal[len(journal)-2:])
+	assert.Nil(t, err)
+	assert.Equal(t, expected, readBytes(t, filepath.Join(dir, "file")))
+}
+
+// errInterrupted is returned by ApplyInPlace when a test interrupts it.
+var errInterrupted = errors.New("interrupted")
+
+// interruptAt interrupt ApplyInPlace before the step that 'at' return for the number of all steps,
+// until the returned function is called.
+func interruptAt(at func(steps int) int) func() {
+	return fdiff.SetInPlaceInterrupt(func(done, steps int) error {
+		if done == at(steps) {
+			return errInterrupted
+		}
+		return nil
+	})
+}
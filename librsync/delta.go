@@ -0,0 +1,319 @@
+package librsync
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/EmilGeorgiev/fdiff"
+)
+
+// commands of a delta.
+const (
+	// opEnd is the end of the delta.
+	opEnd = 0x00
+
+	// opLiteral1 is a literal with 1 byte. The commands up to opLiteral64 contain
+	// the length of the literal in the command itself.
+	opLiteral1 = 0x01
+
+	// opLiteral64 is a literal with 64 bytes.
+	opLiteral64 = 0x40
+
+	// opLiteralN1 is a literal whose length is written in 1 byte after the command. The
+	// next 3 commands are literals with length in 2, 4 and 8 bytes.
+	opLiteralN1 = 0x41
+
+	// opCopyN1N1 is a copy whose offset and length are written in 1 byte. The next 15
+	// commands are copies with offset and length in 1, 2, 4 or 8 bytes, ordered by the
+	// size of the offset and then by the size of the length.
+	opCopyN1N1 = 0x45
+
+	// opCopyN8N8 is a copy whose offset and length are written in 8 bytes.
+	opCopyN8N8 = 0x54
+)
+
+// ComputeDelta find the operations that create the data in 'r' from the basis file of the signature.
+// It moves a window with the length of the blocks over the data byte by byte. When the weak
+// and the strong checksums of the window match a block, the window is copied from the basis file.
+func ComputeDelta(sig Signature, r io.Reader) ([]fdiff.Op, error) {
+	if _, err := sig.Magic.newStrongHash(); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := map[uint32][]int{}
+	for i, b := range sig.Blocks {
+		blocks[b.Weak] = append(blocks[b.Weak], i)
+	}
+
+	var ops []fdiff.Op
+	literalStart := 0
+	// next is the block after the last copied block. It is preferred, so the copies can be merged.
+	next := -1
+	weak := sig.Magic.newWeakSum()
+	p := 0
+	end := windowEnd(p, len(data), sig.BlockLength)
+	weak.update(data[p:end])
+	for p < len(data) {
+		idx, err := findBlock(sig, blocks[weak.digest()], data[p:end], next)
+		if err != nil {
+			return nil, err
+		}
+		if idx >= 0 {
+			if literalStart < p {
+				ops = appendOp(ops, literalOp(data[literalStart:p]))
+			}
+			ops = appendOp(ops, fdiff.Op{
+				Type:         fdiff.OpCopy,
+				SourceOffset: uint64(idx) * uint64(sig.BlockLength),
+				Length:       uint64(end - p),
+			})
+			next = idx + 1
+			p, literalStart = end, end
+			end = windowEnd(p, len(data), sig.BlockLength)
+			weak.reset()
+			weak.update(data[p:end])
+			continue
+		}
+
+		if end < len(data) {
+			weak.rotate(data[p], data[end])
+			end++
+		} else {
+			weak.rollout(data[p])
+		}
+		p++
+	}
+	if literalStart < len(data) {
+		ops = appendOp(ops, literalOp(data[literalStart:]))
+	}
+	return ops, nil
+}
+
+// windowEnd return the end of the window that starts from 'p'.
+func windowEnd(p, size int, blockLength uint32) int {
+	if end := p + int(blockLength); end < size {
+		return end
+	}
+	return size
+}
+
+// findBlock return the index of the block that has the same strong checksum as the window
+// or -1 if there is no such block. 'candidates' contains the blocks with the same weak checksum.
+// Only the last block can be shorter than the length of the blocks.
+func findBlock(sig Signature, candidates []int, window []byte, preferred int) (int, error) {
+	if len(candidates) == 0 {
+		return -1, nil
+	}
+	strong, err := sig.Magic.strongSum(window, sig.StrongLength)
+	if err != nil {
+		return -1, err
+	}
+
+	found := -1
+	for _, i := range candidates {
+		if uint32(len(window)) < sig.BlockLength && i != len(sig.Blocks)-1 {
+			continue
+		}
+		if !equalSums(sig.Blocks[i].Strong, strong) {
+			continue
+		}
+		if i == preferred {
+			return i, nil
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	return found, nil
+}
+
+// literalOp return OpData with a copy of the data.
+func literalOp(data []byte) fdiff.Op {
+	return fdiff.Op{Type: fdiff.OpData, Length: uint64(len(data)), Data: append([]byte(nil), data...)}
+}
+
+// appendOp add the operation and merge it with the last one if they are contiguous copies or data.
+func appendOp(ops []fdiff.Op, op fdiff.Op) []fdiff.Op {
+	if n := len(ops); n > 0 {
+		last := &ops[n-1]
+		if last.Type == fdiff.OpCopy && op.Type == fdiff.OpCopy && last.SourceOffset+last.Length == op.SourceOffset {
+			last.Length += op.Length
+			return ops
+		}
+		if last.Type == fdiff.OpData && op.Type == fdiff.OpData {
+			last.Data = append(last.Data[:last.Length:last.Length], op.Data...)
+			last.Length += op.Length
+			return ops
+		}
+	}
+	return append(ops, op)
+}
+
+// EncodeDelta write the operations as an rdiff delta. Every integer is written with the smallest
+// possible size, as librsync does. rdiff has no command for zeros, so OpZero is written as a
+// literal with zeros.
+func EncodeDelta(w io.Writer, ops []fdiff.Op) error {
+	bw := bufio.NewWriter(w)
+	writeInt(bw, uint64(DeltaMagic), 4)
+	for _, op := range ops {
+		switch op.Type {
+		case fdiff.OpCopy:
+			offsetSize, lengthSize := intSize(op.SourceOffset), intSize(op.Length)
+			_ = bw.WriteByte(byte(opCopyN1N1 + 4*sizeIndex(offsetSize) + sizeIndex(lengthSize)))
+			writeInt(bw, op.SourceOffset, offsetSize)
+			writeInt(bw, op.Length, lengthSize)
+		case fdiff.OpData:
+			if uint64(len(op.Data)) != op.Length {
+				return fmt.Errorf("the operation has %d bytes of data instead of %d", len(op.Data), op.Length)
+			}
+			if op.Length == 0 {
+				continue
+			}
+			writeLiteralCommand(bw, op.Length)
+			_, _ = bw.Write(op.Data)
+		case fdiff.OpZero:
+			if op.Length == 0 {
+				continue
+			}
+			writeLiteralCommand(bw, op.Length)
+			for n := op.Length; n > 0; {
+				k := uint64(len(zeros))
+				if n < k {
+					k = n
+				}
+				_, _ = bw.Write(zeros[:k])
+				n -= k
+			}
+		default:
+			return fmt.Errorf("unknown operation %d", op.Type)
+		}
+	}
+	_ = bw.WriteByte(opEnd)
+	return bw.Flush()
+}
+
+// zeros is a buffer of zeros that is written instead of OpZero.
+var zeros = make([]byte, 32<<10)
+
+// writeLiteralCommand write the command of a literal with the length. The data of the literal follows it.
+func writeLiteralCommand(bw *bufio.Writer, length uint64) {
+	if length <= opLiteral64-opLiteral1+1 {
+		_ = bw.WriteByte(byte(opLiteral1 + length - 1))
+		return
+	}
+	size := intSize(length)
+	_ = bw.WriteByte(byte(opLiteralN1 + sizeIndex(size)))
+	writeInt(bw, length, size)
+}
+
+// DecodeDelta read an rdiff delta and return its operations.
+func DecodeDelta(r io.Reader) ([]fdiff.Op, error) {
+	br := bufio.NewReader(r)
+	magic, err := readInt(br, 4)
+	if err != nil || Magic(magic) != DeltaMagic {
+		return nil, errors.New("the data is not an rdiff delta")
+	}
+
+	var ops []fdiff.Op
+	for {
+		cmd, err := br.ReadByte()
+		if err != nil {
+			return nil, errors.New("the delta is truncated")
+		}
+		switch {
+		case cmd == opEnd:
+			return ops, nil
+		case cmd <= opLiteral64:
+			if ops, err = readLiteral(br, ops, uint64(cmd-opLiteral1+1)); err != nil {
+				return nil, err
+			}
+		case cmd < opCopyN1N1:
+			length, err := readInt(br, 1<<(cmd-opLiteralN1))
+			if err != nil {
+				return nil, err
+			}
+			if ops, err = readLiteral(br, ops, length); err != nil {
+				return nil, err
+			}
+		case cmd <= opCopyN8N8:
+			offset, err := readInt(br, 1<<((cmd-opCopyN1N1)/4))
+			if err != nil {
+				return nil, err
+			}
+			length, err := readInt(br, 1<<((cmd-opCopyN1N1)%4))
+			if err != nil {
+				return nil, err
+			}
+			ops = appendOp(ops, fdiff.Op{Type: fdiff.OpCopy, SourceOffset: offset, Length: length})
+		default:
+			return nil, fmt.Errorf("unknown command %#x", cmd)
+		}
+	}
+}
+
+// readLiteral read a literal with 'length' bytes and add it to the operations.
+func readLiteral(br *bufio.Reader, ops []fdiff.Op, length uint64) ([]fdiff.Op, error) {
+	data, err := io.ReadAll(io.LimitReader(br, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != length {
+		return nil, errors.New("the delta is truncated")
+	}
+	return appendOp(ops, fdiff.Op{Type: fdiff.OpData, Length: length, Data: data}), nil
+}
+
+// intSize return the smallest size (1, 2, 4 or 8 bytes) in which 'v' can be written.
+func intSize(v uint64) int {
+	switch {
+	case v <= 0xff:
+		return 1
+	case v <= 0xffff:
+		return 2
+	case v <= 0xffffffff:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// sizeIndex return the index of the size of an integer in the commands.
+func sizeIndex(size int) int {
+	switch size {
+	case 1:
+		return 0
+	case 2:
+		return 1
+	case 4:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// writeInt write 'v' in 'size' bytes big-endian.
+func writeInt(bw *bufio.Writer, v uint64, size int) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	_, _ = bw.Write(b[8-size:])
+}
+
+// readInt read an integer with 'size' bytes big-endian.
+func readInt(br *bufio.Reader, size int) (uint64, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return 0, errors.New("the delta is truncated")
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
@@ -0,0 +1,77 @@
+// Package librsync reads and writes the signature and delta formats of
+// librsync, which are used by the rdiff tool (rdiff signature/delta/patch).
+//
+// An rdiff signature splits the basis file in blocks with fixed length. For
+// every block it contains a weak rolling checksum (rollsum or RabinKarp) and
+// a strong checksum (MD4 or BLAKE2b), that may be truncated. A delta contains
+// commands that copy ranges of the basis file and literal data. All integers
+// in both formats are big-endian.
+package librsync
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/md4" //nolint:staticcheck // MD4 is needed for compatibility with rdiff
+)
+
+// Magic is the number in the beginning of every signature and delta file.
+type Magic uint32
+
+const (
+	// DeltaMagic is the magic number of a delta.
+	DeltaMagic Magic = 0x72730236
+
+	// MD4SigMagic is the magic number of a signature with rollsum and MD4.
+	MD4SigMagic Magic = 0x72730136
+
+	// Blake2SigMagic is the magic number of a signature with rollsum and BLAKE2b.
+	Blake2SigMagic Magic = 0x72730137
+
+	// RKMD4SigMagic is the magic number of a signature with RabinKarp and MD4.
+	RKMD4SigMagic Magic = 0x72730146
+
+	// RKBlake2SigMagic is the magic number of a signature with RabinKarp and BLAKE2b.
+	// It is the default of rdiff since librsync 2.2.
+	RKBlake2SigMagic Magic = 0x72730147
+)
+
+// DefaultBlockLength is the default length of the blocks of a signature.
+const DefaultBlockLength = 2048
+
+// newStrongHash return the strong hash of the signature type and its length.
+func (m Magic) newStrongHash() (hash.Hash, error) {
+	switch m {
+	case MD4SigMagic, RKMD4SigMagic:
+		return md4.New(), nil
+	case Blake2SigMagic, RKBlake2SigMagic:
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("unknown signature type %#x", uint32(m))
+	}
+}
+
+// newWeakSum return a new weak rolling checksum of the signature type.
+func (m Magic) newWeakSum() weakSum {
+	if m == RKMD4SigMagic || m == RKBlake2SigMagic {
+		return newRabinKarp()
+	}
+	return &rollsum{}
+}
+
+// strongSum return the strong checksum of the data truncated to 'length' bytes.
+func (m Magic) strongSum(data []byte, length uint32) ([]byte, error) {
+	h, err := m.newStrongHash()
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data)
+	return h.Sum(nil)[:length], nil
+}
+
+// equalSums compare two strong checksums.
+func equalSums(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
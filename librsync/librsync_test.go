@@ -0,0 +1,305 @@
+package librsync_test
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/librsync"
+	"github.com/stretchr/testify/assert"
+)
+
+// golden is a signature and a delta in testdata that are created by rdiff.
+type golden struct {
+	name         string
+	base         string
+	magic        librsync.Magic
+	blockLength  uint32
+	strongLength uint32
+}
+
+// goldenMagics contains the magic numbers of the signatures by the names of their hashes in testdata.
+var goldenMagics = map[string]librsync.Magic{
+	"md4":      librsync.MD4SigMagic,
+	"blake2":   librsync.Blake2SigMagic,
+	"rkmd4":    librsync.RKMD4SigMagic,
+	"rkblake2": librsync.RKBlake2SigMagic,
+}
+
+// goldenFiles return the signatures and the deltas in testdata. Their names are
+// <base>-<md4|blake2|rkmd4|rkblake2>-<block length>-<strong length>.
+func goldenFiles(t *testing.T) []golden {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.signature"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	var result []golden
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".signature")
+		parts := strings.Split(name, "-")
+		assert.Len(t, parts, 4)
+		blockLength, err := strconv.Atoi(parts[2])
+		assert.NoError(t, err)
+		strongLength, err := strconv.Atoi(parts[3])
+		assert.NoError(t, err)
+		magic, ok := goldenMagics[parts[1]]
+		assert.True(t, ok, "unknown hash of %s", name)
+		result = append(result, golden{
+			name:         name,
+			base:         parts[0],
+			magic:        magic,
+			blockLength:  uint32(blockLength),
+			strongLength: uint32(strongLength),
+		})
+	}
+	return result
+}
+
+func TestSign(t *testing.T) {
+	for _, g := range goldenFiles(t) {
+		t.Run(g.name, func(t *testing.T) {
+			// SetUp
+			old := readFile(t, g.base+".old")
+			expected := readFile(t, g.name+".signature")
+
+			// Action
+			sig, err := librsync.Sign(bytes.NewReader(old), g.magic, g.blockLength, g.strongLength)
+			var actual bytes.Buffer
+			_, writeErr := sig.WriteTo(&actual)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.NoError(t, writeErr)
+			assert.Equal(t, expected, actual.Bytes())
+		})
+	}
+}
+
+func TestReadSignature(t *testing.T) {
+	for _, g := range goldenFiles(t) {
+		t.Run(g.name, func(t *testing.T) {
+			// SetUp
+			old := readFile(t, g.base+".old")
+			expected, err := librsync.Sign(bytes.NewReader(old), g.magic, g.blockLength, g.strongLength)
+			assert.NoError(t, err)
+
+			// Action
+			actual, err := librsync.ReadSignature(bytes.NewReader(readFile(t, g.name+".signature")))
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestReadSignature_WhenSignatureIsTruncated(t *testing.T) {
+	// SetUp
+	data := readFile(t, "001-md4-777-15.signature")
+
+	// Action
+	_, err := librsync.ReadSignature(bytes.NewReader(data[:len(data)-1]))
+
+	// Assert
+	assert.EqualError(t, err, "the signature is truncated after 9 blocks and 18 bytes")
+}
+
+func TestDecodeDelta(t *testing.T) {
+	for _, g := range goldenFiles(t) {
+		t.Run(g.name, func(t *testing.T) {
+			// SetUp
+			old := readFile(t, g.base+".old")
+			expected := readFile(t, g.base+".new")
+
+			// Action
+			ops, err := librsync.DecodeDelta(bytes.NewReader(readFile(t, g.name+".delta")))
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, expected, applyOps(t, old, ops))
+		})
+	}
+}
+
+func TestEncodeDelta(t *testing.T) {
+	for _, g := range goldenFiles(t) {
+		t.Run(g.name, func(t *testing.T) {
+			// SetUp
+			expected := readFile(t, g.name+".delta")
+			ops, err := librsync.DecodeDelta(bytes.NewReader(expected))
+			assert.NoError(t, err)
+
+			// Action
+			var actual bytes.Buffer
+			err = librsync.EncodeDelta(&actual, ops)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, expected, actual.Bytes())
+		})
+	}
+}
+
+func TestEncodeDelta_WithZeroOps(t *testing.T) {
+	// SetUp
+	old := []byte("the old data")
+	ops := []fdiff.Op{
+		{Type: fdiff.OpZero, Length: 100000},
+		{Type: fdiff.OpCopy, SourceOffset: 4, Length: 3},
+		{Type: fdiff.OpZero, Length: 3},
+	}
+
+	// Action
+	var delta bytes.Buffer
+	err := librsync.EncodeDelta(&delta, ops)
+	decoded, decodeErr := librsync.DecodeDelta(&delta)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, decodeErr)
+	assert.Equal(t, applyOps(t, old, ops), applyOps(t, old, decoded))
+}
+
+func TestComputeDelta(t *testing.T) {
+	for _, g := range goldenFiles(t) {
+		t.Run(g.name, func(t *testing.T) {
+			// SetUp
+			old := readFile(t, g.base+".old")
+			newData := readFile(t, g.base+".new")
+			sig, err := librsync.ReadSignature(bytes.NewReader(readFile(t, g.name+".signature")))
+			assert.NoError(t, err)
+
+			// Action
+			ops, err := librsync.ComputeDelta(sig, bytes.NewReader(newData))
+			var delta bytes.Buffer
+			encodeErr := librsync.EncodeDelta(&delta, ops)
+			decoded, decodeErr := librsync.DecodeDelta(&delta)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.NoError(t, encodeErr)
+			assert.NoError(t, decodeErr)
+			assert.Equal(t, newData, applyOps(t, old, decoded))
+			assert.LessOrEqual(t, literalSize(decoded), literalSize(goldenOps(t, g.name)))
+		})
+	}
+}
+
+func TestComputeDelta_WithRabinKarp(t *testing.T) {
+	for _, magic := range []librsync.Magic{librsync.RKMD4SigMagic, librsync.RKBlake2SigMagic} {
+		// SetUp
+		rnd := rand.New(rand.NewSource(1))
+		old := make([]byte, 100000)
+		rnd.Read(old)
+		newData := append(append(append([]byte{}, old[:30000]...), "inserted data"...), old[30100:]...)
+		sig, err := librsync.Sign(bytes.NewReader(old), magic, 1024, 8)
+		assert.NoError(t, err)
+
+		// Action
+		ops, err := librsync.ComputeDelta(sig, bytes.NewReader(newData))
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Equal(t, newData, applyOps(t, old, ops))
+		assert.Less(t, literalSize(ops), 2*1024)
+	}
+}
+
+func TestSign_WithRabinKarpOfRdiff(t *testing.T) {
+	requireTool(t, "rdiff")
+	cases := map[string]librsync.Magic{"md4": librsync.RKMD4SigMagic, "blake2": librsync.RKBlake2SigMagic}
+	for hash, magic := range cases {
+		t.Run(hash, func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			rnd := rand.New(rand.NewSource(1))
+			old := make([]byte, 100000)
+			rnd.Read(old)
+			newData := append(append(append([]byte{}, old[:30000]...), "inserted data"...), old[30100:]...)
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, "old"), old, 0o644))
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, "new"), newData, 0o644))
+			rdiff(t, "signature", "-R", "rabinkarp", "-H", hash, "-b", "512", "-S", "16", filepath.Join(dir, "old"), filepath.Join(dir, "sig"))
+			rdiff(t, "delta", filepath.Join(dir, "sig"), filepath.Join(dir, "new"), filepath.Join(dir, "delta"))
+			expected, err := os.ReadFile(filepath.Join(dir, "sig"))
+			assert.NoError(t, err)
+			delta, err := os.ReadFile(filepath.Join(dir, "delta"))
+			assert.NoError(t, err)
+
+			// Action
+			sig, err := librsync.Sign(bytes.NewReader(old), magic, 512, 16)
+			var actual bytes.Buffer
+			_, writeErr := sig.WriteTo(&actual)
+			ops, decodeErr := librsync.DecodeDelta(bytes.NewReader(delta))
+
+			// Assert
+			assert.NoError(t, err)
+			assert.NoError(t, writeErr)
+			assert.NoError(t, decodeErr)
+			assert.Equal(t, expected, actual.Bytes())
+			assert.Equal(t, newData, applyOps(t, old, ops))
+		})
+	}
+}
+
+func TestDecodeDelta_WhenDataIsNotDelta(t *testing.T) {
+	// Action
+	_, err := librsync.DecodeDelta(bytes.NewReader(readFile(t, "001-md4-777-15.signature")))
+
+	// Assert
+	assert.EqualError(t, err, "the data is not an rdiff delta")
+}
+
+// goldenOps return the operations of the delta created by rdiff.
+func goldenOps(t *testing.T, name string) []fdiff.Op {
+	ops, err := librsync.DecodeDelta(bytes.NewReader(readFile(t, name+".delta")))
+	assert.NoError(t, err)
+	return ops
+}
+
+// applyOps create the new data from the old data and the operations.
+func applyOps(t *testing.T, old []byte, ops []fdiff.Op) []byte {
+	b := bytes.NewBuffer([]byte{})
+	assert.NoError(t, fdiff.Patch{Ops: ops}.Apply(bytes.NewReader(old), b))
+	return b.Bytes()
+}
+
+// literalSize return the number of the bytes that are not copied from the old data.
+func literalSize(ops []fdiff.Op) int {
+	size := 0
+	for _, op := range ops {
+		if op.Type == fdiff.OpData {
+			size += len(op.Data)
+		}
+	}
+	return size
+}
+
+// requireTool skip the test when the tool is not installed. In CI the tools are installed,
+// so there the test fails instead of being skipped.
+func requireTool(t *testing.T, name string) {
+	if _, err := exec.LookPath(name); err != nil {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("%s is not installed", name)
+		}
+		t.Skipf("%s is not installed", name)
+	}
+}
+
+// rdiff run rdiff with the arguments and fail the test when it fails.
+func rdiff(t *testing.T, args ...string) {
+	if out, err := exec.Command("rdiff", args...).CombinedOutput(); err != nil {
+		t.Fatalf("rdiff %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+}
+
+func readFile(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	assert.NoError(t, err)
+	return data
+}
@@ -0,0 +1,106 @@
+package librsync
+
+// weakSum is a weak rolling checksum over a window of bytes.
+type weakSum interface {
+	// update add the bytes to the end of the window.
+	update(data []byte)
+
+	// rotate remove the byte 'out' from the beginning of the window and add the byte 'in' to its end.
+	rotate(out, in byte)
+
+	// rollout remove the byte 'out' from the beginning of the window.
+	rollout(out byte)
+
+	// digest return the value of the checksum.
+	digest() uint32
+
+	// reset clear the window.
+	reset()
+}
+
+// rollsumCharOffset is added to every byte, so sequences of zeros have different checksums.
+const rollsumCharOffset = 31
+
+// rollsum is the rolling checksum of rsync, which is based on Adler-32.
+type rollsum struct {
+	count  uint16
+	s1, s2 uint16
+}
+
+func (r *rollsum) update(data []byte) {
+	for _, b := range data {
+		r.s1 += uint16(b) + rollsumCharOffset
+		r.s2 += r.s1
+		r.count++
+	}
+}
+
+func (r *rollsum) rotate(out, in byte) {
+	r.s1 += uint16(in) - uint16(out)
+	r.s2 += r.s1 - r.count*(uint16(out)+rollsumCharOffset)
+}
+
+func (r *rollsum) rollout(out byte) {
+	r.s1 -= uint16(out) + rollsumCharOffset
+	r.s2 -= r.count * (uint16(out) + rollsumCharOffset)
+	r.count--
+}
+
+func (r *rollsum) digest() uint32 {
+	return uint32(r.s2)<<16 | uint32(r.s1)
+}
+
+func (r *rollsum) reset() {
+	*r = rollsum{}
+}
+
+const (
+	// rabinKarpSeed is the initial value of the hash. It makes blocks of zeros with
+	// different length to have different hashes.
+	rabinKarpSeed = 1
+
+	// rabinKarpMult is the multiplier of the hash.
+	rabinKarpMult = 0x08104225
+
+	// rabinKarpInvM is the inverse of rabinKarpMult modulo 2^32.
+	rabinKarpInvM = 0x98f009ad
+
+	// rabinKarpAdj adjust the seed when a byte is removed. It is (rabinKarpMult - 1) * rabinKarpSeed.
+	rabinKarpAdj = 0x08104224
+)
+
+// rabinKarp is the polynomial rolling hash of librsync 2.2.
+type rabinKarp struct {
+	hash uint32
+
+	// mult is rabinKarpMult powered with the number of the bytes in the window.
+	mult uint32
+}
+
+func newRabinKarp() *rabinKarp {
+	return &rabinKarp{hash: rabinKarpSeed, mult: 1}
+}
+
+func (r *rabinKarp) update(data []byte) {
+	for _, b := range data {
+		r.hash = r.hash*rabinKarpMult + uint32(b)
+		r.mult *= rabinKarpMult
+	}
+}
+
+func (r *rabinKarp) rotate(out, in byte) {
+	r.hash = r.hash*rabinKarpMult + uint32(in) - r.mult*(uint32(out)+rabinKarpAdj)
+}
+
+func (r *rabinKarp) rollout(out byte) {
+	r.mult *= rabinKarpInvM
+	r.hash -= r.mult * (uint32(out) + rabinKarpAdj)
+}
+
+func (r *rabinKarp) digest() uint32 {
+	return r.hash
+}
+
+func (r *rabinKarp) reset() {
+	*r = *newRabinKarp()
+}
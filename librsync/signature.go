@@ -0,0 +1,144 @@
+package librsync
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Block contains the checksums of one block of the basis file.
+type Block struct {
+	// Weak is the rolling checksum of the block.
+	Weak uint32
+
+	// Strong is the strong checksum of the block truncated to Signature.StrongLength bytes.
+	Strong []byte
+}
+
+// Signature is an rdiff signature of a file.
+//
+// The format is:
+//
+//	magic (4 bytes) | block length (4 bytes) | strong length (4 bytes) | blocks
+//
+// Every block is the weak checksum (4 bytes) followed by the strong checksum.
+type Signature struct {
+	// Magic show which weak and strong checksums are used.
+	Magic Magic
+
+	// BlockLength is the length of the blocks. Only the last block may be shorter.
+	BlockLength uint32
+
+	// StrongLength is the length of the strong checksums.
+	StrongLength uint32
+
+	// Blocks contains the checksums of the blocks in the order of the file.
+	Blocks []Block
+}
+
+// Sign read the data from 'r' and create its signature. If 'strongLength' is 0
+// the full length of the strong checksum is used.
+func Sign(r io.Reader, magic Magic, blockLength, strongLength uint32) (Signature, error) {
+	h, err := magic.newStrongHash()
+	if err != nil {
+		return Signature{}, err
+	}
+	if blockLength == 0 {
+		return Signature{}, errors.New("the block length must be positive")
+	}
+	if strongLength == 0 {
+		strongLength = uint32(h.Size())
+	}
+	if strongLength > uint32(h.Size()) {
+		return Signature{}, fmt.Errorf("the strong length %d is bigger than %d", strongLength, h.Size())
+	}
+
+	sig := Signature{Magic: magic, BlockLength: blockLength, StrongLength: strongLength}
+	block := make([]byte, blockLength)
+	br := bufio.NewReader(r)
+	for {
+		n, err := io.ReadFull(br, block)
+		if n > 0 {
+			w := magic.newWeakSum()
+			w.update(block[:n])
+			strong, err := magic.strongSum(block[:n], strongLength)
+			if err != nil {
+				return Signature{}, err
+			}
+			sig.Blocks = append(sig.Blocks, Block{Weak: w.digest(), Strong: strong})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return Signature{}, err
+		}
+	}
+}
+
+// WriteTo write the signature to 'w' in the format of rdiff.
+func (s Signature) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header, uint32(s.Magic))
+	binary.BigEndian.PutUint32(header[4:], s.BlockLength)
+	binary.BigEndian.PutUint32(header[8:], s.StrongLength)
+	m, _ := bw.Write(header)
+	n += int64(m)
+
+	weak := make([]byte, 4)
+	for _, b := range s.Blocks {
+		if uint32(len(b.Strong)) != s.StrongLength {
+			return n, fmt.Errorf("the strong checksum has %d bytes instead of %d", len(b.Strong), s.StrongLength)
+		}
+		binary.BigEndian.PutUint32(weak, b.Weak)
+		m, _ = bw.Write(weak)
+		n += int64(m)
+		m, _ = bw.Write(b.Strong)
+		n += int64(m)
+	}
+	return n, bw.Flush()
+}
+
+// ReadSignature read a signature in the format of rdiff.
+func ReadSignature(r io.Reader) (Signature, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return Signature{}, errors.New("the data is not an rdiff signature")
+	}
+
+	sig := Signature{
+		Magic:        Magic(binary.BigEndian.Uint32(header)),
+		BlockLength:  binary.BigEndian.Uint32(header[4:]),
+		StrongLength: binary.BigEndian.Uint32(header[8:]),
+	}
+	h, err := sig.Magic.newStrongHash()
+	if err != nil {
+		return Signature{}, err
+	}
+	if sig.BlockLength == 0 {
+		return Signature{}, errors.New("the block length must be positive")
+	}
+	if sig.StrongLength == 0 || sig.StrongLength > uint32(h.Size()) {
+		return Signature{}, fmt.Errorf("invalid strong length %d", sig.StrongLength)
+	}
+
+	block := make([]byte, 4+sig.StrongLength)
+	for {
+		n, err := io.ReadFull(br, block)
+		if err == io.EOF {
+			return sig, nil
+		}
+		if err != nil {
+			return Signature{}, fmt.Errorf("the signature is truncated after %d blocks and %d bytes", len(sig.Blocks), n)
+		}
+		sig.Blocks = append(sig.Blocks, Block{
+			Weak:   binary.BigEndian.Uint32(block),
+			Strong: append([]byte(nil), block[4:]...),
+		})
+	}
+}
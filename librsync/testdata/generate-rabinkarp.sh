@@ -0,0 +1,13 @@
+#!/bin/sh
+# generate-rabinkarp.sh create the RabinKarp signatures and deltas of the files in this
+# directory with rdiff of librsync 2.2 or newer.
+set -e
+cd "$(dirname "$0")"
+for old in *.old; do
+	base="${old%.old}"
+	for hash in md4 blake2; do
+		name="$base-rk$hash-512-16"
+		rdiff signature -R rabinkarp -H "$hash" -b 512 -S 16 "$old" "$name.signature"
+		rdiff delta "$name.signature" "$base.new" "$name.delta"
+	done
+done
@@ -0,0 +1,96 @@
+package fdiff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errNotMapped is returned when a file can't be mapped to the memory, for example a pipe or an
+// empty file. The file should be read instead.
+var errNotMapped = errors.New("the file is not mapped to the memory")
+
+// mappedFile is a regular file that is mapped to the memory for reading. The chunkers scan its
+// data in place and the data of the chunks are subslices of it instead of copies, so they can be
+// used only until the file is closed.
+type mappedFile struct {
+	data []byte
+
+	// extents are the data and the holes of the file. They are found before the file is mapped.
+	extents []extent
+}
+
+// openMappedFile open the file and map it to the memory. When the file is not a regular file
+// or the mapping fails, errNotMapped is returned.
+func openMappedFile(name string) (*mappedFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays after the file is closed
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if !info.Mode().IsRegular() || size == 0 || int64(int(size)) != size {
+		return nil, errNotMapped
+	}
+	extents, err := fileExtents(f, size)
+	if err != nil {
+		return nil, err
+	}
+	data, err := mmap(f, int(size))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotMapped, err)
+	}
+	return &mappedFile{data: data, extents: extents}, nil
+}
+
+// ReadAt copy the bytes from the offset to 'p'.
+func (m *mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > int64(len(m.data)) {
+		return 0, fmt.Errorf("invalid offset %d", off)
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Size return the size of the file.
+func (m *mappedFile) Size() int64 {
+	return int64(len(m.data))
+}
+
+// Close unmap the file. It does nothing when the file is nil.
+func (m *mappedFile) Close() error {
+	if m == nil || m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
+
+// release copy the data of the delta, which can refer to the mapped file, and close the file.
+// It does nothing when the file is nil.
+func (m *mappedFile) release(d Delta) Delta {
+	if m == nil {
+		return d
+	}
+	for i := range d.NewChunks {
+		d.NewChunks[i].Data = append([]byte(nil), d.NewChunks[i].Data...)
+	}
+	for i := range d.Ops {
+		if d.Ops[i].Data != nil {
+			d.Ops[i].Data = append([]byte(nil), d.Ops[i].Data...)
+		}
+	}
+	m.Close()
+	return d
+}
@@ -0,0 +1,242 @@
+package fdiff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// MerkleNode point to a node of a Merkle tree. The leaves are on level 0 and the node with
+// the index I on level L is the parent of the leaves from I*2^L to (I+1)*2^L.
+type MerkleNode struct {
+	Level int
+	Index int
+}
+
+// MerkleNodes return the hashes of nodes of a Merkle tree. It is implemented by MerkleTree, but
+// it can be implemented by a client of a remote tree too, then DiffMerkle asks for the nodes of
+// one level of the tree with one request.
+type MerkleNodes interface {
+	// Leaves return the number of the leaves of the tree.
+	Leaves() (int, error)
+
+	// NodeHashes return the hashes of the nodes in the same order. The hash of a node
+	// without leaves is nil.
+	NodeHashes(nodes []MerkleNode) ([][]byte, error)
+}
+
+// MerkleTree is a Merkle tree over the ordered chunks of a signature. Every leaf is the hash
+// of the signature and the length of a chunk and every node is the hash of its two children.
+// When a node has only a left child, its hash is the hash of the left child, so the root of
+// the tree doesn't depend on the number of levels above the leaves and two trees with different
+// numbers of leaves can be compared node by node.
+type MerkleTree struct {
+	// levels contains the hashes of the nodes of every level. The first level contains
+	// the leaves and the last level contains only the root.
+	levels [][][]byte
+}
+
+// NewMerkleTree build a Merkle tree over the chunks in their order.
+func NewMerkleTree(chunks []Chunk) *MerkleTree {
+	leaves := make([][]byte, len(chunks))
+	for i, ch := range chunks {
+		leaves[i] = merkleLeafHash(ch)
+	}
+
+	t := &MerkleTree{levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		parents := make([][]byte, (len(level)+1)/2)
+		for i := range parents {
+			if 2*i+1 == len(level) {
+				parents[i] = level[2*i]
+				continue
+			}
+			parents[i] = merkleNodeHash(level[2*i], level[2*i+1])
+		}
+		t.levels = append(t.levels, parents)
+		level = parents
+	}
+	return t
+}
+
+// merkleRoot calculate the root of the Merkle tree of chunks that are added one by one, without keeping
+// the chunks or the tree. It has the same root as NewMerkleTree, but keeps only one hash per level:
+// pending[l] is the hash of the last complete subtree of 2^l leaves that has no right sibling yet.
+type merkleRoot struct {
+	pending [][]byte
+}
+
+// add the leaf of the chunk after the leaves that are already added.
+func (r *merkleRoot) add(ch Chunk) {
+	h := merkleLeafHash(ch)
+	for l := 0; ; l++ {
+		if l == len(r.pending) {
+			r.pending = append(r.pending, h)
+			return
+		}
+		if r.pending[l] == nil {
+			r.pending[l] = h
+			return
+		}
+		h = merkleNodeHash(r.pending[l], h)
+		r.pending[l] = nil
+	}
+}
+
+// String return the hash of the root in hex. The root of a tree without leaves is empty. A subtree
+// without a right sibling is the right child of the complete subtree on a higher level, or it is
+// the root itself.
+func (r *merkleRoot) String() string {
+	var root []byte
+	for _, h := range r.pending {
+		switch {
+		case h == nil:
+		case root == nil:
+			root = h
+		default:
+			root = merkleNodeHash(h, root)
+		}
+	}
+	if root == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", root)
+}
+
+// merkleLeafHash return the hash of the leaf of the chunk: SHA-256 of 0x00, the length
+// of the chunk as 8 bytes in big endian and the digest of the signature of the chunk.
+func merkleLeafHash(ch Chunk) []byte {
+	h := sha256.New()
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], ch.Length)
+	h.Write([]byte{0})
+	h.Write(length[:])
+	h.Write(ch.Signature.sum[:ch.Signature.size])
+	return h.Sum(nil)
+}
+
+// merkleNodeHash return the hash of a node with two children: SHA-256 of 0x01 and the hashes of the children.
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Root return the hash of the root of the tree in hex. The root of a tree without leaves is empty.
+func (t *MerkleTree) Root() string {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%x", top[0])
+}
+
+// Leaves return the number of the leaves of the tree.
+func (t *MerkleTree) Leaves() (int, error) {
+	return len(t.levels[0]), nil
+}
+
+// NodeHashes return the hashes of the nodes. The nodes above the root are the root.
+func (t *MerkleTree) NodeHashes(nodes []MerkleNode) ([][]byte, error) {
+	hashes := make([][]byte, len(nodes))
+	for i, n := range nodes {
+		hashes[i] = t.nodeHash(n)
+	}
+	return hashes, nil
+}
+
+func (t *MerkleTree) nodeHash(n MerkleNode) []byte {
+	if n.Level < 0 || n.Index < 0 {
+		return nil
+	}
+	if top := len(t.levels) - 1; n.Level > top {
+		// a node above the root contains the root in its first leftmost descendant
+		if n.Index != 0 {
+			return nil
+		}
+		n = MerkleNode{Level: top}
+	}
+	if level := t.levels[n.Level]; n.Index < len(level) {
+		return level[n.Index]
+	}
+	return nil
+}
+
+// LeafRange is a range of leaves [From, To) of a Merkle tree. The leaves are the chunks of a signature by their indexes.
+type LeafRange struct {
+	From int
+	To   int
+}
+
+// DiffMerkle find the ranges of the leaves that are different in two Merkle trees. The trees are
+// compared from the root to the leaves, level by level, and only the children of the different nodes
+// are compared. So for every different range only O(log n) hashes are exchanged. Every level needs
+// one call of NodeHashes of every tree. The chunks are compared by their indexes, so when a chunk is
+// inserted all chunks after it are different.
+func DiffMerkle(a, b MerkleNodes) ([]LeafRange, error) {
+	la, err := a.Leaves()
+	if err != nil {
+		return nil, err
+	}
+	lb, err := b.Leaves()
+	if err != nil {
+		return nil, err
+	}
+	leaves := la
+	if lb > leaves {
+		leaves = lb
+	}
+	if leaves == 0 {
+		return nil, nil
+	}
+
+	top := 0
+	for 1<<top < leaves {
+		top++
+	}
+
+	var ranges []LeafRange
+	nodes := []MerkleNode{{Level: top}}
+	for len(nodes) > 0 {
+		ha, err := a.NodeHashes(nodes)
+		if err != nil {
+			return nil, err
+		}
+		hb, err := b.NodeHashes(nodes)
+		if err != nil {
+			return nil, err
+		}
+		if len(ha) != len(nodes) || len(hb) != len(nodes) {
+			return nil, fmt.Errorf("expected %d node hashes, got %d and %d", len(nodes), len(ha), len(hb))
+		}
+
+		var children []MerkleNode
+		for i, n := range nodes {
+			if bytes.Equal(ha[i], hb[i]) {
+				continue
+			}
+			if n.Level == 0 {
+				ranges = appendLeafRange(ranges, n.Index)
+				continue
+			}
+			children = append(children, MerkleNode{Level: n.Level - 1, Index: 2 * n.Index})
+			if right := (2*n.Index + 1) << (n.Level - 1); right < leaves {
+				children = append(children, MerkleNode{Level: n.Level - 1, Index: 2*n.Index + 1})
+			}
+		}
+		nodes = children
+	}
+	return ranges, nil
+}
+
+// appendLeafRange add the leaf to the ranges. The leaves are added in increasing order.
+func appendLeafRange(ranges []LeafRange, leaf int) []LeafRange {
+	if n := len(ranges); n > 0 && ranges[n-1].To == leaf {
+		ranges[n-1].To++
+		return ranges
+	}
+	return append(ranges, LeafRange{From: leaf, To: leaf + 1})
+}
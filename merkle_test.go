@@ -0,0 +1,132 @@
+package fdiff_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleTree_Root(t *testing.T) {
+	// SetUp
+	chunks := merkleChunks(1000, "old")
+	changed := append([]fdiff.Chunk{}, chunks...)
+	changed[500].Signature = signatureOf("changed")
+	resized := append([]fdiff.Chunk{}, chunks...)
+	resized[500].Length++
+
+	// Action
+	root := fdiff.NewMerkleTree(chunks).Root()
+
+	// Assert
+	assert.Len(t, root, 64)
+	assert.Equal(t, root, fdiff.NewMerkleTree(merkleChunks(1000, "old")).Root())
+	assert.NotEqual(t, root, fdiff.NewMerkleTree(changed).Root())
+	assert.NotEqual(t, root, fdiff.NewMerkleTree(resized).Root())
+	assert.NotEqual(t, root, fdiff.NewMerkleTree(chunks[:999]).Root())
+	assert.Equal(t, "", fdiff.NewMerkleTree(nil).Root())
+}
+
+func TestDiffMerkle(t *testing.T) {
+	// SetUp
+	oldChunks := merkleChunks(1000, "old")
+	newChunks := append([]fdiff.Chunk{}, oldChunks...)
+	newChunks[10].Signature = signatureOf("changed")
+	newChunks[11].Signature = signatureOf("changed")
+	newChunks[700].Signature = signatureOf("changed")
+	newChunks = append(newChunks, merkleChunks(30, "added")...)
+	a := &countingNodes{MerkleNodes: fdiff.NewMerkleTree(oldChunks)}
+	b := &countingNodes{MerkleNodes: fdiff.NewMerkleTree(newChunks)}
+
+	// Action
+	actual, err := fdiff.DiffMerkle(a, b)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, []fdiff.LeafRange{{From: 10, To: 12}, {From: 700, To: 701}, {From: 1000, To: 1030}}, actual)
+	assert.Equal(t, 12, a.calls)
+	assert.Less(t, a.nodes, 150)
+	assert.Equal(t, a.nodes, b.nodes)
+}
+
+func TestDiffMerkle_WhenTreesAreEqual(t *testing.T) {
+	// SetUp
+	a := &countingNodes{MerkleNodes: fdiff.NewMerkleTree(merkleChunks(1000, "old"))}
+	b := &countingNodes{MerkleNodes: fdiff.NewMerkleTree(merkleChunks(1000, "old"))}
+
+	// Action
+	actual, err := fdiff.DiffMerkle(a, b)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Empty(t, actual)
+	assert.Equal(t, 1, a.nodes)
+}
+
+func TestDiffMerkle_WhenTreeIsEmpty(t *testing.T) {
+	// Action
+	actual, err := fdiff.DiffMerkle(fdiff.NewMerkleTree(nil), fdiff.NewMerkleTree(merkleChunks(3, "new")))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, []fdiff.LeafRange{{From: 0, To: 3}}, actual)
+}
+
+func TestDecodeSignature_WithMerkleRoot(t *testing.T) {
+	// SetUp
+	header := fdiff.SignatureHeader{FileSize: 57, FileChecksum: "2fd4e1c67a2d28fced849ee1bb76e7391b93eb12", MerkleRoot: "a341511743dd05e7"}
+
+	// Action
+	actual, _, err := fdiff.DecodeSignature(strings.NewReader(header.String()))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, header, actual)
+}
+
+func TestSign_WithMerkleRoot(t *testing.T) {
+	for blocks := 0; blocks <= 17; blocks++ {
+		t.Run(fmt.Sprintf("%d blocks", blocks), func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "file"), randomData(blocks*512, int64(blocks)))
+			fs := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, fdiff.ChunkConfig{BlockSize: 512}, 1)
+
+			// Action
+			err := fs.Sign(filepath.Join(dir, "file"), filepath.Join(dir, "sign"))
+
+			// Assert
+			assert.Nil(t, err)
+			header, chunks, err := fdiff.DecodeSignature(strings.NewReader(readFile(filepath.Join(dir, "sign"))))
+			assert.Nil(t, err)
+			assert.Len(t, chunks, blocks)
+			assert.Equal(t, fdiff.NewMerkleTree(chunks).Root(), header.MerkleRoot)
+		})
+	}
+}
+
+// merkleChunks create n chunks with different signatures that start with the prefix.
+func merkleChunks(n int, prefix string) []fdiff.Chunk {
+	chunks := make([]fdiff.Chunk, n)
+	for i := range chunks {
+		chunks[i] = fdiff.Chunk{Offset: uint64(i * 100), Length: 100, Signature: signatureOf(fmt.Sprintf("%s-%d", prefix, i))}
+	}
+	return chunks
+}
+
+// countingNodes counts the calls of NodeHashes and the number of the requested nodes.
+type countingNodes struct {
+	fdiff.MerkleNodes
+	calls int
+	nodes int
+}
+
+func (c *countingNodes) NodeHashes(nodes []fdiff.MerkleNode) ([][]byte, error) {
+	c.calls++
+	c.nodes += len(nodes)
+	return c.MerkleNodes.NodeHashes(nodes)
+}
@@ -0,0 +1,16 @@
+package fdiff
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap map the first 'size' bytes of the file to the memory for reading.
+func mmap(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap remove the mapping that is created by mmap.
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
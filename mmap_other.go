@@ -0,0 +1,18 @@
+//go:build !linux
+
+package fdiff
+
+import (
+	"errors"
+	"os"
+)
+
+// mmap is not supported on this platform, so the files are always read.
+func mmap(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported")
+}
+
+// munmap do nothing, because mmap never maps files.
+func munmap(data []byte) error {
+	return nil
+}
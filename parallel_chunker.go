@@ -0,0 +1,386 @@
+package fdiff
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+)
+
+const (
+	// minSegmentSize is the minimum size of the segments when ParallelChunker calculates it.
+	minSegmentSize = 1 << 20
+
+	// segmentsPerJob is the number of segments per job when ParallelChunker calculates the size of the segments.
+	segmentsPerJob = 4
+)
+
+// ParallelChunker split a file to chunks with several goroutines. The created chunks are identical
+// to the chunks that are created by Chunker with the same configuration, except in sparse files:
+// their holes are found only by ParallelChunker (see Chunks), and Chunker splits their zeros like
+// any other data.
+//
+// The file is split in segments and every segment is processed by one worker. The rolling hash is never
+// reset at the boundaries of the chunks, so its value at every byte depends only on the last WindowSize
+// bytes. A worker starts WindowSize-1 bytes before its segment and finds all bytes in the segment where
+// the hash matches the break point, without knowing where the chunks start. Then the segments are
+// stitched in their order: only the minimum and the maximum sizes of the chunks depend on the previous
+// boundaries, so they are applied to the found bytes sequentially, which is cheap.
+type ParallelChunker struct {
+	config ChunkConfig
+
+	// newRollingHash is creating a new rolling hash
+	newRollingHash func([]byte) rollinghash.Hash
+
+	// newStrongHash is creating a new hash for the signatures of the chunks
+	newStrongHash func() hash.Hash
+
+	// strongHashTag is the tag of the strong hash in the signatures of the chunks
+	strongHashTag byte
+
+	// jobs is the number of the segments that are processed at the same time.
+	jobs int
+
+	// SegmentSize is the number of bytes of a segment. When it is 0, the file is split in 4
+	// segments per job, but the segments are not smaller than 1 MB.
+	SegmentSize int64
+}
+
+// NewParallelChunker initialize and return *ParallelChunker that runs 'jobs' workers. It return an
+// error if the strong hash of the configuration is not registered.
+func NewParallelChunker(new func([]byte) rollinghash.Hash, cfg ChunkConfig, jobs int) (*ParallelChunker, error) {
+	strongHash, err := getStrongHash(cfg.StrongHash)
+	if err != nil {
+		return nil, err
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &ParallelChunker{
+		config:         cfg,
+		newRollingHash: new,
+		newStrongHash:  strongHash.new,
+		strongHashTag:  strongHash.tag,
+		jobs:           jobs,
+	}, nil
+}
+
+// segment is a part of the data that is processed by one worker.
+type segment struct {
+	start int64
+	data  []byte
+
+	// breakPoints contains the positions of the bytes in the segment after which a chunk
+	// can end, because the hash of the window that ends with them matches the break point.
+	breakPoints []int64
+	err         error
+}
+
+// Chunks split the first 'size' bytes of 'r' to chunks and send them through the channel in the order
+// of their offsets. The channel is closed when all chunks are sent or an error is found.
+//
+// The signatures of the chunks are calculated by 'jobs' goroutines too.
+//
+// When 'r' is a sparse file, its holes are found with SEEK_DATA and SEEK_HOLE and every hole is sent
+// as one chunk with the signature of a hole, without reading and hashing its zeros. Every part of the
+// data between the holes is split like a whole file.
+//
+// When BlockSize of the configuration is set, the data is split to fixed-size blocks and only the
+// blocks that are whole in a hole are sent as holes. See BlockSize.
+func (pc *ParallelChunker) Chunks(r io.ReaderAt, size int64, ch chan<- Chunk) error {
+	hashers := newHasherPool(pc.newStrongHash, pc.strongHashTag, pc.jobs, ch)
+	defer hashers.close()
+
+	extents, err := extentsOf(r, size)
+	if err != nil {
+		return err
+	}
+	if pc.config.BlockSize > 0 {
+		return pc.chunkBlocks(r, size, extents, hashers)
+	}
+	for _, e := range extents {
+		if e.hole {
+			hashers.addHashed(holeChunk(e))
+			continue
+		}
+		if err = pc.chunkExtent(r, e, hashers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkExtent split the data of the extent to chunks and send them to the hashers.
+func (pc *ParallelChunker) chunkExtent(r io.ReaderAt, e extent, hashers *hasherPool) error {
+	s := stitcher{chunks: hashers, pc: pc, base: uint64(e.offset)}
+	if m, ok := r.(*mappedFile); ok && e.offset+e.length <= m.Size() {
+		s.mapped = m.data[e.offset : e.offset+e.length : e.offset+e.length]
+		r = &mappedFile{data: s.mapped}
+	} else {
+		r = io.NewSectionReader(r, e.offset, e.length)
+	}
+
+	size := e.length
+	segmentSize := pc.segmentSize(size)
+	done := make(chan struct{})
+
+	// the results of the segments are received in their order. The queue holds the segments that
+	// are processed, so at most 'jobs' segments are processed or waiting to be stitched.
+	queue := make(chan chan segment, pc.jobs-1)
+	defer func() {
+		// stop the dispatcher and wait for the workers, because they can still scan a mapped file
+		close(done)
+		for result := range queue {
+			<-result
+		}
+	}()
+	go func() {
+		defer close(queue)
+		for start := int64(0); start < size; start += segmentSize {
+			end := start + segmentSize
+			if end > size {
+				end = size
+			}
+			result := make(chan segment, 1)
+			select {
+			case queue <- result:
+			case <-done:
+				return
+			}
+			go func(start, end int64) {
+				result <- pc.findBreakPoints(r, start, end)
+			}(start, end)
+		}
+	}()
+
+	for result := range queue {
+		seg := <-result
+		if seg.err != nil {
+			return seg.err
+		}
+		s.add(seg)
+	}
+	s.finish()
+	return nil
+}
+
+// chunksOfFile split the file to chunks and return the channel through which they are received. When
+// the file is mapped to the memory, the data of the chunks are its subslices and can be used until the
+// returned file is closed. Otherwise, the returned file is nil. The error of the splitting (or nil) is
+// received through the returned error channel after the channel of the chunks is closed, because a
+// failed read closes the channel of the chunks like the end of the file.
+func (pc *ParallelChunker) chunksOfFile(file string) (<-chan Chunk, <-chan error, *mappedFile, error) {
+	chunks := make(chan Chunk, 1000)
+	errc := make(chan error, 1)
+	m, err := openMappedFile(file)
+	if err == nil {
+		go func() {
+			errc <- pc.Chunks(m, m.Size(), chunks)
+		}()
+		return chunks, errc, m, nil
+	}
+	if !errors.Is(err, errNotMapped) {
+		return nil, nil, nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		// a pipe can't be read at offsets, so it is split by one Chunker
+		b := make(chan byte, 1000)
+		c, err := NewChunker(pc.newRollingHash, pc.config, b, chunks)
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+		c.Start()
+		go func() {
+			defer f.Close()
+			errc <- sendData(f, b)
+		}()
+		return chunks, errc, nil, nil
+	}
+	go func() {
+		defer f.Close()
+		errc <- pc.Chunks(f, info.Size(), chunks)
+	}()
+	return chunks, errc, nil, nil
+}
+
+// segmentSize return the size of the segments of data with the size.
+func (pc *ParallelChunker) segmentSize(size int64) int64 {
+	if pc.SegmentSize > 0 {
+		return pc.SegmentSize
+	}
+	segmentSize := size / int64(pc.jobs*segmentsPerJob)
+	if segmentSize < minSegmentSize {
+		segmentSize = minSegmentSize
+	}
+	return segmentSize
+}
+
+// windowSize return the number of bytes in the window of the rolling hash. The Chunker
+// creates the rolling hash with at least one byte.
+func (pc *ParallelChunker) windowSize() int64 {
+	if pc.config.WindowSize < 1 {
+		return 1
+	}
+	return int64(pc.config.WindowSize)
+}
+
+// findBreakPoints read the segment [start, end) and the bytes of the window before it and find the
+// break points in the segment.
+//
+// Chunker checks the last byte of the first window twice: with the hash of the first window and, if
+// it doesn't create a chunk, after the hash is moved with the same byte again. So until the duplicated
+// byte leaves the window, the hash depends on the beginning of the data and the segments that start
+// there are read from the beginning.
+func (pc *ParallelChunker) findBreakPoints(r io.ReaderAt, start, end int64) segment {
+	w := pc.windowSize()
+	from := start - (w - 1)
+	if start < 2*w-2 {
+		from = 0
+	}
+	var buf []byte
+	if m, ok := r.(*mappedFile); ok && end <= m.Size() {
+		// the mapped file is scanned in place
+		buf = m.data[from:end:end]
+	} else {
+		buf = make([]byte, end-from)
+		if n, err := r.ReadAt(buf, from); int64(n) != end-from {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return segment{err: err}
+		}
+	}
+
+	seg := segment{start: start, data: buf[start-from:]}
+	first := from + w - 1
+	if first >= end {
+		return seg
+	}
+
+	h := pc.newRollingHash(buf[first-(w-1)-from : first+1-from])
+	breakPoint := pc.isBreakPoint(h.Value())
+	if first == w-1 && !((breakPoint && int(w) >= pc.config.MinSizeChunk) || int(w) >= pc.config.MaxSizeChunk) {
+		// the first window doesn't create a chunk, so Chunker moves the hash with its last byte again
+		h.Next(buf[first])
+		breakPoint = breakPoint || pc.isBreakPoint(h.Value())
+	}
+	for p := first; ; {
+		if breakPoint && p >= start {
+			seg.breakPoints = append(seg.breakPoints, p)
+		}
+		p++
+		if p == end {
+			return seg
+		}
+		h.Next(buf[p-from])
+		breakPoint = pc.isBreakPoint(h.Value())
+	}
+}
+
+// isBreakPoint return true when the value of the rolling hash matches the break point. It is the
+// condition of Chunker.shouldCreateAChunk without the sizes of the chunks.
+func (pc *ParallelChunker) isBreakPoint(v uint64) bool {
+	if pc.config.Discriminator != 0 {
+		v %= pc.config.Discriminator
+	}
+	return v == pc.config.FingerprintBreakPoint
+}
+
+// stitcher create the chunks from the break points of the segments in their order.
+type stitcher struct {
+	pc *ParallelChunker
+
+	// chunks calculate the signatures of the created chunks and send them.
+	chunks *hasherPool
+
+	// base is the offset of the data in the file.
+	base uint64
+
+	// offset is where the current chunk starts in the data.
+	offset uint64
+
+	// data contains the bytes of the current chunk from the previous segments.
+	data []byte
+
+	// mapped is the data of the mapped file when the chunks are created from it. The data
+	// of the chunks are its subslices instead of copies.
+	mapped []byte
+}
+
+// add create the chunks that end in the segment. Like Chunker, a chunk ends at the first byte after
+// the first window where its size reaches the maximum or at a break point if its size is at least
+// the minimum.
+func (s *stitcher) add(seg segment) {
+	end := seg.start + int64(len(seg.data))
+	breakPoints := seg.breakPoints
+	for {
+		for len(breakPoints) > 0 && breakPoints[0] < int64(s.offset) {
+			// the chunk is already created at the break point because of its maximum size
+			breakPoints = breakPoints[1:]
+		}
+		p := s.maxEnd()
+		if len(breakPoints) > 0 && breakPoints[0] < p {
+			p = breakPoints[0]
+			breakPoints = breakPoints[1:]
+			if p+1-int64(s.offset) < int64(s.pc.config.MinSizeChunk) {
+				continue
+			}
+		}
+		if p >= end {
+			s.data = s.dataTo(seg, end)
+			return
+		}
+		s.createChunk(s.dataTo(seg, p+1))
+	}
+}
+
+// dataTo return the data of the current chunk until the position 'to' in the segment.
+func (s *stitcher) dataTo(seg segment, to int64) []byte {
+	if s.mapped != nil {
+		return s.mapped[s.offset:to:to]
+	}
+	return append(s.data, seg.data[int64(s.offset)+int64(len(s.data))-seg.start:to-seg.start]...)
+}
+
+// maxEnd return the position of the byte where the current chunk reaches the maximum size.
+func (s *stitcher) maxEnd() int64 {
+	max := int64(s.pc.config.MaxSizeChunk)
+	if max < 1 {
+		max = 1
+	}
+	p := int64(s.offset) + max - 1
+	if w := s.pc.windowSize(); p < w-1 {
+		p = w - 1
+	}
+	return p
+}
+
+// finish create the last chunk from the rest of the data.
+func (s *stitcher) finish() {
+	if len(s.data) > 0 {
+		s.createChunk(s.data)
+	}
+}
+
+func (s *stitcher) createChunk(data []byte) {
+	s.chunks.add(Chunk{
+		Offset: s.base + s.offset,
+		Length: uint64(len(data)),
+		Data:   data,
+	})
+	s.offset += uint64(len(data))
+	s.data = nil
+}
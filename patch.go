@@ -0,0 +1,484 @@
+package fdiff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// OpType is the type of operation of a patch.
+type OpType byte
+
+const (
+	// OpCopy copies Length bytes of the old data starting from SourceOffset.
+	OpCopy OpType = iota + 1
+
+	// OpData writes the Data. The Data is not found in the old data.
+	OpData
+
+	// OpZero writes Length zeros. It is a hole of a sparse file, so it is recreated
+	// as a hole when it is possible.
+	OpZero
+)
+
+// Op is one operation of a patch.
+type Op struct {
+	Type OpType
+
+	// SourceOffset show from which byte of the old data is the copy. It is used only by OpCopy.
+	SourceOffset uint64
+
+	// Length is the number of bytes that the operation writes.
+	Length uint64
+
+	// Data contains the bytes that are written. It is used only by OpData.
+	Data []byte
+}
+
+// patchMagic is written in the beginning of every patch without a checksum.
+const patchMagic = "fdiff-patch/1\n"
+
+// patchChecksumMagic is written in the beginning of every patch with a checksum.
+const patchChecksumMagic = "fdiff-patch/2\n"
+
+// encoding of the literal data of OpData in a patch.
+const (
+	// dataRaw show that the data is stored as it is.
+	dataRaw byte = iota
+
+	// dataCompressed show that the data is compressed with the codec of the patch.
+	dataCompressed
+
+	// dataDictionary show that the data is compressed with DEFLATE by
+	// using a region of the old data as a preset dictionary.
+	dataDictionary
+)
+
+const (
+	// maxDictionarySize is the size of the window of DEFLATE. The bytes of a preset
+	// dictionary that are farther than it from the compressed data are not used.
+	maxDictionarySize = 32 << 10
+
+	// dictionaryBlockSize is the number of literal bytes that are compressed with one
+	// dictionary. The literal data is split in blocks, so every block can be compressed
+	// with the region of the old data that is around the same position.
+	dictionaryBlockSize = 16 << 10
+)
+
+// Patch contains the operations that create the new data from the old data.
+type Patch struct {
+	// Codec is the name of the codec that compress the literal data of the patch.
+	Codec string
+
+	// Ops are the operations in the order of the new data.
+	Ops []Op
+
+	// Checksum is the SHA-1 checksum of the new data in hex. When it is
+	// not empty Apply verifies the new data with it.
+	Checksum string
+}
+
+// NewPatch create a patch from the delta. The literal data of
+// the patch will be compressed with the codec when it is encoded.
+func NewPatch(d Delta, codec string) Patch {
+	return Patch{Codec: codec, Ops: d.Ops, Checksum: d.Checksum}
+}
+
+// Encode write the patch to 'w'. The data of every OpData is compressed separately
+// with the codec of the patch. If the compressed data is not smaller than the
+// original one, the original data is stored.
+//
+// The format of the patch is:
+//
+//	magic | [checksum] | codec | op ... | 0
+//
+// where the checksum (only in the patches with a checksum, which have another magic)
+// and the codec are stored as uvarint length followed by the value and every op
+// starts with its type:
+//
+//	OpCopy: 1 | uvarint source offset | uvarint length
+//	OpData: 2 | encoding | uvarint length | [uvarint dictionary offset | uvarint dictionary length] | [uvarint compressed length] | data
+//	OpZero: 3 | uvarint length
+func (p Patch) Encode(w io.Writer) error {
+	return p.EncodeWithDictionary(w, nil)
+}
+
+// EncodeWithDictionary write the patch to 'w' like Encode, but the literal data is also
+// compressed with DEFLATE by using the region of the old data that it replaces as a preset
+// dictionary. Changed chunks are usually small edits of the old chunks, so most of their
+// bytes are found in the dictionary. For every block of the literal data the smallest of
+// the original data, the data compressed with the codec and the data compressed with the
+// dictionary is stored. The patch can be decoded only with DecodePatchWithDictionary.
+// If 'old' is nil, the patch is encoded without dictionary.
+func (p Patch) EncodeWithDictionary(w io.Writer, old io.ReaderAt) error {
+	codec, err := GetCodec(p.Codec)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if p.Checksum == "" {
+		_, _ = bw.WriteString(patchMagic)
+	} else {
+		_, _ = bw.WriteString(patchChecksumMagic)
+		writeUvarint(bw, uint64(len(p.Checksum)))
+		_, _ = bw.WriteString(p.Checksum)
+	}
+	writeUvarint(bw, uint64(len(codec.Name())))
+	_, _ = bw.WriteString(codec.Name())
+
+	for i, op := range p.Ops {
+		switch op.Type {
+		case OpCopy:
+			_ = bw.WriteByte(byte(op.Type))
+			writeUvarint(bw, op.SourceOffset)
+			writeUvarint(bw, op.Length)
+		case OpZero:
+			_ = bw.WriteByte(byte(op.Type))
+			writeUvarint(bw, op.Length)
+		case OpData:
+			if old == nil {
+				if err = writeData(bw, codec, op.Data, dictionary{}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			offset, length := replacedRegion(p.Ops, i)
+			for r := 0; r < len(op.Data); r += dictionaryBlockSize {
+				block := op.Data[r:]
+				if len(block) > dictionaryBlockSize {
+					block = block[:dictionaryBlockSize]
+				}
+				dict, err := readDictionary(old, offset, length, uint64(r), uint64(len(block)))
+				if err != nil {
+					return err
+				}
+				if err = writeData(bw, codec, block, dict); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unknown type of operation %d", op.Type)
+		}
+	}
+	_ = bw.WriteByte(0)
+	return bw.Flush()
+}
+
+// dictionary is a region of the old data that is used as a preset dictionary of DEFLATE.
+type dictionary struct {
+	offset uint64
+	data   []byte
+}
+
+// writeData write OpData with the data in the smallest possible encoding.
+func writeData(bw *bufio.Writer, codec Codec, data []byte, dict dictionary) error {
+	encoding, encoded := dataRaw, data
+	compressed, err := codec.Compress(data)
+	if err != nil {
+		return err
+	}
+	if len(compressed) < len(encoded) {
+		encoding, encoded = dataCompressed, compressed
+	}
+	if len(dict.data) > 0 {
+		compressed, err = compressWithDictionary(data, dict.data)
+		if err != nil {
+			return err
+		}
+		// the offset and the length of the dictionary are stored too
+		if len(compressed)+2*binary.MaxVarintLen32 < len(encoded) {
+			encoding, encoded = dataDictionary, compressed
+		}
+	}
+
+	_ = bw.WriteByte(byte(OpData))
+	_ = bw.WriteByte(encoding)
+	writeUvarint(bw, uint64(len(data)))
+	switch encoding {
+	case dataDictionary:
+		writeUvarint(bw, dict.offset)
+		writeUvarint(bw, uint64(len(dict.data)))
+		writeUvarint(bw, uint64(len(encoded)))
+	case dataCompressed:
+		writeUvarint(bw, uint64(len(encoded)))
+	}
+	_, _ = bw.Write(encoded)
+	return nil
+}
+
+// replacedRegion return the offset and the length of the region of the old data that
+// is replaced by the i-th operation. This is the region between the previous and the
+// next copied bytes. If there is no such region, for example when the data is inserted,
+// the region with the same length after the previous copied bytes is returned.
+func replacedRegion(ops []Op, i int) (uint64, uint64) {
+	var start uint64
+	for j := i - 1; j >= 0; j-- {
+		if ops[j].Type == OpCopy {
+			start = ops[j].SourceOffset + ops[j].Length
+			break
+		}
+	}
+
+	for j := i + 1; j < len(ops); j++ {
+		if ops[j].Type == OpCopy {
+			if ops[j].SourceOffset > start {
+				return start, ops[j].SourceOffset - start
+			}
+			break
+		}
+	}
+
+	var length uint64
+	for j := i; j < len(ops) && ops[j].Type == OpData; j++ {
+		length += ops[j].Length
+	}
+	return start, length
+}
+
+// readDictionary read the dictionary of the block of literal data that starts from
+// 'r' in the data that replaces the region of the old data. The dictionary is the
+// part of the region around the same position as the block.
+func readDictionary(old io.ReaderAt, offset, length, r, blockLength uint64) (dictionary, error) {
+	pad := uint64(maxDictionarySize-blockLength) / 2
+	start, end := offset, offset+length
+	if offset+r > start+pad {
+		start = offset + r - pad
+	}
+	if offset+r+blockLength+pad < end {
+		end = offset + r + blockLength + pad
+	}
+	if start >= end {
+		return dictionary{}, nil
+	}
+
+	data := make([]byte, end-start)
+	n, err := old.ReadAt(data, int64(start))
+	if err != nil && err != io.EOF {
+		return dictionary{}, err
+	}
+	return dictionary{offset: start, data: data[:n]}, nil
+}
+
+// compressWithDictionary compress the data with DEFLATE by using a preset dictionary.
+func compressWithDictionary(data, dict []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodePatch read a patch that is created by Encode. The
+// literal data of the operations is decompressed.
+func DecodePatch(r io.Reader) (Patch, error) {
+	return DecodePatchWithDictionary(r, nil)
+}
+
+// DecodePatchWithDictionary read a patch that is created by Encode or EncodeWithDictionary.
+// The literal data of the operations is decompressed. The old data is needed for the data
+// that is compressed with a dictionary. If 'old' is nil and the patch contains such data,
+// an error is returned.
+func DecodePatchWithDictionary(r io.Reader, old io.ReaderAt) (Patch, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(patchMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || (string(magic) != patchMagic && string(magic) != patchChecksumMagic) {
+		return Patch{}, errors.New("the data is not a patch")
+	}
+
+	var checksum []byte
+	if string(magic) == patchChecksumMagic {
+		var err error
+		if checksum, err = readBytes(br); err != nil {
+			return Patch{}, err
+		}
+	}
+
+	name, err := readBytes(br)
+	if err != nil {
+		return Patch{}, err
+	}
+	codec, err := GetCodec(string(name))
+	if err != nil {
+		return Patch{}, err
+	}
+
+	p := Patch{Codec: codec.Name(), Checksum: string(checksum)}
+	for {
+		t, err := br.ReadByte()
+		if err != nil {
+			return Patch{}, err
+		}
+
+		switch OpType(t) {
+		case 0:
+			return p, nil
+		case OpCopy:
+			var op = Op{Type: OpCopy}
+			if op.SourceOffset, err = binary.ReadUvarint(br); err != nil {
+				return Patch{}, err
+			}
+			if op.Length, err = binary.ReadUvarint(br); err != nil {
+				return Patch{}, err
+			}
+			p.Ops = append(p.Ops, op)
+		case OpZero:
+			var op = Op{Type: OpZero}
+			if op.Length, err = binary.ReadUvarint(br); err != nil {
+				return Patch{}, err
+			}
+			p.Ops = append(p.Ops, op)
+		case OpData:
+			data, err := readData(br, codec, old)
+			if err != nil {
+				return Patch{}, err
+			}
+			p.Ops = append(p.Ops, Op{Type: OpData, Length: uint64(len(data)), Data: data})
+		default:
+			return Patch{}, fmt.Errorf("unknown type of operation %d", t)
+		}
+	}
+}
+
+// readData read the literal data of OpData and decompress it if it is needed.
+func readData(br *bufio.Reader, codec Codec, old io.ReaderAt) ([]byte, error) {
+	encoding, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch encoding {
+	case dataRaw:
+		return readFull(br, length)
+	case dataCompressed:
+		compressed, err := readBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		if length > math.MaxInt {
+			return nil, fmt.Errorf("the length of the data %d is too big", length)
+		}
+		return codec.Decompress(compressed, int(length))
+	case dataDictionary:
+		if old == nil {
+			return nil, errors.New("the patch is compressed with the old data as a dictionary")
+		}
+		offset, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		dictLength, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := readBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		if offset > math.MaxInt64 || dictLength > math.MaxInt64 || length > math.MaxInt {
+			return nil, fmt.Errorf("the dictionary of %d bytes at offset %d is too big", dictLength, offset)
+		}
+		dict, err := readFull(io.NewSectionReader(old, int64(offset), int64(dictLength)), dictLength)
+		if err != nil {
+			return nil, fmt.Errorf("read dictionary from offset %d: %v", offset, err)
+		}
+		return readAll(flate.NewReaderDict(bytes.NewReader(compressed), dict), int(length))
+	default:
+		return nil, fmt.Errorf("unknown encoding of data %d", encoding)
+	}
+}
+
+// Apply create the new data by applying the operations of the patch to the old data. The
+// new data is written to 'w'. If the patch has a checksum and the checksum of the new data
+// is different, ErrChecksumMismatch is returned after all data is written.
+//
+// When 'w' is a file (see sparseWriter), the holes of OpZero are recreated by skipping bytes
+// instead of writing zeros, so the file must not contain data after its current offset.
+func (p Patch) Apply(old io.ReaderAt, w io.Writer) error {
+	h := sha1.New()
+	var checksum io.Writer
+	if p.Checksum != "" {
+		checksum = h
+	}
+	hw := newHoleWriter(w, checksum)
+	w = hw
+	for _, op := range p.Ops {
+		switch op.Type {
+		case OpCopy:
+			n, err := io.Copy(w, io.NewSectionReader(old, int64(op.SourceOffset), int64(op.Length)))
+			if err != nil {
+				return err
+			}
+			if uint64(n) != op.Length {
+				return fmt.Errorf("copy %d bytes from offset %d: the old data is too short", op.Length, op.SourceOffset)
+			}
+		case OpData:
+			if _, err := w.Write(op.Data); err != nil {
+				return err
+			}
+		case OpZero:
+			if err := hw.writeHole(op.Length); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown type of operation %d", op.Type)
+		}
+	}
+	if err := hw.close(); err != nil {
+		return err
+	}
+
+	if sum := fmt.Sprintf("%x", h.Sum(nil)); p.Checksum != "" && sum != p.Checksum {
+		return fmt.Errorf("%w: the checksum of the new data is %s, expected %s", ErrChecksumMismatch, sum, p.Checksum)
+	}
+	return nil
+}
+
+// writeUvarint write the value as uvarint.
+func writeUvarint(w *bufio.Writer, v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	_, _ = w.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+// readBytes read bytes that are prefixed with their uvarint length.
+func readBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	return readFull(br, n)
+}
+
+// readFull read exactly n bytes. The lengths in a patch are not trusted, so the buffer grows
+// while the bytes are read instead of allocating n bytes before reading them.
+func readFull(r io.Reader, n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("the length %d is too big", n)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,279 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatch(t *testing.T) {
+	// SetUp
+	oldData, newData := textData(3000, 1), textData(3000, 1)
+	newData = append(newData[:40000:40000], append([]byte(strings.Repeat("a new line of the log\n", 500)), newData[40000:]...)...)
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+
+	for _, codec := range []string{fdiff.CodecNone, fdiff.CodecDeflate, fdiff.CodecGzip} {
+		t.Run(codec, func(t *testing.T) {
+			// Action
+			var encoded bytes.Buffer
+			err := fdiff.NewPatch(d, codec).Encode(&encoded)
+			size := encoded.Len()
+			p, decodeErr := fdiff.DecodePatch(&encoded)
+			var actual bytes.Buffer
+			applyErr := p.Apply(bytes.NewReader(oldData), &actual)
+
+			// Assert
+			assert.Nil(t, err)
+			assert.Nil(t, decodeErr)
+			assert.Nil(t, applyErr)
+			assert.Equal(t, codec, p.Codec)
+			assert.Equal(t, newData, actual.Bytes())
+			if codec != fdiff.CodecNone {
+				assert.Less(t, size, literalSize(d)/4)
+			}
+		})
+	}
+}
+
+func TestPatch_WhenChecksumDoesNotMatch(t *testing.T) {
+	// SetUp
+	oldData, newData := textData(3000, 1), textData(3000, 1)
+	newData = append(newData[:40000:40000], append([]byte("a new line of the log\n"), newData[40000:]...)...)
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+	changedOldData := append([]byte{}, oldData...)
+	changedOldData[100] ^= 0xff
+
+	// Action
+	var encoded bytes.Buffer
+	err := fdiff.NewPatch(d, fdiff.CodecDeflate).Encode(&encoded)
+	p, decodeErr := fdiff.DecodePatch(&encoded)
+	applyErr := p.Apply(bytes.NewReader(oldData), io.Discard)
+	changedErr := p.Apply(bytes.NewReader(changedOldData), io.Discard)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, decodeErr)
+	assert.Nil(t, applyErr)
+	assert.Equal(t, fmt.Sprintf("%x", sha1.Sum(newData)), p.Checksum)
+	assert.ErrorIs(t, changedErr, fdiff.ErrChecksumMismatch)
+}
+
+func TestPatch_WithRegisteredCodec(t *testing.T) {
+	// SetUp
+	deflate, _ := fdiff.GetCodec(fdiff.CodecDeflate)
+	fdiff.RegisterCodec(renamedCodec{Codec: deflate, name: "custom"})
+	p := fdiff.Patch{
+		Codec: "custom",
+		Ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 4, Length: 3},
+			{Type: fdiff.OpData, Length: 500, Data: bytes.Repeat([]byte("hello"), 100)},
+		},
+	}
+
+	// Action
+	var encoded bytes.Buffer
+	err := p.Encode(&encoded)
+	decoded, decodeErr := fdiff.DecodePatch(bytes.NewReader(encoded.Bytes()))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, decodeErr)
+	assert.Equal(t, p, decoded)
+	assert.Less(t, encoded.Len(), 100)
+	assert.Contains(t, fdiff.CodecNames(), "custom")
+}
+
+func TestPatch_WhenCodecIsUnknown(t *testing.T) {
+	// Action
+	err := fdiff.Patch{Codec: "unknown"}.Encode(&bytes.Buffer{})
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func TestDecodePatch_WhenDataIsNotPatch(t *testing.T) {
+	// Action
+	_, err := fdiff.DecodePatch(strings.NewReader("0-30-9d23da68e8d2e7b42b1e021b1a4c2912a827f285\n"))
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func TestDecodePatch_WhenLengthsAreMalformed(t *testing.T) {
+	huge := string(binary.AppendUvarint(nil, 1<<62))
+	cases := map[string]string{
+		"checksum":          "fdiff-patch/2\n" + huge,
+		"codec":             "fdiff-patch/1\n" + huge,
+		"raw data":          "fdiff-patch/1\n\x04none\x02\x00" + huge + "abc",
+		"compressed data":   "fdiff-patch/1\n\x07deflate\x02\x01\x03" + huge + "abc",
+		"decompressed data": "fdiff-patch/1\n\x07deflate\x02\x01" + huge + "\x03abc",
+		"too big length":    "fdiff-patch/1\n\x07deflate\x02\x01" + string(binary.AppendUvarint(nil, 1<<63)) + "\x03abc",
+	}
+
+	for name, patch := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Action
+			_, err := fdiff.DecodePatch(strings.NewReader(patch))
+
+			// Assert
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestPatch_WithZeroOps(t *testing.T) {
+	// SetUp
+	oldData := textData(100, 1)
+	newData := append(append(append([]byte("new data"), make([]byte, 50000)...), oldData[:1000]...), make([]byte, 70000)...)
+	d := fdiff.Delta{
+		Ops: []fdiff.Op{
+			{Type: fdiff.OpData, Length: 8, Data: []byte("new data")},
+			{Type: fdiff.OpZero, Length: 50000},
+			{Type: fdiff.OpCopy, Length: 1000},
+			{Type: fdiff.OpZero, Length: 70000},
+		},
+		Checksum: fmt.Sprintf("%x", sha1.Sum(newData)),
+	}
+
+	// Action
+	var encoded bytes.Buffer
+	err := fdiff.NewPatch(d, fdiff.CodecNone).Encode(&encoded)
+	p, decodeErr := fdiff.DecodePatch(&encoded)
+	var actual bytes.Buffer
+	applyErr := p.Apply(bytes.NewReader(oldData), &actual)
+	out, createErr := os.Create(filepath.Join(t.TempDir(), "out"))
+	fileErr := p.Apply(bytes.NewReader(oldData), out)
+	out.Close()
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, decodeErr)
+	assert.Nil(t, applyErr)
+	assert.Nil(t, createErr)
+	assert.Nil(t, fileErr)
+	assert.Equal(t, d.Ops, p.Ops)
+	assert.Equal(t, newData, actual.Bytes())
+	assert.Equal(t, newData, readBytes(t, out.Name()))
+}
+
+// renamedCodec is a codec that is registered with another name.
+type renamedCodec struct {
+	fdiff.Codec
+	name string
+}
+
+func (c renamedCodec) Name() string { return c.name }
+
+// findDeltaWithChunker find the delta between the old and the new data with the real Chunker.
+func findDeltaWithChunker(t *testing.T, oldData, newData []byte, cfg fdiff.ChunkConfig) fdiff.Delta {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "old"), oldData)
+	writeFile(t, filepath.Join(dir, "new"), newData)
+	signWithChunker(t, filepath.Join(dir, "old"), filepath.Join(dir, "sign"), cfg)
+
+	d := make(chan byte, 100)
+	ch := make(chan fdiff.Chunk, 100)
+	newChunker(t, rollinghash.NewRabinFingerprint, cfg, d, ch).Start()
+	delta, err := fdiff.NewFileSignerDeltaWithConfig(d, ch, cfg).FindDelta(filepath.Join(dir, "sign"), filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delta
+}
+
+// textData create lines of text that look like a log.
+func textData(lines int, seed int64) []byte {
+	r := randomData(lines, seed)
+	var buf bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&buf, "2022-11-%02d INFO request %d served with status %d\n", i%30+1, i, 200+int(r[i]%5))
+	}
+	return buf.Bytes()
+}
+
+// literalSize return the number of literal bytes in the delta.
+func literalSize(d fdiff.Delta) int {
+	n := 0
+	for _, op := range d.Ops {
+		n += len(op.Data)
+	}
+	return n
+}
+
+func TestPatch_EncodeWithDictionary(t *testing.T) {
+	// SetUp
+	oldData := randomData(300000, 6)
+	newData := append([]byte{}, oldData...)
+	for i := 1000; i < len(newData); i += 7000 {
+		newData[i] ^= 0xff
+	}
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+
+	// Action
+	var withoutDictionary, withDictionary bytes.Buffer
+	err := fdiff.NewPatch(d, fdiff.CodecDeflate).Encode(&withoutDictionary)
+	dictErr := fdiff.NewPatch(d, fdiff.CodecDeflate).EncodeWithDictionary(&withDictionary, bytes.NewReader(oldData))
+	size := withDictionary.Len()
+	p, decodeErr := fdiff.DecodePatchWithDictionary(&withDictionary, bytes.NewReader(oldData))
+	var actual bytes.Buffer
+	applyErr := p.Apply(bytes.NewReader(oldData), &actual)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, dictErr)
+	assert.Nil(t, decodeErr)
+	assert.Nil(t, applyErr)
+	assert.Equal(t, newData, actual.Bytes())
+	assert.Greater(t, withoutDictionary.Len(), literalSize(d))
+	assert.Less(t, size, withoutDictionary.Len()/10)
+}
+
+func TestPatch_EncodeWithDictionary_WhenDataIsInserted(t *testing.T) {
+	// SetUp
+	oldData := randomData(100000, 7)
+	newData := append(append(append([]byte{}, oldData[:50000]...), randomData(20000, 8)...), oldData[50000:]...)
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+
+	// Action
+	var withoutDictionary, withDictionary bytes.Buffer
+	err := fdiff.NewPatch(d, fdiff.CodecDeflate).Encode(&withoutDictionary)
+	dictErr := fdiff.NewPatch(d, fdiff.CodecDeflate).EncodeWithDictionary(&withDictionary, bytes.NewReader(oldData))
+	size := withDictionary.Len()
+	p, decodeErr := fdiff.DecodePatchWithDictionary(&withDictionary, bytes.NewReader(oldData))
+	var actual bytes.Buffer
+	applyErr := p.Apply(bytes.NewReader(oldData), &actual)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, dictErr)
+	assert.Nil(t, decodeErr)
+	assert.Nil(t, applyErr)
+	assert.Equal(t, newData, actual.Bytes())
+	assert.LessOrEqual(t, size, withoutDictionary.Len())
+}
+
+func TestDecodePatch_WhenPatchIsCompressedWithDictionary(t *testing.T) {
+	// SetUp
+	oldData := randomData(100000, 9)
+	newData := append([]byte{}, oldData...)
+	newData[50000] ^= 0xff
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+	var encoded bytes.Buffer
+	_ = fdiff.NewPatch(d, fdiff.CodecDeflate).EncodeWithDictionary(&encoded, bytes.NewReader(oldData))
+
+	// Action
+	_, err := fdiff.DecodePatch(&encoded)
+
+	// Assert
+	assert.NotNil(t, err)
+}
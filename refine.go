@@ -0,0 +1,123 @@
+package fdiff
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// refineMinMatch is the minimal number of equal bytes that are copied from the
+	// old data by the refinement. Shorter matches are stored as data, because a copy
+	// operation is not smaller than them.
+	refineMinMatch = 16
+
+	// refineGramSize is the number of bytes by which the positions in the old data are indexed.
+	refineGramSize = 8
+
+	// maxRefineRegion is the maximum number of bytes of the old data that are compared
+	// with the new data of one operation.
+	maxRefineRegion = 1 << 20
+)
+
+// RefineDelta compare byte by byte the new data of the delta with the old data that
+// it replaces. FindDelta works with chunks, so when one byte is changed the whole chunk
+// is new. RefineDelta pairs every OpData with the region of the old data at the same
+// position (the removed old chunks between the previous and the next copied chunk) and
+// split it to operations that copy the equal bytes and write only the changed bytes.
+// NewChunks, OldChunks, Edits and Checksum of the delta are not changed.
+func RefineDelta(d Delta, old io.ReaderAt) (Delta, error) {
+	refined := Delta{NewChunks: d.NewChunks, OldChunks: d.OldChunks, Edits: d.Edits, Checksum: d.Checksum}
+	for i, op := range d.Ops {
+		if op.Type != OpData {
+			refined.addOp(op)
+			continue
+		}
+
+		offset, length := replacedRegion(d.Ops, i)
+		if length > maxRefineRegion {
+			length = maxRefineRegion
+		}
+		oldData := make([]byte, length)
+		n, err := old.ReadAt(oldData, int64(offset))
+		if err != nil && err != io.EOF {
+			return Delta{}, err
+		}
+
+		for _, o := range diffBytes(oldData[:n], op.Data, offset) {
+			refined.addOp(o)
+		}
+	}
+	return refined, nil
+}
+
+// diffBytes return the operations that create 'newData' from 'oldData'. The parameter
+// 'base' is the offset of 'oldData' in the whole old data. First the common prefix and
+// suffix are found. The bytes between them are matched greedily: the positions of all
+// grams of 'oldData' are indexed and at every position of 'newData' the match starting
+// from the position of its gram is extended as much as possible.
+func diffBytes(oldData, newData []byte, base uint64) []Op {
+	var ops []Op
+	prefix := commonPrefix(oldData, newData)
+	suffix := commonSuffix(oldData[prefix:], newData[prefix:])
+	if prefix > 0 {
+		ops = append(ops, Op{Type: OpCopy, SourceOffset: base, Length: uint64(prefix)})
+	}
+
+	index := map[uint64]int{}
+	for i := 0; i+refineGramSize <= len(oldData); i++ {
+		g := binary.LittleEndian.Uint64(oldData[i:])
+		if _, ok := index[g]; !ok {
+			index[g] = i
+		}
+	}
+
+	middle := newData[prefix : len(newData)-suffix]
+	literal := 0
+	for j := 0; j < len(middle); {
+		if j+refineGramSize <= len(middle) {
+			if i, ok := index[binary.LittleEndian.Uint64(middle[j:])]; ok {
+				if n := commonPrefix(oldData[i:], middle[j:]); n >= refineMinMatch {
+					if literal < j {
+						ops = append(ops, dataOp(middle[literal:j]))
+					}
+					ops = append(ops, Op{Type: OpCopy, SourceOffset: base + uint64(i), Length: uint64(n)})
+					j += n
+					literal = j
+					continue
+				}
+			}
+		}
+		j++
+	}
+	if literal < len(middle) {
+		ops = append(ops, dataOp(middle[literal:]))
+	}
+
+	if suffix > 0 {
+		ops = append(ops, Op{Type: OpCopy, SourceOffset: base + uint64(len(oldData)-suffix), Length: uint64(suffix)})
+	}
+	return ops
+}
+
+// dataOp return OpData that writes a copy of the data.
+func dataOp(data []byte) Op {
+	return Op{Type: OpData, Length: uint64(len(data)), Data: append([]byte{}, data...)}
+}
+
+// commonPrefix return the number of equal bytes in the beginning of 'a' and 'b'.
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// commonSuffix return the number of equal bytes in the end of 'a' and 'b'.
+func commonSuffix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefineDelta(t *testing.T) {
+	// SetUp
+	oldData := randomData(300000, 10)
+	newData := append([]byte{}, oldData...)
+	changed := 0
+	for i := 1000; i < len(newData); i += 9000 {
+		newData[i] ^= 0xff
+		changed++
+	}
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+
+	// Action
+	refined, err := fdiff.RefineDelta(d, bytes.NewReader(oldData))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, d.NewChunks, refined.NewChunks)
+	assert.Equal(t, d.OldChunks, refined.OldChunks)
+	assert.Equal(t, changed, literalSize(refined))
+	assert.Equal(t, newData, applyDelta(t, refined, oldData))
+}
+
+func TestRefineDelta_WhenDataIsInsertedAndRemoved(t *testing.T) {
+	// SetUp
+	oldData := textData(5000, 11)
+	var newData []byte
+	newData = append(newData, oldData[:20000]...)
+	newData = append(newData, []byte("THIS IS A NEW DATA")...)
+	newData = append(newData, oldData[20000:100000]...)
+	newData = append(newData, oldData[100100:]...)
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+
+	// Action
+	refined, err := fdiff.RefineDelta(d, bytes.NewReader(oldData))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, literalSize(refined), len("THIS IS A NEW DATA")+32)
+	assert.Equal(t, newData, applyDelta(t, refined, oldData))
+}
+
+func TestRefineDelta_WhenDataIsNew(t *testing.T) {
+	// SetUp
+	oldData := randomData(100000, 12)
+	newData := append(append(append([]byte{}, oldData[:50000]...), randomData(30000, 13)...), oldData[50000:]...)
+	d := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+
+	// Action
+	refined, err := fdiff.RefineDelta(d, bytes.NewReader(oldData))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, literalSize(refined), literalSize(d))
+	assert.GreaterOrEqual(t, literalSize(refined), 30000)
+	assert.Equal(t, newData, applyDelta(t, refined, oldData))
+}
+
+// applyDelta apply the operations of the delta to the old data.
+func applyDelta(t *testing.T, d fdiff.Delta, oldData []byte) []byte {
+	var buf bytes.Buffer
+	if err := fdiff.NewPatch(d, fdiff.CodecNone).Apply(bytes.NewReader(oldData), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,61 @@
+// Package restic creates chunks with the same boundaries as the chunker of restic.
+//
+// restic finds the boundaries with a Rabin fingerprint of a window with 64 bytes. The
+// polynomial of the fingerprint is random and irreducible and it is stored in the config
+// of every repository (the field "chunker_polynomial"). A chunk ends when the lowest 20
+// bits of the fingerprint are zero, so the chunks are 1 MiB on average. Their size is
+// between 512 KiB and 8 MiB.
+package restic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+)
+
+// parameters of the chunker of restic.
+const (
+	// WindowSize is the size of the window of the fingerprint.
+	WindowSize = 64
+
+	// MinSize is the minimum size of the chunks.
+	MinSize = 512 * 1024
+
+	// MaxSize is the maximum size of the chunks.
+	MaxSize = 8 * 1024 * 1024
+
+	// AverageBits is the number of the lowest bits of the fingerprint that must be zero at the end of a chunk.
+	AverageBits = 20
+)
+
+// ChunkConfig return the configuration of the Chunker, with which it creates the same chunks
+// as restic with the polynomial. The Chunker must use rollinghash.NewRabin64 with the polynomial.
+func ChunkConfig(pol rollinghash.Pol) fdiff.ChunkConfig {
+	return fdiff.ChunkConfig{
+		WindowSize:            WindowSize,
+		MinSizeChunk:          MinSize,
+		MaxSizeChunk:          MaxSize,
+		FingerprintBreakPoint: 0,
+		Discriminator:         1 << AverageBits,
+		RollingHash:           rollinghash.Rabin64,
+		Polynomial:            pol.String(),
+	}
+}
+
+// Chunk split the data in chunks with the chunker of restic and the polynomial of a repository.
+func Chunk(r io.Reader, pol rollinghash.Pol) ([]fdiff.Chunk, error) {
+	newHash, err := rollinghash.NewRabin64(pol)
+	if err != nil {
+		return nil, err
+	}
+	return fdiff.ChunkReader(newHash, ChunkConfig(pol), r)
+}
+
+// ID return the ID of the blob of the chunk in a restic repository, which is the SHA-256 of its data.
+func ID(c fdiff.Chunk) string {
+	sum := sha256.Sum256(c.Data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,107 @@
+package restic_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/restic"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPol is the polynomial of the tests of restic.
+const testPol = rollinghash.Pol(0x3DA3358B4DC173)
+
+// expectedChunk is a chunk that restic creates.
+type expectedChunk struct {
+	length uint64
+	id     string
+}
+
+func TestChunk(t *testing.T) {
+	// SetUp
+	// the first chunks of 32 MiB random data (math/rand with seed 23) from the tests of restic
+	expected := []expectedChunk{
+		{length: 2163460, id: "4b94cb2cf293855ea43bf766731c74969b91aa6bf3c078719aabdd19860d590d"},
+		{length: 643703, id: "5727a63c0964f365ab8ed2ccf604912f2ea7be29759a2b53ede4d6841e397407"},
+		{length: 1528956, id: "a73759636a1e7a2758767791c69e81b69fb49236c6929e5d1b654e06e37674ba"},
+		{length: 1955808, id: "c955fb059409b25f07e5ae09defbbc2aadf117c97a3724e06ad4abd2787e6824"},
+	}
+
+	// Action
+	chunks, err := restic.Chunk(bytes.NewReader(randomData(8*1024*1024, 23)), testPol)
+
+	// Assert
+	assert.NoError(t, err)
+	assertChunks(t, expected, chunks)
+}
+
+func TestChunk_WithAverageBits(t *testing.T) {
+	// SetUp
+	// the first chunks of the same data with 19 average bits from the tests of restic
+	expected := []expectedChunk{
+		{length: 1491586, id: "4c008237df602048039287427171cef568a6cb965d1b5ca28dc80504a24bb061"},
+		{length: 671874, id: "fa8a42321b90c3d4ce9dd850562b2fd0c0fe4bdd26cf01a24f22046a224225d3"},
+		{length: 643703, id: "5727a63c0964f365ab8ed2ccf604912f2ea7be29759a2b53ede4d6841e397407"},
+	}
+	cfg := restic.ChunkConfig(testPol)
+	cfg.Discriminator = 1 << 19
+	newHash, err := fdiff.RollingHashOf(cfg)
+	assert.NoError(t, err)
+
+	// Action
+	chunks, err := fdiff.ChunkReader(newHash, cfg, bytes.NewReader(randomData(3*1024*1024, 23)))
+
+	// Assert
+	assert.NoError(t, err)
+	assertChunks(t, expected, chunks)
+}
+
+func TestChunk_WhenDataIsZeros(t *testing.T) {
+	// Action
+	chunks, err := restic.Chunk(bytes.NewReader(make([]byte, 4*restic.MinSize)), testPol)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 4)
+	for _, c := range chunks {
+		assert.Equal(t, uint64(restic.MinSize), c.Length)
+		assert.Equal(t, "07854d2fef297a06ba81685e660c332de36d5d18d546927d30daad6d7fda1541", restic.ID(c))
+	}
+}
+
+func TestChunk_WhenPolynomialIsReducible(t *testing.T) {
+	// Action
+	_, err := restic.Chunk(bytes.NewReader(nil), testPol*2)
+
+	// Assert
+	assert.EqualError(t, err, "the polynomial 0x7b466b169b82e6 is not irreducible")
+}
+
+// assertChunks check that the first chunks have the expected lengths and IDs.
+func assertChunks(t *testing.T, expected []expectedChunk, chunks []fdiff.Chunk) {
+	assert.Greater(t, len(chunks), len(expected))
+	var offset uint64
+	for i, e := range expected {
+		assert.Equal(t, offset, chunks[i].Offset)
+		assert.Equal(t, e.length, chunks[i].Length)
+		assert.Equal(t, e.id, restic.ID(chunks[i]))
+		offset += chunks[i].Length
+	}
+}
+
+// randomData return the same random data as the tests of restic.
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 0; i < size; i += 4 {
+		r := rnd.Uint32()
+		data[i] = byte(r)
+		data[i+1] = byte(r >> 8)
+		data[i+2] = byte(r >> 16)
+		data[i+3] = byte(r >> 24)
+	}
+	return data
+}
@@ -0,0 +1,56 @@
+package rollinghash_test
+
+import (
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBuzhash(t *testing.T) {
+	// SetUp
+	a := rollinghash.NewBuzhash([]byte("a")).Value()
+	b := rollinghash.NewBuzhash([]byte("b")).Value()
+
+	// Action
+	actual := rollinghash.NewBuzhash([]byte("ab")).Value()
+
+	// Assert
+	assert.Equal(t, uint64(bits.RotateLeft32(uint32(a), 1)^uint32(b)), actual)
+}
+
+func TestBuzhash_Next(t *testing.T) {
+	// SetUp
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+	h := rollinghash.NewBuzhash(data[:48])
+
+	for i := 48; i < len(data); i++ {
+		// Action
+		actual := h.Next(data[i])
+
+		// Assert
+		expected := rollinghash.NewBuzhash(data[i-47 : i+1]).Value()
+		assert.Equal(t, expected, actual)
+		assert.Equal(t, expected, h.Value())
+	}
+}
+
+func TestByName(t *testing.T) {
+	for _, name := range []string{"", rollinghash.Rabin, rollinghash.Buzhash} {
+		// Action
+		newHash, err := rollinghash.ByName(name)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.NotNil(t, newHash([]byte("abc")))
+	}
+
+	// Action
+	_, err := rollinghash.ByName("unknown")
+
+	// Assert
+	assert.EqualError(t, err, `unknown rolling hash "unknown"`)
+}
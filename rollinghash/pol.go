@@ -0,0 +1,78 @@
+package rollinghash
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Pol is a polynomial over GF(2). The bit i is the coefficient of x^i.
+type Pol uint64
+
+// ParsePol parse a polynomial in hex, with or without the prefix 0x. This is the
+// format in which restic stores the polynomial in the config of a repository.
+func ParsePol(s string) (Pol, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid polynomial %q: %w", s, err)
+	}
+	return Pol(v), nil
+}
+
+// String return the polynomial in hex.
+func (p Pol) String() string {
+	return fmt.Sprintf("0x%x", uint64(p))
+}
+
+// Deg return the degree of the polynomial. The degree of 0 is -1.
+func (p Pol) Deg() int {
+	return bits.Len64(uint64(p)) - 1
+}
+
+// Mod return the remainder of the division of 'p' by 'd'.
+func (p Pol) Mod(d Pol) Pol {
+	dd := d.Deg()
+	for diff := p.Deg() - dd; diff >= 0; diff = p.Deg() - dd {
+		p ^= d << uint(diff)
+	}
+	return p
+}
+
+// mulMod return p * q mod m. The degree of 'm' must be less than 64.
+func (p Pol) mulMod(q, m Pol) Pol {
+	p = p.Mod(m)
+	var result Pol
+	for ; q != 0; q >>= 1 {
+		if q&1 == 1 {
+			result ^= p
+		}
+		p = (p << 1).Mod(m)
+	}
+	return result
+}
+
+// gcd return the greatest common divisor of the polynomials.
+func (p Pol) gcd(q Pol) Pol {
+	for q != 0 {
+		p, q = q, p.Mod(q)
+	}
+	return p
+}
+
+// Irreducible show whether the polynomial can't be factored. It is the test of Ben-Or:
+// 'p' with degree n is irreducible if gcd(p, x^(2^i) - x) = 1 for every i <= n/2.
+func (p Pol) Irreducible() bool {
+	if p.Deg() < 1 {
+		return false
+	}
+	const x = Pol(2)
+	q := x
+	for i := 1; i <= p.Deg()/2; i++ {
+		q = q.mulMod(q, p)
+		if p.gcd(q^x) != 1 {
+			return false
+		}
+	}
+	return true
+}
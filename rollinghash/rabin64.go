@@ -0,0 +1,108 @@
+package rollinghash
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// minRabin64Degree is the minimum degree of the polynomial of the Rabin64. The top 8 bits
+	// of the value are used to find the reduction in the table of the modulus.
+	minRabin64Degree = 8
+
+	// maxRabin64Degree is the maximum degree of the polynomial of the Rabin64. The value is
+	// shifted with 8 bits, so it must fit in 64 bits.
+	maxRabin64Degree = 56
+)
+
+// rabin64Tables contains the precomputed values of a polynomial.
+type rabin64Tables struct {
+	shift uint
+
+	// mod[b] = (b * x^deg) mod pol | b * x^deg. The top 8 bits of the value are used as an
+	// index in it and one XOR removes them and adds the remainder of their division.
+	mod [256]uint64
+
+	// out contains the tables for the window sizes. out[w][b] is the fingerprint of
+	// b followed by w-1 zero bytes. XOR with it removes b from the beginning of the window.
+	out map[int]*[256]uint64
+	mu  sync.Mutex
+}
+
+// outTable return the table that removes a byte from the beginning of a window with 'size' bytes.
+func (t *rabin64Tables) outTable(size int) *[256]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if table, ok := t.out[size]; ok {
+		return table
+	}
+
+	table := &[256]uint64{}
+	for b := range table {
+		v := t.appendByte(0, byte(b))
+		for i := 0; i < size-1; i++ {
+			v = t.appendByte(v, 0)
+		}
+		table[b] = v
+	}
+	t.out[size] = table
+	return table
+}
+
+// appendByte add the byte to the end of the fingerprint.
+func (t *rabin64Tables) appendByte(v uint64, b byte) uint64 {
+	index := v >> t.shift
+	return (v<<8 | uint64(b)) ^ t.mod[index]
+}
+
+// rabin64 is the Rabin fingerprint of the window with an irreducible polynomial over GF(2),
+// which is used by restic and LBFS. The fingerprint is the remainder of the division of the
+// window, interpreted as a polynomial, by the polynomial.
+type rabin64 struct {
+	tables *rabin64Tables
+	out    *[256]uint64
+	value  uint64
+	window []byte
+
+	// idx is the position of the oldest byte in the window.
+	idx int
+}
+
+// NewRabin64 return a function that creates a Rabin fingerprint with the polynomial. The
+// polynomial must be irreducible and its degree must be between 8 and 56.
+func NewRabin64(pol Pol) (func([]byte) Hash, error) {
+	if d := pol.Deg(); d < minRabin64Degree || d > maxRabin64Degree {
+		return nil, fmt.Errorf("the degree of the polynomial %s must be between %d and %d", pol, minRabin64Degree, maxRabin64Degree)
+	}
+	if !pol.Irreducible() {
+		return nil, fmt.Errorf("the polynomial %s is not irreducible", pol)
+	}
+
+	t := &rabin64Tables{shift: uint(pol.Deg() - 8), out: map[int]*[256]uint64{}}
+	for b := range t.mod {
+		bk := Pol(b) << uint(pol.Deg())
+		t.mod[b] = uint64(bk.Mod(pol) | bk)
+	}
+
+	return func(bytes []byte) Hash {
+		h := &rabin64{tables: t, out: t.outTable(len(bytes)), window: append([]byte{}, bytes...)}
+		for _, b := range bytes {
+			h.value = t.appendByte(h.value, b)
+		}
+		return h
+	}, nil
+}
+
+// Value return the value of the hash.
+func (h *rabin64) Value() uint64 {
+	return h.value
+}
+
+// Next calculate the hash of the next rolling window. The window is shifted with one byte.
+func (h *rabin64) Next(b byte) uint64 {
+	h.value ^= h.out[h.window[h.idx]]
+	h.window[h.idx] = b
+	h.idx = (h.idx + 1) % len(h.window)
+	h.value = h.tables.appendByte(h.value, b)
+	return h.value
+}
@@ -0,0 +1,85 @@
+package rollinghash_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPol_Irreducible(t *testing.T) {
+	cases := []struct {
+		name     string
+		pol      rollinghash.Pol
+		expected bool
+	}{
+		{name: "x^2 + x + 1", pol: 0x7, expected: true},
+		{name: "x^2 + 1", pol: 0x5, expected: false},
+		{name: "x^4 + x + 1", pol: 0x13, expected: true},
+		{name: "x^4 + x^2 + 1", pol: 0x15, expected: false},
+		{name: "polynomial of restic", pol: 0x3DA3358B4DC173, expected: true},
+		{name: "polynomial of restic multiplied by x", pol: 0x3DA3358B4DC173 << 1, expected: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Action
+			actual := c.pol.Irreducible()
+
+			// Assert
+			assert.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestPol_Mod(t *testing.T) {
+	// SetUp
+	// x^3 mod (x^2 + x + 1) = 1, so (x^4 + x^3 + x) mod (x^2 + x + 1) = x + 1 + x = 1
+	pol := rollinghash.Pol(0x1a)
+
+	// Action
+	actual := pol.Mod(0x7)
+
+	// Assert
+	assert.Equal(t, rollinghash.Pol(0x1), actual)
+}
+
+func TestParsePol(t *testing.T) {
+	for _, s := range []string{"3da3358b4dc173", "0x3DA3358B4DC173"} {
+		// Action
+		pol, err := rollinghash.ParsePol(s)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Equal(t, rollinghash.Pol(0x3DA3358B4DC173), pol)
+		assert.Equal(t, 53, pol.Deg())
+	}
+}
+
+func TestRabin64_Next(t *testing.T) {
+	// SetUp
+	newHash, err := rollinghash.NewRabin64(0x3DA3358B4DC173)
+	assert.NoError(t, err)
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+	h := newHash(data[:64])
+
+	for i := 64; i < len(data); i++ {
+		// Action
+		actual := h.Next(data[i])
+
+		// Assert
+		expected := newHash(data[i-63 : i+1]).Value()
+		assert.Equal(t, expected, actual)
+		assert.Less(t, actual, uint64(1)<<53)
+	}
+}
+
+func TestNewRabin64_WhenDegreeIsTooBig(t *testing.T) {
+	// Action
+	_, err := rollinghash.NewRabin64(1<<60 | 1)
+
+	// Assert
+	assert.EqualError(t, err, "the degree of the polynomial 0x1000000000000001 must be between 8 and 56")
+}
@@ -1,5 +1,7 @@
 package rollinghash
 
+import "fmt"
+
 // Hash is the common interface implemented by all rolling hash functions.
 type Hash interface {
 	// Value return the value of the hash/sign
@@ -8,3 +10,28 @@ type Hash interface {
 	// Next calculate the hash of the next rolling window. The window is shifted with one byte
 	Next(byte) uint64
 }
+
+// names of the rolling hashes.
+const (
+	// Rabin is the name of the Rabin fingerprint. It is the default rolling hash.
+	Rabin = "rabin"
+
+	// Buzhash is the name of the buzhash, which is used by casync.
+	Buzhash = "buzhash"
+
+	// Rabin64 is the name of the Rabin fingerprint with a polynomial of degree up to 56,
+	// which is used by restic. It needs a polynomial, so it is created with NewRabin64.
+	Rabin64 = "rabin64"
+)
+
+// ByName return the function that creates the rolling hash with the name. The empty name is Rabin.
+func ByName(name string) (func([]byte) Hash, error) {
+	switch name {
+	case "", Rabin:
+		return NewRabinFingerprint, nil
+	case Buzhash:
+		return NewBuzhash, nil
+	default:
+		return nil, fmt.Errorf("unknown rolling hash %q", name)
+	}
+}
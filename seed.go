@@ -0,0 +1,119 @@
+package fdiff
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+)
+
+// chunkLocation point where the bytes of a chunk can be found in a local file.
+type chunkLocation struct {
+	file   string
+	offset uint64
+	length uint64
+}
+
+// seedIndex contains the locations of the chunks of the seed files by their signatures.
+// Seed files are local files from which the chunks of another file can be reused. The
+// data of the chunks is not stored in the index, it is read from the files when needed.
+type seedIndex struct {
+	locations map[Signature]chunkLocation
+
+	// digestLength is the number of bytes to which the digests of the signatures
+	// are truncated in the index. It is 0 when they are not truncated.
+	digestLength int
+
+	// files contains the opened seed files by their names.
+	files map[string]*os.File
+}
+
+// newSeedIndex split every seed to chunks and build an index of their locations.
+// A seed can be a file or a directory. All regular files in the directory and its
+// subdirectories are used. Seeds that do not exist are skipped. When a chunk exists
+// in several seeds, the first found location is used. The digests of the signatures
+// are truncated to 'digestLength' bytes, so they can be found by truncated signatures.
+func newSeedIndex(new func([]byte) rollinghash.Hash, cfg ChunkConfig, seeds []string, digestLength int) (*seedIndex, error) {
+	idx := &seedIndex{
+		locations:    map[Signature]chunkLocation{},
+		digestLength: digestLength,
+		files:        map[string]*os.File{},
+	}
+
+	for _, seed := range seeds {
+		err := filepath.WalkDir(seed, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			return idx.add(new, cfg, path)
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// add split the file to chunks and add their locations to the index.
+func (idx *seedIndex) add(new func([]byte) rollinghash.Hash, cfg ChunkConfig, file string) error {
+	chunks, mapped, err := chunkFile(new, cfg, file)
+	if err != nil {
+		return err
+	}
+	defer mapped.Close()
+
+	for _, ch := range chunks {
+		if ch.Signature.IsHole() {
+			// the holes of the remote file are not copied from the seeds
+			continue
+		}
+		signature := ch.Signature.Truncate(idx.digestLength)
+		if _, ok := idx.locations[signature]; ok {
+			continue
+		}
+		idx.locations[signature] = chunkLocation{file: file, offset: ch.Offset, length: ch.Length}
+	}
+	return nil
+}
+
+// find return the location of the chunk with the signature and the length.
+func (idx *seedIndex) find(signature Signature, length uint64) (chunkLocation, bool) {
+	loc, ok := idx.locations[signature]
+	if !ok || loc.length != length {
+		return chunkLocation{}, false
+	}
+	return loc, true
+}
+
+// copyTo read the bytes from the location and write them to 'w'.
+func (idx *seedIndex) copyTo(w io.Writer, loc chunkLocation) error {
+	f, ok := idx.files[loc.file]
+	if !ok {
+		var err error
+		if f, err = os.Open(loc.file); err != nil {
+			return err
+		}
+		idx.files[loc.file] = f
+	}
+
+	_, err := io.Copy(w, io.NewSectionReader(f, int64(loc.offset), int64(loc.length)))
+	return err
+}
+
+// Close closes all opened seed files.
+func (idx *seedIndex) Close() error {
+	var err error
+	for name, f := range idx.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(idx.files, name)
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+package fdiff
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// MaxSignatureSize is the maximum number of bytes of the digest of a signature.
+const MaxSignatureSize = 32
+
+const (
+	// tagHole is the tag of the signatures of the holes of sparse files. It can't be
+	// the tag of a strong hash.
+	tagHole = 0xff
+
+	// holeSignatureText is the signature of a hole in the signature files.
+	holeSignatureText = "hole"
+)
+
+// Signature is the signature of a chunk: the digest of the data of the chunk and the tag of
+// the strong hash that created it. It is a value with a fixed size without pointers, so it
+// can be compared with == and used as a key of a map without allocations. It doesn't save
+// memory in maps: the fixed size fits the longest digest, so an entry of a map with SHA-1
+// signatures needs more memory than with hex strings (see BenchmarkSignatureMap); only the
+// allocations drop. The zero Signature is not a signature of any data.
+type Signature struct {
+	tag  byte
+	size byte
+	sum  [MaxSignatureSize]byte
+}
+
+// NewSignature return the signature with the digest created by the strong hash with the name.
+func NewSignature(strongHash string, digest []byte) (Signature, error) {
+	tag, size, err := strongHashTag(strongHash)
+	if err != nil {
+		return Signature{}, err
+	}
+	if len(digest) != size {
+		return Signature{}, fmt.Errorf("invalid digest of %s: %d bytes, expected %d", strongHashName(strongHash), len(digest), size)
+	}
+	return newSignature(tag, digest), nil
+}
+
+// newSignature return the signature with the tag and the digest. The digest must not be longer than MaxSignatureSize.
+func newSignature(tag byte, digest []byte) Signature {
+	s := Signature{tag: tag, size: byte(len(digest))}
+	copy(s.sum[:], digest)
+	return s
+}
+
+// holeSignature return the signature of a hole of a sparse file with the length. The data of the
+// hole is not hashed, so its digest is the length and the holes with equal lengths have equal signatures.
+func holeSignature(length uint64) Signature {
+	s := Signature{tag: tagHole, size: 8}
+	binary.BigEndian.PutUint64(s.sum[:], length)
+	return s
+}
+
+// IsHole return true if the signature is the signature of a hole of a sparse file. The holes are
+// zeros that are not stored on the disk, so they are neither read nor hashed.
+func (s Signature) IsHole() bool {
+	return s.tag == tagHole
+}
+
+// ParseSignature parse the digest in hex that is created by the strong hash with the name.
+func ParseSignature(strongHash, s string) (Signature, error) {
+	digest, err := hex.DecodeString(s)
+	if err != nil {
+		return Signature{}, fmt.Errorf("invalid signature %q: %w", s, err)
+	}
+	return NewSignature(strongHash, digest)
+}
+
+// parseSignature parse the digest in hex that is created by the strong hash with the name and is
+// truncated to 'digestLength' bytes. If 'digestLength' is 0 the digest must not be truncated.
+func parseSignature(strongHash, s string, digestLength int) (Signature, error) {
+	if digestLength == 0 {
+		return ParseSignature(strongHash, s)
+	}
+	digest, err := hex.DecodeString(s)
+	if err != nil {
+		return Signature{}, fmt.Errorf("invalid signature %q: %w", s, err)
+	}
+	tag, size, err := strongHashTag(strongHash)
+	if err != nil {
+		return Signature{}, err
+	}
+	if digestLength > size || len(digest) != digestLength {
+		return Signature{}, fmt.Errorf("invalid digest of %s: %d bytes, expected %d", strongHashName(strongHash), len(digest), digestLength)
+	}
+	return newSignature(tag, digest), nil
+}
+
+// Truncate return the signature with only the first n bytes of the digest. If n is 0 or
+// it is not less than the length of the digest, the signature is returned as it is. The
+// signatures of the holes are never truncated.
+func (s Signature) Truncate(n int) Signature {
+	if n <= 0 || n >= int(s.size) || s.IsHole() {
+		return s
+	}
+	return newSignature(s.tag, s.sum[:n])
+}
+
+// StrongHash return the name of the strong hash that created the signature.
+func (s Signature) StrongHash() string {
+	return strongHashByTag(s.tag)
+}
+
+// Digest return the digest of the signature.
+func (s Signature) Digest() []byte {
+	return append([]byte{}, s.sum[:s.size]...)
+}
+
+// IsZero return true if the signature is the zero Signature.
+func (s Signature) IsZero() bool {
+	return s == Signature{}
+}
+
+// String return the digest of the signature in hex. It is the format of the signatures in the
+// signature files, where the name of the strong hash is stored once in the header. The signature
+// of a hole is "hole", because the length of the hole is stored with it.
+func (s Signature) String() string {
+	if s.IsHole() {
+		return holeSignatureText
+	}
+	return hex.EncodeToString(s.sum[:s.size])
+}
+
+// MarshalText return the signature in the format <strong hash>:<digest in hex>. The signature
+// of a hole is in the format hole:<length in hex>.
+func (s Signature) MarshalText() ([]byte, error) {
+	if s.IsZero() {
+		return nil, errors.New("marshal the zero signature")
+	}
+	if s.IsHole() {
+		return []byte(holeSignatureText + ":" + hex.EncodeToString(s.sum[:s.size])), nil
+	}
+	return []byte(s.StrongHash() + ":" + s.String()), nil
+}
+
+// UnmarshalText parse a signature in the format <strong hash>:<digest in hex>.
+func (s *Signature) UnmarshalText(text []byte) error {
+	name, digest, ok := strings.Cut(string(text), ":")
+	if !ok {
+		return fmt.Errorf("invalid signature %q: missing strong hash", text)
+	}
+	if name == holeSignatureText {
+		length, err := hex.DecodeString(digest)
+		if err != nil || len(length) != 8 {
+			return fmt.Errorf("invalid signature of hole %q", text)
+		}
+		*s = holeSignature(binary.BigEndian.Uint64(length))
+		return nil
+	}
+	parsed, err := ParseSignature(name, digest)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// MarshalBinary return the tag of the strong hash followed by the digest.
+func (s Signature) MarshalBinary() ([]byte, error) {
+	if s.IsZero() {
+		return nil, errors.New("marshal the zero signature")
+	}
+	return append([]byte{s.tag}, s.sum[:s.size]...), nil
+}
+
+// UnmarshalBinary parse a signature created by MarshalBinary.
+func (s *Signature) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("invalid signature: no data")
+	}
+	if data[0] == tagHole {
+		if len(data) != 9 {
+			return errors.New("invalid signature of hole")
+		}
+		*s = holeSignature(binary.BigEndian.Uint64(data[1:]))
+		return nil
+	}
+	name := strongHashByTag(data[0])
+	if name == "" {
+		return fmt.Errorf("invalid signature: unknown strong hash with tag %d", data[0])
+	}
+	parsed, err := NewSignature(name, data[1:])
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// minDigestLength is the minimum number of bytes of a truncated digest.
+const minDigestLength = 2
+
+// DigestLengthOf return the number of bytes of the digests of the signature of a file with the
+// size 'fileSize' that is created with the configuration, or 0 if the digests are not truncated.
+//
+// When DigestLength of the configuration is set, it is used. Otherwise when CollisionBits is set,
+// the length is calculated from the size of the file: the file has at most n = fileSize / MinSizeChunk
+// chunks (or fileSize / BlockSize blocks) and a delta with a file of similar size compares at most
+// n^2 pairs of chunks, so the probability that two different chunks have equal digests is at most
+// n^2 / 2^(8*length). The length is the smallest one with which it is not more than 2^-CollisionBits.
+func DigestLengthOf(cfg ChunkConfig, fileSize uint64) (int, error) {
+	strongHash, err := getStrongHash(cfg.StrongHash)
+	if err != nil {
+		return 0, err
+	}
+
+	length := cfg.DigestLength
+	if length == 0 && cfg.CollisionBits > 0 {
+		chunks := fileSize
+		if cfg.BlockSize > 1 {
+			chunks /= cfg.BlockSize
+		} else if cfg.MinSizeChunk > 1 {
+			chunks /= uint64(cfg.MinSizeChunk)
+		}
+		length = (2*bits.Len64(chunks) + cfg.CollisionBits + 7) / 8
+		if length < minDigestLength {
+			length = minDigestLength
+		}
+	}
+	if length <= 0 || length >= strongHash.size {
+		return 0, nil
+	}
+	return length, nil
+}
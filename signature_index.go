@@ -0,0 +1,489 @@
+package fdiff
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	// indexRecordMemory is the approximate number of bytes of memory that one indexRecord needs.
+	indexRecordMemory = 64
+
+	// minRunRecords is the minimum number of records that are sorted in memory at once.
+	minRunRecords = 64
+
+	// fenceInterval is the number of records in a block of the index. The first digest
+	// of every block is kept in memory, so a digest is found by reading one or two blocks.
+	fenceInterval = 128
+
+	// bloomBitsPerKey is the number of bits of the Bloom filter per chunk. With 7 hashes
+	// about 1% of the digests that are not in the index pass the filter.
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+// indexRecord is one chunk of the signature in the index. The ordinal is the position of the chunk in the signature.
+type indexRecord struct {
+	signature Signature
+	offset    uint64
+	length    uint64
+	ordinal   uint64
+}
+
+// less order the records by their digests and then by their positions in the signature.
+func (r indexRecord) less(o indexRecord) bool {
+	if c := bytes.Compare(r.signature.sum[:r.signature.size], o.signature.sum[:o.signature.size]); c != 0 {
+		return c < 0
+	}
+	return r.ordinal < o.ordinal
+}
+
+// diskSignatureIndex is a multiset of the chunks of a signature that is stored on the disk. It is
+// used instead of chunkOccurrences when the chunks of the signature don't fit in the memory budget.
+//
+// The chunks are sorted by their digests with an external merge sort: runs that fit in the budget
+// are sorted in memory and written to a temporary file, then the runs are merged in the index file.
+// The records of the index have a fixed size, so the index is split in blocks of fenceInterval records
+// and only the first digest of every block (the fence) is kept in memory. A Bloom filter is checked
+// before the index is read, so most of the new chunks that are not in the signature don't need a read
+// from the disk. The used chunks are marked in a bitset. Together the fences, the Bloom filter and the
+// bitset need about 2 bytes of memory per chunk, besides the budget.
+//
+// The records with the same digest are found by binary searches, and like in chunkOccurrences the
+// first record of a digest that may not be used is kept for the digests with several records, so the
+// chunks that are repeated many times are not read again for every new chunk.
+type diskSignatureIndex struct {
+	// signatureFile is read again for the unused chunks in their order.
+	signatureFile string
+
+	file       *os.File
+	records    uint64
+	tag        byte
+	digestSize int
+	fences     [][]byte
+	bloom      bloomFilter
+	used       []uint64
+
+	// firstUnused is the position of the first record that may not be used by the position
+	// of the first record of the digest.
+	firstUnused map[uint64]uint64
+
+	// block is the last block of the index that is read in 'buf', or -1.
+	block int64
+	buf   []byte
+}
+
+// newDiskSignatureIndex create an index of the chunks of the signature. The first chunks are
+// already read in 'chunks' and the rest are read from the scanner. 'budget' is the number of
+// bytes of memory for the sorted runs.
+func newDiskSignatureIndex(signatureFile string, chunks []Chunk, s *signatureScanner, budget uint64) (*diskSignatureIndex, error) {
+	runRecords := int(budget / indexRecordMemory)
+	if runRecords < minRunRecords {
+		runRecords = minRunRecords
+	}
+
+	runs, err := os.CreateTemp("", "fdiff-runs-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(runs.Name())
+	defer runs.Close()
+
+	idx := &diskSignatureIndex{signatureFile: signatureFile, firstUnused: map[uint64]uint64{}, block: -1}
+	w := bufio.NewWriter(runs)
+	var runSizes []int64
+	run := make([]indexRecord, 0, runRecords)
+	writeRun := func() error {
+		sort.Slice(run, func(i, j int) bool { return run[i].less(run[j]) })
+		for _, r := range run {
+			if err := idx.writeRecord(w, r); err != nil {
+				return err
+			}
+		}
+		runSizes = append(runSizes, int64(len(run)))
+		run = run[:0]
+		return nil
+	}
+	add := func(ch Chunk) error {
+		if ch.Signature.IsHole() {
+			// the holes are never copied from the old data
+			return nil
+		}
+		if idx.records == 0 {
+			idx.tag, idx.digestSize = ch.Signature.tag, int(ch.Signature.size)
+		} else if ch.Signature.tag != idx.tag || int(ch.Signature.size) != idx.digestSize {
+			return errors.New("the chunks of the signature have signatures of different sizes")
+		}
+		run = append(run, indexRecord{signature: ch.Signature, offset: ch.Offset, length: ch.Length, ordinal: idx.records})
+		idx.records++
+		if len(run) == runRecords {
+			return writeRun()
+		}
+		return nil
+	}
+
+	for _, ch := range chunks {
+		if err = add(ch); err != nil {
+			return nil, err
+		}
+	}
+	for s.Scan() {
+		if err = add(s.Chunk()); err != nil {
+			return nil, err
+		}
+	}
+	if s.Err() != nil {
+		return nil, s.Err()
+	}
+	if len(run) > 0 {
+		if err = writeRun(); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+
+	if idx.file, err = os.CreateTemp("", "fdiff-index-*"); err != nil {
+		return nil, err
+	}
+	if err = idx.merge(runs, runSizes); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// recordSize return the number of bytes of a record in a file: the digest, the offset, the length and the ordinal.
+func (idx *diskSignatureIndex) recordSize() int {
+	return idx.digestSize + 24
+}
+
+func (idx *diskSignatureIndex) writeRecord(w io.Writer, r indexRecord) error {
+	buf := make([]byte, idx.recordSize())
+	copy(buf, r.signature.sum[:idx.digestSize])
+	binary.BigEndian.PutUint64(buf[idx.digestSize:], r.offset)
+	binary.BigEndian.PutUint64(buf[idx.digestSize+8:], r.length)
+	binary.BigEndian.PutUint64(buf[idx.digestSize+16:], r.ordinal)
+	_, err := w.Write(buf)
+	return err
+}
+
+func (idx *diskSignatureIndex) readRecord(buf []byte) indexRecord {
+	return indexRecord{
+		signature: newSignature(idx.tag, buf[:idx.digestSize]),
+		offset:    binary.BigEndian.Uint64(buf[idx.digestSize:]),
+		length:    binary.BigEndian.Uint64(buf[idx.digestSize+8:]),
+		ordinal:   binary.BigEndian.Uint64(buf[idx.digestSize+16:]),
+	}
+}
+
+// merge merge the sorted runs in the index file. The fences and the Bloom filter are created
+// from the merged records.
+func (idx *diskSignatureIndex) merge(runs *os.File, runSizes []int64) error {
+	idx.bloom = newBloomFilter(idx.records)
+	idx.used = make([]uint64, (idx.records+63)/64)
+
+	size := int64(idx.recordSize())
+	h := &runHeap{}
+	var start int64
+	for _, n := range runSizes {
+		r := &runReader{r: bufio.NewReader(io.NewSectionReader(runs, start*size, n*size)), buf: make([]byte, size)}
+		start += n
+		if ok, err := r.next(idx); err != nil {
+			return err
+		} else if ok {
+			heap.Push(h, r)
+		}
+	}
+
+	w := bufio.NewWriter(idx.file)
+	for written := 0; h.Len() > 0; written++ {
+		r := (*h)[0]
+		if written%fenceInterval == 0 {
+			idx.fences = append(idx.fences, append([]byte{}, r.record.signature.sum[:idx.digestSize]...))
+		}
+		idx.bloom.add(r.record.signature.sum[:idx.digestSize])
+		if err := idx.writeRecord(w, r.record); err != nil {
+			return err
+		}
+
+		ok, err := r.next(idx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return w.Flush()
+}
+
+// take return an occurrence of the chunk with the signature and mark it as used.
+// The occurrence is chosen like chunkOccurrences.take chooses it.
+func (idx *diskSignatureIndex) take(signature Signature, next uint64) (Chunk, bool, error) {
+	if signature.tag != idx.tag || int(signature.size) != idx.digestSize || !idx.bloom.contains(signature.sum[:idx.digestSize]) {
+		return Chunk{}, false, nil
+	}
+	start, end, err := idx.find(signature.sum[:idx.digestSize])
+	if err != nil || start == end {
+		return Chunk{}, false, err
+	}
+
+	// the records of the digest are in the order of the signature, so they are ordered by their offsets too
+	i, err := idx.search(start, end, func(r []byte) bool { return idx.offsetOf(r) >= next })
+	if err != nil {
+		return Chunk{}, false, err
+	}
+	atNext, usedAtNext := false, false
+	if i < end {
+		r, err := idx.recordAt(i)
+		if err != nil {
+			return Chunk{}, false, err
+		}
+		atNext, usedAtNext = idx.offsetOf(r) == next, idx.isUsed(idx.ordinalOf(r))
+	}
+	if !atNext || usedAtNext {
+		k, ok := idx.firstUnused[start]
+		if !ok {
+			k = start
+		}
+		for ; k < end; k++ {
+			r, err := idx.recordAt(k)
+			if err != nil {
+				return Chunk{}, false, err
+			}
+			if !idx.isUsed(idx.ordinalOf(r)) {
+				break
+			}
+		}
+		if end-start > 1 {
+			idx.firstUnused[start] = k
+		}
+		switch {
+		case k < end:
+			i = k
+		case !atNext:
+			i = start
+		}
+	}
+
+	r, err := idx.recordAt(i)
+	if err != nil {
+		return Chunk{}, false, err
+	}
+	record := idx.readRecord(r)
+	idx.used[record.ordinal/64] |= 1 << (record.ordinal % 64)
+	return Chunk{Offset: record.offset, Length: record.length, Signature: record.signature}, true, nil
+}
+
+func (idx *diskSignatureIndex) isUsed(ordinal uint64) bool {
+	return idx.used[ordinal/64]&(1<<(ordinal%64)) != 0
+}
+
+// find return the positions of the first record with the digest and of the first record after them.
+// The fences limit both searches to one block.
+func (idx *diskSignatureIndex) find(digest []byte) (uint64, uint64, error) {
+	// the first record with the digest is in the block of the last fence that is less than the digest
+	block := sort.Search(len(idx.fences), func(i int) bool { return bytes.Compare(idx.fences[i], digest) >= 0 })
+	lo, hi := idx.blockRange(block)
+	start, err := idx.search(lo, hi, func(r []byte) bool { return bytes.Compare(r[:idx.digestSize], digest) >= 0 })
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the first record after them is in the block of the last fence that is not greater than the digest
+	block = sort.Search(len(idx.fences), func(i int) bool { return bytes.Compare(idx.fences[i], digest) > 0 })
+	lo, hi = idx.blockRange(block)
+	if lo < start {
+		lo = start
+	}
+	end, err := idx.search(lo, hi, func(r []byte) bool { return bytes.Compare(r[:idx.digestSize], digest) > 0 })
+	return start, end, err
+}
+
+// blockRange return the positions of the records of the block before the fence and the position of the
+// record of the fence.
+func (idx *diskSignatureIndex) blockRange(fence int) (uint64, uint64) {
+	var lo uint64
+	if fence > 0 {
+		lo = uint64(fence-1) * fenceInterval
+	}
+	hi := uint64(fence) * fenceInterval
+	if hi > idx.records {
+		hi = idx.records
+	}
+	return lo, hi
+}
+
+// search return the first position between 'lo' and 'hi' of the record for which 'f' return true,
+// or 'hi' if there is not such a record. 'f' must return true for all records after it.
+func (idx *diskSignatureIndex) search(lo, hi uint64, f func(r []byte) bool) (uint64, error) {
+	for lo < hi {
+		m := lo + (hi-lo)/2
+		r, err := idx.recordAt(m)
+		if err != nil {
+			return 0, err
+		}
+		if f(r) {
+			hi = m
+		} else {
+			lo = m + 1
+		}
+	}
+	return lo, nil
+}
+
+// recordAt return the bytes of the record at the position in the index. The whole block of the record
+// is read, so the next records of the same block don't need a read from the disk.
+func (idx *diskSignatureIndex) recordAt(position uint64) ([]byte, error) {
+	size := int64(idx.recordSize())
+	if block := int64(position / fenceInterval); block != idx.block {
+		if idx.buf == nil {
+			idx.buf = make([]byte, fenceInterval*size)
+		}
+		idx.block = -1
+		if _, err := idx.file.ReadAt(idx.buf, block*fenceInterval*size); err != nil && err != io.EOF {
+			return nil, err
+		}
+		idx.block = block
+	}
+	i := int64(position%fenceInterval) * size
+	return idx.buf[i : i+size], nil
+}
+
+// offsetOf return the offset of the chunk of the record.
+func (idx *diskSignatureIndex) offsetOf(r []byte) uint64 {
+	return binary.BigEndian.Uint64(r[idx.digestSize:])
+}
+
+// ordinalOf return the position of the chunk of the record in the signature.
+func (idx *diskSignatureIndex) ordinalOf(r []byte) uint64 {
+	return binary.BigEndian.Uint64(r[idx.digestSize+16:])
+}
+
+// unused return the chunks that are not used in the order of the signature. The chunks are
+// read again from the signature file.
+func (idx *diskSignatureIndex) unused() ([]Chunk, error) {
+	f, err := os.Open(idx.signatureFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var chunks []Chunk
+	s := newSignatureScanner(f)
+	var ordinal uint64
+	for s.Scan() {
+		if s.Chunk().Signature.IsHole() {
+			continue
+		}
+		if ordinal >= idx.records {
+			return nil, errors.New("the signature file is changed")
+		}
+		if !idx.isUsed(ordinal) {
+			chunks = append(chunks, s.Chunk())
+		}
+		ordinal++
+	}
+	return chunks, s.Err()
+}
+
+// Close closes and removes the index file.
+func (idx *diskSignatureIndex) Close() error {
+	if idx.file == nil {
+		return nil
+	}
+	err := idx.file.Close()
+	if rerr := os.Remove(idx.file.Name()); err == nil {
+		err = rerr
+	}
+	idx.file = nil
+	return err
+}
+
+// runReader reads the records of one sorted run.
+type runReader struct {
+	r      *bufio.Reader
+	buf    []byte
+	record indexRecord
+}
+
+// next read the next record of the run. It return false at the end of the run.
+func (r *runReader) next(idx *diskSignatureIndex) (bool, error) {
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	r.record = idx.readRecord(r.buf)
+	return true, nil
+}
+
+// runHeap is a min-heap of the runs by their current records.
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].record.less(h[j].record) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// bloomFilter is a Bloom filter of digests. The positions of the bits are created from
+// two hashes of the digest (double hashing).
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+}
+
+// newBloomFilter create a Bloom filter for n keys.
+func newBloomFilter(n uint64) bloomFilter {
+	m := n*bloomBitsPerKey + 64
+	return bloomFilter{bits: make([]uint64, (m+63)/64), m: m}
+}
+
+func (b bloomFilter) add(digest []byte) {
+	h1, h2 := bloomHash(digest)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// contains return false if the digest is not added to the filter. It can return
+// true for a digest that is not added.
+func (b bloomFilter) contains(digest []byte) bool {
+	h1, h2 := bloomHash(digest)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash return two hashes of the digest. The digests can be truncated
+// to a few bytes, so they are hashed instead of used as they are.
+func bloomHash(digest []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(digest)
+	h1 := h.Sum64()
+	h.Write([]byte{0})
+	return h1, h.Sum64() | 1
+}
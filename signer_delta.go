@@ -2,13 +2,16 @@ package fdiff
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
 )
 
 // SignerDelta contains methods for sign a file (Sign) anf
@@ -26,6 +29,52 @@ type Delta struct {
 	// OldChunks contains all chunks from the old data
 	// bytes that are removed or updated and are not up-to-date.
 	OldChunks []Chunk
+
+	// Ops contains the operations that create the new data bytes from the old
+	// data bytes. They are in the order of the new data bytes: every chunk of
+	// the new data is either copied from the old data or is one of NewChunks.
+	Ops []Op
+
+	// Edits is the edit script that transforms the sequence of the old chunks
+	// to the sequence of the new chunks. See EditScript.
+	Edits []Edit
+
+	// Checksum is the SHA-1 checksum of the whole new data in hex. The data created
+	// by Ops is verified with it, see VerifyDelta.
+	Checksum string
+
+	// ChangedBlocks contains the indexes of the blocks of the new data that are different from
+	// the blocks of the old data with the same indexes, when the data is split to fixed-size
+	// blocks (see BlockSize). The blocks after the end of the old data are changed too.
+	ChangedBlocks []uint64
+}
+
+// ErrChecksumMismatch is returned when the checksum of the data created by a delta or a patch
+// is not the expected one. When the delta is found with a signature with truncated digests, two
+// different chunks may have equal digests and then the delta must be found with full-length digests.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// addOp add the operation for the next chunk of the new data to the delta. If the
+// operation continues the last one, both are merged. For example two chunks that are
+// next to each other in the old data bytes are copied with one operation.
+func (d *Delta) addOp(op Op) {
+	if n := len(d.Ops); n > 0 {
+		last := &d.Ops[n-1]
+		if last.Type == OpCopy && op.Type == OpCopy && last.SourceOffset+last.Length == op.SourceOffset {
+			last.Length += op.Length
+			return
+		}
+		if last.Type == OpData && op.Type == OpData {
+			last.Data = append(last.Data[:last.Length:last.Length], op.Data...)
+			last.Length += op.Length
+			return
+		}
+		if last.Type == OpZero && op.Type == OpZero {
+			last.Length += op.Length
+			return
+		}
+	}
+	d.Ops = append(d.Ops, op)
 }
 
 // Chunk represent one chunk of the data bytes.
@@ -41,136 +90,706 @@ type Chunk struct {
 
 	// Signature is the unique signature/hash of the data.
 	// Two chunks with equal Data will have the same Signature.
-	Signature string
+	Signature Signature
 }
 
 // String return string representation of the chunk in the format <offset>-<length>-<signature>.
 func (ch Chunk) String() string {
-	return fmt.Sprintf("%d-%d-%s", ch.Offset, len(ch.Data), ch.Signature)
+	return fmt.Sprintf("%d-%d-%s", ch.Offset, ch.Length, ch.Signature)
 }
 
-// createChunkFromString create a new chunk from a string. The parameter
-// 'str' MUST contain a value in format <offset>-<length>-<signature>.
-func createChunkFromString(str string) Chunk {
+// createChunkFromString create a new chunk from a string. The parameter 'str' MUST contain
+// a value in format <offset>-<length>-<signature>, where the signature is created by the strong
+// hash and is truncated to 'digestLength' bytes (0 if it is not truncated).
+func createChunkFromString(str, strongHash string, digestLength int) (Chunk, error) {
 	p := strings.Split(str, "-")
 	if len(p) != 3 {
-		return Chunk{}
+		return Chunk{}, fmt.Errorf("invalid chunk %q", str)
 	}
 
-	offset, _ := strconv.ParseUint(p[0], 10, 64)
-	length, _ := strconv.ParseUint(p[1], 10, 64)
+	offset, err := strconv.ParseUint(p[0], 10, 64)
+	if err != nil {
+		return Chunk{}, fmt.Errorf("invalid offset of chunk %q: %w", str, err)
+	}
+	length, err := strconv.ParseUint(p[1], 10, 64)
+	if err != nil {
+		return Chunk{}, fmt.Errorf("invalid length of chunk %q: %w", str, err)
+	}
+	if p[2] == holeSignatureText {
+		return Chunk{Offset: offset, Length: length, Signature: holeSignature(length)}, nil
+	}
+	signature, err := parseSignature(strongHash, p[2], digestLength)
+	if err != nil {
+		return Chunk{}, fmt.Errorf("invalid signature of chunk %q: %w", str, err)
+	}
 
 	return Chunk{
 		Offset:    offset,
 		Length:    length,
-		Signature: p[2],
+		Signature: signature,
+	}, nil
+}
+
+// SignatureHeader contains information about the whole file that is signed.
+// It is stored in the beginning of the signature file, before the chunks.
+type SignatureHeader struct {
+	// FileSize is the number of bytes in the signed file.
+	FileSize uint64
+
+	// FileChecksum is the SHA-1 checksum of the whole signed file. It is
+	// used to verify a file that is reconstructed from the signature.
+	FileChecksum string
+
+	// StrongHash is the name of the hash that created the signatures of the chunks. The
+	// signatures without it (created by older versions of the tool) are created with SHA-1.
+	StrongHash string
+
+	// MerkleRoot is the root of the Merkle tree over the chunks of the signature in hex. Two
+	// signatures with equal roots have equal chunks. It is empty in older signature files.
+	// See MerkleTree.
+	MerkleRoot string
+
+	// DigestLength is the number of bytes of the digests of the signatures of the chunks
+	// when they are truncated. It is 0 when the digests are not truncated. See DigestLengthOf.
+	DigestLength int
+
+	// BlockSize is the size of the blocks when the file is split to fixed-size blocks. Then
+	// the chunks are stored by their indexes. It is 0 for content-defined chunks.
+	BlockSize uint64
+
+	// BlockAlignment is the offset of the first whole block. See BlockAlignment of ChunkConfig.
+	BlockAlignment uint64
+}
+
+const (
+	headerFileSize       = "file-size"
+	headerFileChecksum   = "file-checksum"
+	headerStrongHash     = "strong-hash"
+	headerMerkleRoot     = "merkle-root"
+	headerDigestLength   = "digest-length"
+	headerBlockSize      = "block-size"
+	headerBlockAlignment = "block-alignment"
+)
+
+// String return the header as lines in the format #<key>: <value>.
+func (h SignatureHeader) String() string {
+	s := fmt.Sprintf("#%s: %d\n#%s: %s\n", headerFileSize, h.FileSize, headerFileChecksum, h.FileChecksum)
+	if h.StrongHash != "" {
+		s += fmt.Sprintf("#%s: %s\n", headerStrongHash, h.StrongHash)
+	}
+	if h.MerkleRoot != "" {
+		s += fmt.Sprintf("#%s: %s\n", headerMerkleRoot, h.MerkleRoot)
+	}
+	if h.DigestLength > 0 {
+		s += fmt.Sprintf("#%s: %d\n", headerDigestLength, h.DigestLength)
+	}
+	if h.BlockSize > 0 {
+		s += fmt.Sprintf("#%s: %d\n", headerBlockSize, h.BlockSize)
 	}
+	if h.BlockAlignment > 0 {
+		s += fmt.Sprintf("#%s: %d\n", headerBlockAlignment, h.BlockAlignment)
+	}
+	return s
+}
+
+// blocks return the layout of the blocks of the signature.
+func (h SignatureHeader) blocks() blockLayout {
+	return blockLayoutOf(ChunkConfig{BlockSize: h.BlockSize, BlockAlignment: h.BlockAlignment})
+}
+
+// StrongHashName return the name of the hash that created the signatures of the chunks.
+// The signature files without the name of the strong hash are created with SHA-1.
+func (h SignatureHeader) StrongHashName() string {
+	if h.StrongHash == "" {
+		return StrongHashSHA1
+	}
+	return h.StrongHash
+}
+
+// parseHeaderLine set the field of the header that is described in the line. The
+// parameter 'line' MUST contain a value in format #<key>: <value>. Unknown keys are
+// ignored, so newer signature files can be read by older versions of the tool.
+func (h *SignatureHeader) parseHeaderLine(line string) error {
+	key, value, ok := strings.Cut(strings.TrimPrefix(line, "#"), ":")
+	if !ok {
+		return fmt.Errorf("invalid header %q", line)
+	}
+	value = strings.TrimSpace(value)
+
+	switch key {
+	case headerFileSize:
+		size, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid file size %q: %w", value, err)
+		}
+		h.FileSize = size
+	case headerFileChecksum:
+		h.FileChecksum = value
+	case headerStrongHash:
+		h.StrongHash = value
+	case headerMerkleRoot:
+		h.MerkleRoot = value
+	case headerDigestLength:
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid digest length %q", value)
+		}
+		h.DigestLength = length
+	case headerBlockSize, headerBlockAlignment:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+		if key == headerBlockSize {
+			h.BlockSize = n
+		} else {
+			h.BlockAlignment = n
+		}
+	}
+	return nil
+}
+
+// createSignatureHeader read the whole file and create its header.
+func createSignatureHeader(file string) (SignatureHeader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return SignatureHeader{}, err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return SignatureHeader{}, err
+	}
+	return SignatureHeader{FileSize: uint64(n), FileChecksum: fmt.Sprintf("%x", h.Sum(nil))}, nil
+}
+
+// DecodeSignature reads a signature created by Sign. It returns the header
+// of the signature and the chunks in the order in which they are stored.
+func DecodeSignature(r io.Reader) (SignatureHeader, []Chunk, error) {
+	var chunks []Chunk
+	s := newSignatureScanner(r)
+	for s.Scan() {
+		chunks = append(chunks, s.Chunk())
+	}
+	if s.Err() != nil {
+		return SignatureHeader{}, nil, s.Err()
+	}
+	return s.Header(), chunks, nil
+}
+
+// signatureScanner reads the chunks of a signature one by one, so a big signature
+// doesn't have to be loaded in memory. The lines of the header are written by Sign
+// before the chunks, so the header is complete when the first chunk is read.
+type signatureScanner struct {
+	scanner *bufio.Scanner
+	header  SignatureHeader
+	chunk   Chunk
+	err     error
+}
+
+func newSignatureScanner(r io.Reader) *signatureScanner {
+	return &signatureScanner{scanner: bufio.NewScanner(r)}
+}
+
+// Scan read the next chunk of the signature. It return false at the end of the signature or on an error.
+func (s *signatureScanner) Scan() bool {
+	for s.err == nil && s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			s.err = s.header.parseHeaderLine(line)
+			continue
+		}
+
+		if s.header.BlockSize > 0 {
+			s.chunk, s.err = createBlockFromString(line, s.header)
+		} else {
+			s.chunk, s.err = createChunkFromString(line, s.header.StrongHashName(), s.header.DigestLength)
+		}
+		return s.err == nil
+	}
+	if s.err == nil {
+		s.err = s.scanner.Err()
+	}
+	return false
+}
+
+// Chunk return the last chunk that is read by Scan.
+func (s *signatureScanner) Chunk() Chunk {
+	return s.chunk
+}
+
+// Header return the header of the signature that is read until now.
+func (s *signatureScanner) Header() SignatureHeader {
+	return s.header
+}
+
+// Err return the first error that is found by Scan.
+func (s *signatureScanner) Err() error {
+	return s.err
 }
 
 type fileSignerDelta struct {
 	chunks <-chan Chunk
 	data   chan<- byte
+
+	// parallel split the files to chunks instead of the chunker that receives the data
+	// from the channel 'data'. It is nil when the chunker is used.
+	parallel *ParallelChunker
+
+	// strongHash is the name of the hash with which the chunker creates the signatures of the chunks.
+	strongHash string
+
+	// config is the configuration of the chunker. It set the length of the digests in the signature files.
+	config ChunkConfig
+
+	// err is the error of the creation of the ParallelChunker, which is returned by Sign and FindDelta.
+	err error
 }
 
-// NewFileSignerDelta initialize and return a new SignerDelta.
+// NewFileSignerDelta initialize and return a new SignerDelta. The chunks that are
+// received from the chunker must be signed with DefaultStrongHash.
 func NewFileSignerDelta(d chan<- byte, ch <-chan Chunk) SignerDelta {
+	return NewFileSignerDeltaWithStrongHash(d, ch, DefaultStrongHash)
+}
+
+// NewFileSignerDeltaWithStrongHash initialize and return a new SignerDelta. The chunks that
+// are received from the chunker must be signed with the strong hash with the name. The name is
+// recorded in the signature files and FindDelta refuses signatures created with another hash.
+func NewFileSignerDeltaWithStrongHash(d chan<- byte, ch <-chan Chunk, strongHash string) SignerDelta {
+	return NewFileSignerDeltaWithConfig(d, ch, ChunkConfig{StrongHash: strongHash})
+}
+
+// NewFileSignerDeltaWithConfig initialize and return a new SignerDelta. The chunks that are received
+// from the chunker must be created with the configuration. Besides the strong hash, the configuration
+// set the length of the digests in the signature files, see DigestLengthOf.
+func NewFileSignerDeltaWithConfig(d chan<- byte, ch <-chan Chunk, cfg ChunkConfig) SignerDelta {
+	return fileSignerDelta{
+		chunks:     ch,
+		data:       d,
+		strongHash: strongHashName(cfg.StrongHash),
+		config:     cfg,
+	}
+}
+
+// NewParallelFileSignerDelta initialize and return a new SignerDelta that splits the files to
+// chunks by itself with a ParallelChunker with 'jobs' workers. The chunks are identical to the
+// chunks of a Chunker with the configuration, so the signatures are the same, except for the
+// holes of sparse files, which only this SignerDelta signs as holes. Unlike the other
+// SignerDeltas it can be used for many files, and the files that can be mapped to the memory are
+// scanned in place instead of being sent byte by byte to a Chunker. When the strong hash of the
+// configuration is not registered, Sign and FindDelta return the error of NewParallelChunker.
+func NewParallelFileSignerDelta(new func([]byte) rollinghash.Hash, cfg ChunkConfig, jobs int) SignerDelta {
+	pc, err := NewParallelChunker(new, cfg, jobs)
 	return fileSignerDelta{
-		chunks: ch,
-		data:   d,
+		parallel:   pc,
+		err:        err,
+		strongHash: strongHashName(cfg.StrongHash),
+		config:     cfg,
 	}
 }
 
+// chunksOf return the channel through which the chunks of the file are received. When the file
+// is mapped to the memory, the data of the chunks are its subslices and can be used until the
+// returned file is closed. Otherwise, the returned file is nil. The error of reading the file
+// (or nil) is received through the error channel after the channel of the chunks is closed.
+func (fsd fileSignerDelta) chunksOf(file string) (<-chan Chunk, <-chan error, *mappedFile, error) {
+	if fsd.parallel == nil {
+		errc, err := fsd.sendFileDataToChunkerWorker(file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return fsd.chunks, errc, nil, nil
+	}
+
+	return fsd.parallel.chunksOfFile(file)
+}
+
 // Sign create a new file that contains chunk's signatures of a file. The method
 // read all data from a file and send bytes to the chunker worker. Then ged created
-// chunks and store them to signatureFile.
+// chunks and store them to signatureFile. The header of the signature contains the
+// root of the Merkle tree over the chunks. The chunks are not kept in memory: the root is
+// calculated while they are stored and written in its reserved place at the end. The digests of the signatures are truncated when the configuration
+// of the SignerDelta require it. The fixed-size blocks are stored by their indexes
+// instead of their offsets and lengths.
 func (fsd fileSignerDelta) Sign(file, signatureFile string) error {
-	f, err := os.Create(signatureFile)
+	if fsd.err != nil {
+		return fsd.err
+	}
+	header, err := createSignatureHeader(file)
+	if err != nil {
+		return err
+	}
+	header.DigestLength, err = DigestLengthOf(fsd.config, header.FileSize)
 	if err != nil {
 		return err
 	}
 
-	if err = fsd.sendFileDataToChunkerWorker(file); err != nil {
+	f, err := os.Create(signatureFile)
+	if err != nil {
 		return err
 	}
 
 	defer f.Close()
-	for ch := range fsd.chunks {
-		_, _ = f.Write([]byte(ch.String() + "\n"))
+	fileChunks, errc, mapped, err := fsd.chunksOf(file)
+	if err != nil {
+		return err
+	}
+	defer mapped.Close()
+
+	header.StrongHash = fsd.strongHash
+	blocks := blockLayoutOf(fsd.config)
+	header.BlockSize, header.BlockAlignment = blocks.size, blocks.alignment
+	if header.FileSize > 0 {
+		// the root is known only after all chunks are written, so its place in the header is reserved
+		header.MerkleRoot = strings.Repeat("0", 2*sha256.Size)
+	}
+	headerString := header.String()
+
+	w := bufio.NewWriter(f)
+	_, _ = w.WriteString(headerString)
+	var root merkleRoot
+	var size uint64
+	for ch := range fileChunks {
+		ch = Chunk{Offset: ch.Offset, Length: ch.Length, Signature: ch.Signature.Truncate(header.DigestLength)}
+		root.add(ch)
+		size += ch.Length
+		if blocks.size > 0 {
+			_, _ = w.WriteString(blocks.chunkString(ch) + "\n")
+			continue
+		}
+		_, _ = w.WriteString(ch.String() + "\n")
+	}
+	if err = <-errc; err != nil {
+		return err
+	}
+	if size != header.FileSize {
+		return fmt.Errorf("the chunks of the file contain %d bytes, but the file has %d bytes", size, header.FileSize)
 	}
-	return err
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if header.FileSize > 0 {
+		line := fmt.Sprintf("#%s: ", headerMerkleRoot)
+		if _, err = f.WriteAt([]byte(root.String()), int64(strings.Index(headerString, line)+len(line))); err != nil {
+			return err
+		}
+	}
+	return f.Close()
 }
 
 // FindDelta find the difference between old and new version of a file. The method accept two parameters,
 // the first one, fileSignature, is the file that contains all chunks' signatures that are used to find
-// difference in the new version of the file 'newFile'.
+// difference in the new version of the file 'newFile'. The signatures in the signature file must be
+// created with the same strong hash as the signatures of the chunks of the new file, otherwise equal
+// chunks can't be found and an error is returned. When the digests in the signature file are truncated,
+// the signatures of the new chunks are truncated too before they are compared, so a chunk can be copied
+// from the old file by mistake. It is detected by VerifyDelta with the checksum of the delta.
+//
+// When MemoryBudget of the configuration is set and the chunks of the signature need more memory, the
+// chunks are stored in an index on the disk instead (see diskSignatureIndex) and the delta doesn't
+// contain Edits, because they need all old chunks in memory. The ChangedBlocks are found while the
+// old blocks are read again from the signature file.
 func (fsd fileSignerDelta) FindDelta(fileSignature, newFile string) (Delta, error) {
-	chunks := decodeChunksOfSignatureFile(fileSignature)
-	if err := fsd.sendFileDataToChunkerWorker(newFile); err != nil {
+	if fsd.err != nil {
+		return Delta{}, fsd.err
+	}
+	f, err := os.Open(fileSignature)
+	if err != nil {
+		return Delta{}, err
+	}
+	defer f.Close()
+
+	s := newSignatureScanner(f)
+	var oldChunks []Chunk
+	for (fsd.config.MemoryBudget == 0 || uint64(len(oldChunks))*chunkMemory < fsd.config.MemoryBudget) && s.Scan() {
+		oldChunks = append(oldChunks, s.Chunk())
+	}
+	if s.Err() != nil {
+		return Delta{}, s.Err()
+	}
+	header := s.Header()
+	if err = checkStrongHash(header, fsd.strongHash); err != nil {
+		return Delta{}, err
+	}
+	if err = checkBlocks(header, fsd.config); err != nil {
 		return Delta{}, err
 	}
 
+	if fsd.config.MemoryBudget == 0 || uint64(len(oldChunks))*chunkMemory < fsd.config.MemoryBudget {
+		newChunks, errc, mapped, err := fsd.chunksOf(newFile)
+		if err != nil {
+			return Delta{}, err
+		}
+		d := deltaOfChunks(oldChunks, newChunks, header.DigestLength, header.blocks())
+		if err = <-errc; err != nil {
+			mapped.Close()
+			return Delta{}, err
+		}
+		return mapped.release(d), nil
+	}
+
+	idx, err := newDiskSignatureIndex(fileSignature, oldChunks, s, fsd.config.MemoryBudget)
+	if err != nil {
+		return Delta{}, err
+	}
+	defer idx.Close()
+
+	var visit func(Chunk)
+	var blocks *blockChanges
+	var oldBlocks *signatureScanner
+	if header.blocks().size > 0 {
+		sf, err := os.Open(fileSignature)
+		if err != nil {
+			return Delta{}, err
+		}
+		defer sf.Close()
+		oldBlocks = newSignatureScanner(sf)
+		blocks = newBlockChanges(header.blocks(), func() (Chunk, bool) {
+			if oldBlocks.Scan() {
+				return oldBlocks.Chunk(), true
+			}
+			return Chunk{}, false
+		})
+		visit = blocks.add
+	}
+
+	newChunks, errc, mapped, err := fsd.chunksOf(newFile)
+	if err != nil {
+		return Delta{}, err
+	}
+	d, err := matchChunks(idx, newChunks, header.DigestLength, visit)
+	if chunkErr := <-errc; err == nil {
+		err = chunkErr
+	}
+	if err == nil && blocks != nil {
+		d.ChangedBlocks, err = blocks.changed, oldBlocks.Err()
+	}
+	if err != nil {
+		mapped.Close()
+		return Delta{}, err
+	}
+	return mapped.release(d), nil
+}
+
+// deltaOfChunks find the difference between the chunks of the old data and the chunks of the
+// new data that are received through the channel. The digests of the signatures of the new chunks
+// are truncated to 'digestLength' bytes (if it is not 0) like the signatures of the old chunks.
+// When the chunks are fixed-size blocks with the layout, the changed blocks are found too.
+func deltaOfChunks(oldChunks []Chunk, chunks <-chan Chunk, digestLength int, blocks blockLayout) Delta {
 	var newChunks []Chunk
-	for ch := range fsd.chunks {
-		if _, ok := chunks[ch.Signature]; ok {
-			delete(chunks, ch.Signature)
+	// the chunks are in memory, so matchChunks doesn't return errors
+	d, _ := matchChunks(newChunkOccurrences(oldChunks), chunks, digestLength, func(ch Chunk) {
+		newChunks = append(newChunks, ch)
+	})
+	d.Edits = EditScript(oldChunks, newChunks)
+	if blocks.size > 0 {
+		d.ChangedBlocks = blocks.changed(oldChunks, newChunks)
+	}
+	return d
+}
+
+// chunkMultiset is a multiset of the chunks of the old data. It is implemented by chunkOccurrences
+// in memory and by diskSignatureIndex on the disk.
+type chunkMultiset interface {
+	// take return an occurrence of the chunk with the signature and mark it as used.
+	// See chunkOccurrences.take.
+	take(signature Signature, next uint64) (Chunk, bool, error)
+
+	// unused return the occurrences that are not used in the order of their offsets.
+	unused() ([]Chunk, error)
+}
+
+// matchChunks find the difference between the old chunks and the chunks of the new data that are
+// received through the channel, without the edit script. Every new chunk with its truncated signature
+// and without its data is passed to 'visit' if it is not nil. On an error the channel is drained, so
+// the chunker is not blocked.
+func matchChunks(old chunkMultiset, chunks <-chan Chunk, digestLength int, visit func(Chunk)) (Delta, error) {
+	var d Delta
+	checksum := sha1.New()
+	// next is the end of the last copied old chunk. The old chunk that starts there is
+	// preferred, so the copies of the chunks that are next to each other are merged.
+	var next uint64
+	for ch := range chunks {
+		signature := ch.Signature.Truncate(digestLength)
+		if visit != nil {
+			visit(Chunk{Offset: ch.Offset, Length: ch.Length, Signature: signature})
+		}
+		if signature.IsHole() {
+			// the hole is recreated without the old data
+			_ = writeZeros(checksum, ch.Length)
+			d.addOp(Op{Type: OpZero, Length: ch.Length})
 			continue
 		}
-		newChunks = append(newChunks, ch)
+		checksum.Write(ch.Data)
+		oldChunk, ok, err := old.take(signature, next)
+		if err != nil {
+			for range chunks {
+			}
+			return Delta{}, err
+		}
+		if ok {
+			d.addOp(Op{Type: OpCopy, SourceOffset: oldChunk.Offset, Length: oldChunk.Length})
+			next = oldChunk.Offset + oldChunk.Length
+			continue
+		}
+		d.NewChunks = append(d.NewChunks, ch)
+		d.addOp(Op{Type: OpData, Length: ch.Length, Data: ch.Data})
 	}
 
-	var oldChunks []Chunk
-	for _, ch := range chunks {
-		oldChunks = append(oldChunks, ch)
+	var err error
+	if d.OldChunks, err = old.unused(); err != nil {
+		return Delta{}, err
 	}
+	d.Checksum = fmt.Sprintf("%x", checksum.Sum(nil))
+	return d, nil
+}
 
-	sort.Slice(oldChunks, func(i, j int) bool {
-		return oldChunks[i].Offset < oldChunks[j].Offset
-	})
-	return Delta{NewChunks: newChunks, OldChunks: oldChunks}, nil
+// VerifyDelta apply the operations of the delta to the old data and compare the checksum of
+// the created data with the checksum of the delta. If they are different ErrChecksumMismatch
+// is returned.
+func VerifyDelta(d Delta, old io.ReaderAt) error {
+	h := sha1.New()
+	if err := (Patch{Ops: d.Ops}).Apply(old, h); err != nil {
+		return err
+	}
+	if sum := fmt.Sprintf("%x", h.Sum(nil)); sum != d.Checksum {
+		return fmt.Errorf("%w: the checksum of the new data is %s, expected %s", ErrChecksumMismatch, sum, d.Checksum)
+	}
+	return nil
 }
 
 // sendFileDataToChunkerWorker sends the data in the file
 // through a channel to worker that will split data to chunks.
 //
 // The parameter 'file' is the file of which should be split to chunks.
-func (fsd fileSignerDelta) sendFileDataToChunkerWorker(file string) error {
+// The error of reading the file (or nil) is received through the returned
+// channel after all data is sent. The file is read with a buffer, because
+// every byte is sent through the channel anyway; only the SignerDelta of
+// NewParallelFileSignerDelta scans the files in place in memory mappings.
+func (fsd fileSignerDelta) sendFileDataToChunkerWorker(file string) (<-chan error, error) {
 	f, err := os.Open(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
+	errc := make(chan error, 1)
 	go func() {
-		for {
-			data := make([]byte, 48)
-			n, errr := f.Read(data)
-			if errr != nil {
-				close(fsd.data)
-				if err != io.EOF {
-					return
-				}
-				return
-			}
-			for _, b := range data[:n] {
-				fsd.data <- b
-			}
-		}
+		defer f.Close()
+		errc <- sendData(f, fsd.data)
 	}()
-	return nil
+	return errc, nil
 }
 
-func decodeChunksOfSignatureFile(f string) map[string]Chunk {
-	chunks := map[string]Chunk{}
-	file, err := os.Open(f)
-	if err != nil {
-		log.Fatal(err)
+// sendData read the data with a buffer and send it byte by byte through the channel. The channel
+// is closed when all data is sent or the reading fails. The error of the reading is returned.
+func sendData(r io.Reader, b chan<- byte) error {
+	defer close(b)
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b <- c
 	}
-	defer file.Close()
+}
 
-	scanner := bufio.NewScanner(file)
-	// optionally, resize scanner's capacity for lines over 64K, see next example
-	for scanner.Scan() {
-		ch := createChunkFromString(scanner.Text())
-		chunks[ch.Signature] = ch
+// chunkMemory is the approximate number of bytes of memory that one old chunk needs in chunkOccurrences.
+const chunkMemory = 200
+
+// chunkOccurrences is a multiset of the chunks of the old data. The same chunk can be found
+// several times in the data, so every occurrence is kept with its offset.
+type chunkOccurrences struct {
+	chunks      []Chunk
+	bySignature map[Signature][]int
+	byOffset    map[uint64]int
+	used        []bool
+
+	// firstUnused is the index in bySignature of the first occurrence that may not be used.
+	firstUnused map[Signature]int
+}
+
+// newChunkOccurrences create a multiset of the chunks. The chunks must be ordered by their offsets.
+// The holes are not in the multiset, because they are never copied from the old data.
+func newChunkOccurrences(chunks []Chunk) *chunkOccurrences {
+	o := &chunkOccurrences{
+		chunks:      chunks,
+		bySignature: map[Signature][]int{},
+		byOffset:    map[uint64]int{},
+		used:        make([]bool, len(chunks)),
+		firstUnused: map[Signature]int{},
+	}
+	for i, ch := range chunks {
+		if ch.Signature.IsHole() {
+			o.used[i] = true
+			continue
+		}
+		o.bySignature[ch.Signature] = append(o.bySignature[ch.Signature], i)
+		o.byOffset[ch.Offset] = i
+	}
+	return o
+}
+
+// take return an occurrence of the chunk with the signature and mark it as used. An occurrence
+// that is not used yet is preferred, so every old chunk is used once when it is possible, and
+// among them the one that starts at 'next'. When all occurrences are used, the content is
+// repeated in the new data and any occurrence is returned, again preferring the one at 'next'.
+func (o *chunkOccurrences) take(signature Signature, next uint64) (Chunk, bool, error) {
+	indexes, ok := o.bySignature[signature]
+	if !ok {
+		return Chunk{}, false, nil
+	}
+
+	i, atNext := o.byOffset[next]
+	atNext = atNext && o.chunks[i].Signature == signature
+	if !atNext || o.used[i] {
+		k := o.firstUnused[signature]
+		for k < len(indexes) && o.used[indexes[k]] {
+			k++
+		}
+		o.firstUnused[signature] = k
+		switch {
+		case k < len(indexes):
+			i = indexes[k]
+		case !atNext:
+			i = indexes[0]
+		}
 	}
-	return chunks
+	o.used[i] = true
+	return o.chunks[i], true, nil
+}
+
+// unused return the occurrences that are not used in the order of their offsets.
+func (o *chunkOccurrences) unused() ([]Chunk, error) {
+	var chunks []Chunk
+	for i, ch := range o.chunks {
+		if !o.used[i] {
+			chunks = append(chunks, ch)
+		}
+	}
+	return chunks, nil
+}
+
+// checkStrongHash return an error if the signatures of the header are not created with the strong hash.
+func checkStrongHash(header SignatureHeader, strongHash string) error {
+	if signed := header.StrongHashName(); signed != strongHashName(strongHash) {
+		return fmt.Errorf("the signature is created with strong hash %s, but the chunks are signed with %s", signed, strongHashName(strongHash))
+	}
+	return nil
+}
+
+// checkBlocks return an error if the file is not split to the same blocks as the signed file.
+func checkBlocks(header SignatureHeader, cfg ChunkConfig) error {
+	if signed, blocks := header.blocks(), blockLayoutOf(cfg); signed != blocks {
+		return fmt.Errorf("the signature is created with blocks of %d bytes aligned at %d, but the file is split to blocks of %d bytes aligned at %d (0 is content-defined chunks)",
+			signed.size, signed.alignment, blocks.size, blocks.alignment)
+	}
+	return nil
 }
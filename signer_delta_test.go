@@ -1,14 +1,18 @@
 package fdiff_test
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,7 +20,7 @@ func TestSign(t *testing.T) {
 	// SetUp
 	d := make(chan byte, 100)
 	ch := make(chan fdiff.Chunk, 100)
-	fs := fdiff.NewFileSignerDelta(d, ch)
+	fs := fdiff.NewFileSignerDeltaWithStrongHash(d, ch, fdiff.StrongHashSHA1)
 	fch := fakeChunker{data: d, chunks: ch, windowsSize: 48}
 	fch.Start("./test/expected_sign_test_data")
 	defer os.Remove("./test/expected_sign_test_data")
@@ -27,7 +31,54 @@ func TestSign(t *testing.T) {
 
 	// Assert
 	assert.Nil(t, err)
-	assert.True(t, equalFileContent("./test/expected_sign_test_data", "./test/sign_test_data"))
+	expected := readFile("./test/expected_sign_test_data")
+	assert.Equal(t, signatureHeader("./test/test_data", expected)+expected, readFile("./test/sign_test_data"))
+}
+
+func TestDecodeSignature(t *testing.T) {
+	// SetUp
+	signature := "#file-size: 57\n" +
+		"#file-checksum: 2fd4e1c67a2d28fced849ee1bb76e7391b93eb12\n" +
+		"#unknown-key: some value\n" +
+		"0-30-9d23da68e8d2e7b42b1e021b1a4c2912a827f285\n" +
+		"30-27-98d34d28921ae7f4c29bcfc1ddd4a87b1dcaf455\n"
+
+	// Action
+	header, chunks, err := fdiff.DecodeSignature(strings.NewReader(signature))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, fdiff.SignatureHeader{FileSize: 57, FileChecksum: "2fd4e1c67a2d28fced849ee1bb76e7391b93eb12"}, header)
+	assert.Equal(t, fdiff.StrongHashSHA1, header.StrongHashName())
+	assert.Equal(t, []fdiff.Chunk{
+		{Offset: 0, Length: 30, Signature: parseSHA1("9d23da68e8d2e7b42b1e021b1a4c2912a827f285")},
+		{Offset: 30, Length: 27, Signature: parseSHA1("98d34d28921ae7f4c29bcfc1ddd4a87b1dcaf455")},
+	}, chunks)
+}
+
+func TestDecodeSignature_WithStrongHash(t *testing.T) {
+	// SetUp
+	signature := "#file-size: 57\n" +
+		"#strong-hash: sha256\n" +
+		"0-57-a6f5d1a6c6cbf2c4fb6b1c8e0c4a3c4e2e0ab0d6b9dd27a3b1d1ee2a8e0a3f31\n"
+
+	// Action
+	_, chunks, err := fdiff.DecodeSignature(strings.NewReader(signature))
+	_, _, invalidErr := fdiff.DecodeSignature(strings.NewReader("#strong-hash: sha256\n0-30-9d23da68e8d2e7b42b1e021b1a4c2912a827f285\n"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, fdiff.StrongHashSHA256, chunks[0].Signature.StrongHash())
+	assert.Equal(t, "a6f5d1a6c6cbf2c4fb6b1c8e0c4a3c4e2e0ab0d6b9dd27a3b1d1ee2a8e0a3f31", chunks[0].Signature.String())
+	assert.NotNil(t, invalidErr)
+}
+
+func TestDecodeSignature_WhenChunkIsInvalid(t *testing.T) {
+	// Action
+	_, _, err := fdiff.DecodeSignature(strings.NewReader("0-abc-9d23da68e8d2e7b42b1e021b1a4c2912a827f285\n"))
+
+	// Assert
+	assert.NotNil(t, err)
 }
 
 func TestFindDelta(t *testing.T) {
@@ -46,7 +97,7 @@ func TestFindDelta(t *testing.T) {
 
 	d := make(chan byte, 100)
 	ch := make(chan fdiff.Chunk, 100)
-	fs := fdiff.NewFileSignerDelta(d, ch)
+	fs := fdiff.NewFileSignerDeltaWithStrongHash(d, ch, fdiff.StrongHashSHA1)
 	fch := fakeChunker{data: d, chunks: ch, windowsSize: 30}
 	fch.Start("")
 
@@ -60,47 +111,336 @@ func TestFindDelta(t *testing.T) {
 				Offset:    60,
 				Data:      []byte("bin fingerprints to (THIS IS A"),
 				Length:    30,
-				Signature: "b80cb62f9823ff1143099a32a6f46f7798a6b92d",
+				Signature: parseSHA1("b80cb62f9823ff1143099a32a6f46f7798a6b92d"),
 			},
 			{
 				Offset:    90,
 				Data:      []byte(" NEW DATA)implement variable s"),
 				Length:    30,
-				Signature: "9ff86b8abc189759d45caab3a1c13704b12f63fe",
+				Signature: parseSHA1("9ff86b8abc189759d45caab3a1c13704b12f63fe"),
 			},
 			{
 				Offset:    120,
 				Data:      []byte("ize shift-resistant blocks."),
 				Length:    27,
-				Signature: "521400e2cf500bb9f745807e8f62e047d566f7d8",
+				Signature: parseSHA1("521400e2cf500bb9f745807e8f62e047d566f7d8"),
 			},
 		},
 		OldChunks: []fdiff.Chunk{
 			{
 				Offset:    60,
 				Length:    30,
-				Signature: "9d23da68e8d2e7b42b1e021b1a4c2912a827f285",
+				Signature: parseSHA1("9d23da68e8d2e7b42b1e021b1a4c2912a827f285"),
 			},
 			{
 				Offset:    90,
 				Length:    30,
-				Signature: "301054dadc4095e21ea59492f52ae2518c9d4195",
+				Signature: parseSHA1("301054dadc4095e21ea59492f52ae2518c9d4195"),
 			},
 			{
 				Offset:    120,
 				Length:    7,
-				Signature: "98d34d28921ae7f4c29bcfc1ddd4a87b1dcaf455",
+				Signature: parseSHA1("98d34d28921ae7f4c29bcfc1ddd4a87b1dcaf455"),
 			},
 		},
+		Ops: []fdiff.Op{
+			{Type: fdiff.OpCopy, SourceOffset: 0, Length: 60},
+			{Type: fdiff.OpData, Length: 87, Data: newFileData[60:]},
+		},
+		Edits: []fdiff.Edit{
+			{Type: fdiff.EditKeep, OldOffset: 0, NewOffset: 0, Length: 30, Signature: parseSHA1("0bc302feb3e53a0e7c9815e19a80532009bbe40b")},
+			{Type: fdiff.EditKeep, OldOffset: 30, NewOffset: 30, Length: 30, Signature: parseSHA1("3adc52a816b863e729296a4ab402790598ff2586")},
+			{Type: fdiff.EditDelete, OldOffset: 60, Length: 30, Signature: parseSHA1("9d23da68e8d2e7b42b1e021b1a4c2912a827f285")},
+			{Type: fdiff.EditDelete, OldOffset: 90, Length: 30, Signature: parseSHA1("301054dadc4095e21ea59492f52ae2518c9d4195")},
+			{Type: fdiff.EditDelete, OldOffset: 120, Length: 7, Signature: parseSHA1("98d34d28921ae7f4c29bcfc1ddd4a87b1dcaf455")},
+			{Type: fdiff.EditInsert, NewOffset: 60, Length: 30, Signature: parseSHA1("b80cb62f9823ff1143099a32a6f46f7798a6b92d")},
+			{Type: fdiff.EditInsert, NewOffset: 90, Length: 30, Signature: parseSHA1("9ff86b8abc189759d45caab3a1c13704b12f63fe")},
+			{Type: fdiff.EditInsert, NewOffset: 120, Length: 27, Signature: parseSHA1("521400e2cf500bb9f745807e8f62e047d566f7d8")},
+		},
+		Checksum: fmt.Sprintf("%x", sha1.Sum(newFileData)),
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestFindDelta_WhenChunksAreRepeated(t *testing.T) {
+	block := "0123456789"
+	other := "abcdefghij"
+	cases := []struct {
+		name      string
+		oldData   string
+		newData   string
+		ops       []fdiff.Op
+		oldChunks []uint64
+	}{
+		{
+			name:    "the same chunk is repeated more times",
+			oldData: strings.Repeat(block, 3),
+			newData: strings.Repeat(block, 5),
+			ops: []fdiff.Op{
+				{Type: fdiff.OpCopy, SourceOffset: 0, Length: 30},
+				{Type: fdiff.OpCopy, SourceOffset: 0, Length: 20},
+			},
+		},
+		{
+			name:    "the same chunk is repeated less times",
+			oldData: strings.Repeat(block, 5),
+			newData: strings.Repeat(block, 2),
+			ops: []fdiff.Op{
+				{Type: fdiff.OpCopy, SourceOffset: 0, Length: 20},
+			},
+			oldChunks: []uint64{20, 30, 40},
+		},
+		{
+			name:    "a repeated chunk is removed from the beginning",
+			oldData: block + other + block,
+			newData: other + block,
+			ops: []fdiff.Op{
+				{Type: fdiff.OpCopy, SourceOffset: 10, Length: 20},
+			},
+			oldChunks: []uint64{0},
+		},
+		{
+			name:    "a sequence of chunks is repeated",
+			oldData: block + other,
+			newData: block + other + block + other + block,
+			ops: []fdiff.Op{
+				{Type: fdiff.OpCopy, SourceOffset: 0, Length: 20},
+				{Type: fdiff.OpCopy, SourceOffset: 0, Length: 20},
+				{Type: fdiff.OpCopy, SourceOffset: 0, Length: 10},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			writeDataToFile(filepath.Join(dir, "old"), []byte(c.oldData))
+			writeDataToFile(filepath.Join(dir, "new"), []byte(c.newData))
+			signFile(filepath.Join(dir, "old"), filepath.Join(dir, "sign"), 10)
+
+			d := make(chan byte, 100)
+			ch := make(chan fdiff.Chunk, 100)
+			fs := fdiff.NewFileSignerDeltaWithStrongHash(d, ch, fdiff.StrongHashSHA1)
+			fakeChunker{data: d, chunks: ch, windowsSize: 10}.Start("")
+
+			// Action
+			actual, err := fs.FindDelta(filepath.Join(dir, "sign"), filepath.Join(dir, "new"))
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Empty(t, actual.NewChunks)
+			assert.Equal(t, c.ops, actual.Ops)
+			var oldChunks []uint64
+			for _, old := range actual.OldChunks {
+				oldChunks = append(oldChunks, old.Offset)
+			}
+			assert.Equal(t, c.oldChunks, oldChunks)
+		})
+	}
+}
+
+func TestFindDelta_WhenStrongHashIsDifferent(t *testing.T) {
+	cases := map[string]string{
+		"signature with strong hash":    "#file-size: 10\n#strong-hash: sha1\n0-10-87acec17cd9dcd20a716cc2cf67417b71c8a7016\n",
+		"signature without strong hash": "#file-size: 10\n0-10-87acec17cd9dcd20a716cc2cf67417b71c8a7016\n",
+	}
+
+	for name, signature := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			writeDataToFile(filepath.Join(dir, "sign"), []byte(signature))
+			writeDataToFile(filepath.Join(dir, "new"), []byte("0123456789"))
+			d := make(chan byte, 100)
+			ch := make(chan fdiff.Chunk, 100)
+			fs := fdiff.NewFileSignerDeltaWithStrongHash(d, ch, fdiff.StrongHashSHA256)
+
+			// Action
+			_, err := fs.FindDelta(filepath.Join(dir, "sign"), filepath.Join(dir, "new"))
+
+			// Assert
+			assert.ErrorContains(t, err, "strong hash sha1")
+		})
+	}
+}
+
+func TestFindDelta_WhenNewFileCannotBeRead(t *testing.T) {
+	d := make(chan byte, 100)
+	ch := make(chan fdiff.Chunk, 100)
+	newChunker(t, rollinghash.NewRabinFingerprint, testChunkConfig, d, ch).Start()
+	cases := map[string]fdiff.SignerDelta{
+		"sequential": fdiff.NewFileSignerDelta(d, ch),
+		"parallel":   fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, testChunkConfig, 4),
+	}
+
+	for name, fs := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			dir := t.TempDir()
+			writeDataToFile(filepath.Join(dir, "old"), []byte("0123456789"))
+			assert.Nil(t, fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, testChunkConfig, 1).Sign(filepath.Join(dir, "old"), filepath.Join(dir, "sign")))
+
+			// Action
+			// a directory can be opened, but it can't be read
+			_, err := fs.FindDelta(filepath.Join(dir, "sign"), dir)
+
+			// Assert
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestFindDelta_WithTruncatedDigests(t *testing.T) {
+	// SetUp
+	cfg := testChunkConfig
+	cfg.DigestLength = 4
+	oldData, newData := textData(3000, 1), textData(3000, 1)
+	newData = append(newData[:40000:40000], append([]byte("a new line of the log\n"), newData[40000:]...)...)
+	full := findDeltaWithChunker(t, oldData, newData, testChunkConfig)
+
+	// Action
+	actual := findDeltaWithChunker(t, oldData, newData, cfg)
+
+	// Assert
+	assert.Equal(t, full.Ops, actual.Ops)
+	assert.Equal(t, full.Checksum, actual.Checksum)
+	assert.Nil(t, fdiff.VerifyDelta(actual, bytes.NewReader(oldData)))
+	for _, e := range actual.Edits {
+		assert.Len(t, e.Signature.Digest(), 4)
+	}
+}
+
+func TestSign_WithTruncatedDigests(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	cfg := testChunkConfig
+	cfg.DigestLength = 6
+	writeFile(t, filepath.Join(dir, "file"), textData(3000, 1))
+
+	// Action
+	signWithChunker(t, filepath.Join(dir, "file"), filepath.Join(dir, "sign"), cfg)
+
+	// Assert
+	header, chunks, err := fdiff.DecodeSignature(bytes.NewReader(readBytes(t, filepath.Join(dir, "sign"))))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, header.DigestLength)
+	assert.Contains(t, readFile(filepath.Join(dir, "sign")), "#digest-length: 6\n")
+	assert.Equal(t, fdiff.NewMerkleTree(chunks).Root(), header.MerkleRoot)
+	for _, ch := range chunks {
+		assert.Len(t, ch.Signature.String(), 12)
 	}
+}
+
+func TestSign_WithDefaultStrongHash(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "file"), textData(3000, 1))
+
+	// Action
+	signWithChunker(t, filepath.Join(dir, "file"), filepath.Join(dir, "sign"), testChunkConfig)
+
+	// Assert
+	header, chunks, err := fdiff.DecodeSignature(bytes.NewReader(readBytes(t, filepath.Join(dir, "sign"))))
 	assert.Nil(t, err)
+	assert.Contains(t, readFile(filepath.Join(dir, "sign")), "#strong-hash: sha256\n")
+	assert.Equal(t, fdiff.StrongHashSHA256, header.StrongHashName())
+	for _, ch := range chunks {
+		assert.Equal(t, fdiff.StrongHashSHA256, ch.Signature.StrongHash())
+	}
+}
+
+func TestVerifyDelta_WhenTruncatedDigestsCollide(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	first, second := sha1.Sum([]byte("0123456789")), sha1.Sum([]byte("ABCDEFGHIJ"))
+	// the second old chunk "abcdefghij" has the same truncated digest as the second new chunk
+	signature := fmt.Sprintf("#file-size: 20\n#strong-hash: sha1\n#digest-length: 2\n0-10-%x\n10-10-%x\n", first[:2], second[:2])
+	writeDataToFile(filepath.Join(dir, "sign"), []byte(signature))
+	writeDataToFile(filepath.Join(dir, "new"), []byte("0123456789ABCDEFGHIJ"))
+	d := make(chan byte, 100)
+	ch := make(chan fdiff.Chunk, 100)
+	fs := fdiff.NewFileSignerDeltaWithStrongHash(d, ch, fdiff.StrongHashSHA1)
+	fakeChunker{data: d, chunks: ch, windowsSize: 10}.Start("")
+
+	// Action
+	delta, err := fs.FindDelta(filepath.Join(dir, "sign"), filepath.Join(dir, "new"))
+	verifyErr := fdiff.VerifyDelta(delta, strings.NewReader("0123456789abcdefghij"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, []fdiff.Op{{Type: fdiff.OpCopy, SourceOffset: 0, Length: 20}}, delta.Ops)
+	assert.ErrorIs(t, verifyErr, fdiff.ErrChecksumMismatch)
+	assert.Nil(t, fdiff.VerifyDelta(delta, strings.NewReader("0123456789ABCDEFGHIJ")))
+}
+
+func TestFindDelta_WhenSignatureExceedsMemoryBudget(t *testing.T) {
+	text := textData(3000, 1)
+	inserted := append(append(append([]byte{}, text[:40000]...), "a new line of the log\n"...), text[40000:]...)
+	repeated := append(append(append([]byte{}, text[:30000]...), text[10000:30000]...), text[50000:]...)
+	cases := map[string]struct {
+		oldData []byte
+		newData []byte
+	}{
+		"data is inserted":   {oldData: text, newData: inserted},
+		"data is repeated":   {oldData: text, newData: repeated},
+		"data is removed":    {oldData: repeated, newData: text[:30000]},
+		"files are not same": {oldData: text, newData: textData(3000, 2)},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			// small chunks, so the index has several sorted runs and several blocks
+			cfg := testChunkConfig
+			cfg.MinSizeChunk = 32
+			cfg.Discriminator = 64
+			expected := findDeltaWithChunker(t, c.oldData, c.newData, cfg)
+			cfg.MemoryBudget = 1000
+
+			// Action
+			actual := findDeltaWithChunker(t, c.oldData, c.newData, cfg)
+
+			// Assert
+			assert.Greater(t, len(expected.Edits), 0)
+			assert.Nil(t, actual.Edits)
+			expected.Edits = nil
+			assert.Equal(t, expected, actual)
+			assert.Nil(t, fdiff.VerifyDelta(actual, bytes.NewReader(c.oldData)))
+		})
+	}
+}
+
+func TestFindDelta_WhenSignatureWithRepeatedChunksExceedsMemoryBudget(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	block := randomData(256, 1)
+	oldData := bytes.Repeat(block, 20000)
+	newData := append(append(append([]byte{}, oldData[:256*5000]...), randomData(256, 2)...), bytes.Repeat(block, 15100)...)
+	writeFile(t, filepath.Join(dir, "old"), oldData)
+	writeFile(t, filepath.Join(dir, "new"), newData)
+	cfg := fdiff.ChunkConfig{BlockSize: 256}
+	assert.Nil(t, fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, cfg, 1).Sign(filepath.Join(dir, "old"), filepath.Join(dir, "sign")))
+	expected, err := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, cfg, 1).FindDelta(filepath.Join(dir, "sign"), filepath.Join(dir, "new"))
+	assert.Nil(t, err)
+	cfg.MemoryBudget = 1000
+
+	// Action
+	actual, err := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, cfg, 1).FindDelta(filepath.Join(dir, "sign"), filepath.Join(dir, "new"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, actual.Edits)
+	expected.Edits = nil
 	assert.Equal(t, expected, actual)
+	assert.Nil(t, fdiff.VerifyDelta(actual, bytes.NewReader(oldData)))
 }
 
 func signFile(file, filesSign string, windowsSize int) {
 	d := make(chan byte, 100)
 	ch := make(chan fdiff.Chunk, 100)
-	fs := fdiff.NewFileSignerDelta(d, ch)
+	fs := fdiff.NewFileSignerDeltaWithStrongHash(d, ch, fdiff.StrongHashSHA1)
 	defer os.Remove("./test/expected_sign_test_data")
 	fch := fakeChunker{data: d, chunks: ch, windowsSize: windowsSize}
 	fch.Start("./test/expected_sign_test_data")
@@ -119,14 +459,19 @@ func writeDataToFile(file string, data []byte) {
 	fmt.Println("Number of bytes: ", n)
 }
 
-func equalFileContent(expected, actual string) bool {
-	expectedData, _ := os.ReadFile(expected)
-	actualData, _ := os.ReadFile(actual)
+func readFile(file string) string {
+	data, _ := os.ReadFile(file)
+	return string(data)
+}
 
-	return sha1.Sum(expectedData) == sha1.Sum(actualData)
+// signatureHeader return the header that Sign writes in the signature file of the file.
+func signatureHeader(file, chunks string) string {
+	data, _ := os.ReadFile(file)
+	_, c, _ := fdiff.DecodeSignature(strings.NewReader(chunks))
+	return fmt.Sprintf("#file-size: %d\n#file-checksum: %x\n#strong-hash: sha1\n#merkle-root: %s\n", len(data), sha1.Sum(data), fdiff.NewMerkleTree(c).Root())
 }
 
-// fakeChunker create a chunks and send it back to the signer
+// fakeChunker create a chunks signed with SHA-1 and send it back to the signer
 type fakeChunker struct {
 	chunks      chan<- fdiff.Chunk
 	data        <-chan byte
@@ -151,7 +496,7 @@ func (fch fakeChunker) Start(file string) {
 						Offset:    offset,
 						Data:      bytesOfTheChunk,
 						Length:    uint64(len(bytesOfTheChunk)),
-						Signature: h,
+						Signature: parseSHA1(h),
 					}
 					fmt.Printf("signature is %s, data is: %v: \n", h, bytesOfTheChunk)
 					fch.chunks <- chunk
@@ -173,7 +518,7 @@ func (fch fakeChunker) Start(file string) {
 				Offset:    offset,
 				Data:      bytesOfTheChunk,
 				Length:    uint64(len(bytesOfTheChunk)),
-				Signature: h,
+				Signature: parseSHA1(h),
 			}
 			fmt.Printf("signature is %s, data is: %v: \n", h, bytesOfTheChunk)
 			fch.chunks <- chunk
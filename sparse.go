@@ -0,0 +1,139 @@
+package fdiff
+
+import (
+	"io"
+	"os"
+)
+
+// extent is a part of a file that contains data or a hole. The holes of sparse files are
+// zeros that are not stored on the disk.
+type extent struct {
+	offset int64
+	length int64
+	hole   bool
+}
+
+// extentsOf return the data and the holes of the first 'size' bytes of 'r'. Only the holes of
+// the files (*os.File and the mapped files) are found, the other readers contain only data.
+func extentsOf(r io.ReaderAt, size int64) ([]extent, error) {
+	var extents []extent
+	switch f := r.(type) {
+	case *mappedFile:
+		extents = f.extents
+	case *os.File:
+		var err error
+		if extents, err = fileExtents(f, size); err != nil {
+			return nil, err
+		}
+	default:
+		extents = []extent{{length: size}}
+	}
+
+	// the extents after 'size' are removed
+	var clipped []extent
+	for _, e := range extents {
+		if e.offset >= size {
+			break
+		}
+		if e.offset+e.length > size {
+			e.length = size - e.offset
+		}
+		clipped = append(clipped, e)
+	}
+	return clipped, nil
+}
+
+// holeChunk return the chunk of the hole. It has no data.
+func holeChunk(e extent) Chunk {
+	return Chunk{Offset: uint64(e.offset), Length: uint64(e.length), Signature: holeSignature(uint64(e.length))}
+}
+
+// zeros is a buffer of zeros that is written instead of the holes.
+var zeros = make([]byte, 32<<10)
+
+// writeZeros write n zeros to 'w'.
+func writeZeros(w io.Writer, n uint64) error {
+	for n > 0 {
+		b := zeros
+		if n < uint64(len(b)) {
+			b = b[:n]
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= uint64(len(b))
+	}
+	return nil
+}
+
+// sparseWriter is a writer that can skip bytes, like *os.File. The skipped bytes of a new file
+// are holes.
+type sparseWriter interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// holeWriter write the data to a writer and recreate the holes of the data by skipping bytes when
+// the writer is a sparseWriter. Otherwise, zeros are written instead of the holes. All bytes,
+// including the zeros of the holes, are written to the checksum too, if it is not nil.
+type holeWriter struct {
+	w        io.Writer
+	sparse   sparseWriter
+	checksum io.Writer
+
+	// hole is true when the last written bytes are a hole. The size of the file is set
+	// at the end, because skipping bytes doesn't change it.
+	hole bool
+}
+
+// newHoleWriter initialize and return *holeWriter. The holes are skipped only when 'w' is
+// a new file or a file without data after its current offset.
+func newHoleWriter(w io.Writer, checksum io.Writer) *holeWriter {
+	hw := &holeWriter{w: w, checksum: checksum}
+	hw.sparse, _ = w.(sparseWriter)
+	return hw
+}
+
+// Write write the data.
+func (hw *holeWriter) Write(p []byte) (int, error) {
+	hw.hole = false
+	n, err := hw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if hw.checksum != nil {
+		_, _ = hw.checksum.Write(p)
+	}
+	return n, nil
+}
+
+// writeHole write a hole with n bytes.
+func (hw *holeWriter) writeHole(n uint64) error {
+	if n == 0 {
+		return nil
+	}
+	if hw.sparse == nil {
+		return writeZeros(hw, n)
+	}
+	if _, err := hw.sparse.Seek(int64(n), io.SeekCurrent); err != nil {
+		return err
+	}
+	hw.hole = true
+	if hw.checksum != nil {
+		return writeZeros(hw.checksum, n)
+	}
+	return nil
+}
+
+// close set the size of the file when it ends with a hole.
+func (hw *holeWriter) close() error {
+	if !hw.hole {
+		return nil
+	}
+	size, err := hw.sparse.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return hw.sparse.Truncate(size)
+}
@@ -0,0 +1,56 @@
+package fdiff
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// whence of lseek that find the next data and the next hole of a sparse file.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// fileExtents find the data and the holes of the first 'size' bytes of the file with
+// lseek(SEEK_DATA) and lseek(SEEK_HOLE). When the file system doesn't support them the
+// file contains only data. The offset of the file is changed.
+func fileExtents(f *os.File, size int64) ([]extent, error) {
+	var extents []extent
+	for offset := int64(0); offset < size; {
+		data, err := f.Seek(offset, seekData)
+		switch {
+		case errors.Is(err, syscall.ENXIO):
+			// there is no data after the offset
+			data = size
+		case errors.Is(err, syscall.EINVAL):
+			return []extent{{length: size}}, nil
+		case err != nil:
+			return nil, err
+		}
+		if data > size {
+			data = size
+		}
+		if data > offset {
+			extents = append(extents, extent{offset: offset, length: data - offset, hole: true})
+		}
+		if data == size {
+			break
+		}
+
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+		extents = append(extents, extent{offset: data, length: hole - data})
+		offset = hole
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return extents, nil
+}
@@ -0,0 +1,176 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelChunker_WithSparseFile(t *testing.T) {
+	// SetUp
+	file := filepath.Join(t.TempDir(), "sparse")
+	first, second := randomData(100<<10, 1), randomData(48<<10, 2)
+	writeSparseFile(t, file, 4<<20, map[int64][]byte{1 << 20: first, 3 << 20: second})
+	var expected []fdiff.Chunk
+	for offset, d := range map[uint64][]byte{1 << 20: first, 3 << 20: second} {
+		chunks, err := fdiff.ChunkReader(rollinghash.NewRabinFingerprint, testChunkConfig, bytes.NewReader(d))
+		assert.Nil(t, err)
+		for _, c := range chunks {
+			c.Offset += offset
+			expected = append(expected, c)
+		}
+	}
+	f, err := os.Open(file)
+	assert.Nil(t, err)
+	defer f.Close()
+	pc := newParallelChunker(t, rollinghash.NewRabinFingerprint, testChunkConfig, 4)
+	ch := make(chan fdiff.Chunk, 10)
+
+	// Action
+	errc := make(chan error, 1)
+	go func() {
+		errc <- pc.Chunks(f, 4<<20, ch)
+	}()
+
+	// Assert
+	var holes [][2]uint64
+	var data []fdiff.Chunk
+	for c := range ch {
+		if c.Signature.IsHole() {
+			assert.Nil(t, c.Data)
+			holes = append(holes, [2]uint64{c.Offset, c.Length})
+			continue
+		}
+		data = append(data, c)
+	}
+	assert.Nil(t, <-errc)
+	assert.Equal(t, [][2]uint64{{0, 1 << 20}, {1<<20 + 100<<10, 2<<20 - 100<<10}, {3<<20 + 48<<10, 1<<20 - 48<<10}}, holes)
+	assert.ElementsMatch(t, expected, data)
+}
+
+func TestParallelFileSignerDelta_WithSparseFiles(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	oldData, newData := textData(1000, 1), textData(1000, 1)
+	newData = append(newData[:20000:20000], append([]byte("a new line of the log\n"), newData[20000:]...)...)
+	writeSparseFile(t, filepath.Join(dir, "old"), 8<<20, map[int64][]byte{2 << 20: oldData})
+	writeSparseFile(t, filepath.Join(dir, "new"), 16<<20, map[int64][]byte{2 << 20: newData, 12 << 20: oldData})
+	fs := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, testChunkConfig, 4)
+
+	// Action
+	signErr := fs.Sign(filepath.Join(dir, "old"), filepath.Join(dir, "old.sig"))
+	d, deltaErr := fs.FindDelta(filepath.Join(dir, "old.sig"), filepath.Join(dir, "new"))
+	var encoded bytes.Buffer
+	encodeErr := fdiff.NewPatch(d, fdiff.CodecDeflate).Encode(&encoded)
+	p, decodeErr := fdiff.DecodePatch(&encoded)
+	out, err := os.Create(filepath.Join(dir, "out"))
+	assert.Nil(t, err)
+	applyErr := p.Apply(bytes.NewReader(readBytes(t, filepath.Join(dir, "old"))), out)
+	closeErr := out.Close()
+
+	// Assert
+	assert.Nil(t, signErr)
+	assert.Nil(t, deltaErr)
+	assert.Nil(t, encodeErr)
+	assert.Nil(t, decodeErr)
+	assert.Nil(t, applyErr)
+	assert.Nil(t, closeErr)
+	assert.Equal(t, 2, strings.Count(readFile(filepath.Join(dir, "old.sig")), "-hole\n"))
+	zeros := 0
+	for _, op := range d.Ops {
+		if op.Type == fdiff.OpZero {
+			zeros++
+		}
+	}
+	assert.Equal(t, 3, zeros)
+	assert.Less(t, encoded.Len(), 2000)
+	assert.Equal(t, readBytes(t, filepath.Join(dir, "new")), readBytes(t, filepath.Join(dir, "out")))
+	assert.Less(t, allocatedBytes(t, filepath.Join(dir, "out")), int64(1<<20))
+}
+
+func TestParallelFileSignerDelta_WithBlockSizeAndSparseFiles(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	cfg := fdiff.ChunkConfig{BlockSize: 4096}
+	data := randomData(10000, 1)
+	writeSparseFile(t, filepath.Join(dir, "old"), 1<<20, map[int64][]byte{64 << 10: data})
+	writeSparseFile(t, filepath.Join(dir, "new"), 1<<20, map[int64][]byte{64 << 10: data, 512 << 10: []byte("a new block")})
+	fs := fdiff.NewParallelFileSignerDelta(rollinghash.NewRabinFingerprint, cfg, 4)
+
+	// Action
+	signErr := fs.Sign(filepath.Join(dir, "old"), filepath.Join(dir, "old.sig"))
+	d, deltaErr := fs.FindDelta(filepath.Join(dir, "old.sig"), filepath.Join(dir, "new"))
+	_, chunks, decodeErr := fdiff.DecodeSignature(strings.NewReader(readFile(filepath.Join(dir, "old.sig"))))
+
+	// Assert
+	assert.Nil(t, signErr)
+	assert.Nil(t, deltaErr)
+	assert.Nil(t, decodeErr)
+	assert.Contains(t, readFile(filepath.Join(dir, "old.sig")), "\n0-16-hole\n")
+	assert.Contains(t, readFile(filepath.Join(dir, "old.sig")), "\n19-237-hole\n")
+	assert.Equal(t, 5, len(chunks))
+	assert.Equal(t, fdiff.Chunk{Offset: 76 << 10, Length: 1<<20 - 76<<10}, fdiff.Chunk{Offset: chunks[4].Offset, Length: chunks[4].Length})
+	assert.True(t, chunks[4].Signature.IsHole())
+	assert.Equal(t, []uint64{128}, d.ChangedBlocks)
+	assert.Equal(t, readBytes(t, filepath.Join(dir, "new")), applyDelta(t, d, readBytes(t, filepath.Join(dir, "old"))))
+}
+
+func TestPatch_ApplyInPlace_WithSparseFile(t *testing.T) {
+	// SetUp
+	dir := t.TempDir()
+	data := randomData(100<<10, 1)
+	writeSparseFile(t, filepath.Join(dir, "file"), 64<<20, map[int64][]byte{1 << 20: data})
+	p := fdiff.Patch{Ops: []fdiff.Op{
+		{Type: fdiff.OpZero, Length: 1 << 20},
+		{Type: fdiff.OpData, Length: 5, Data: []byte("12345")},
+		{Type: fdiff.OpCopy, SourceOffset: 1 << 20, Length: 100 << 10},
+		{Type: fdiff.OpZero, Length: 80 << 20},
+	}}
+	var expected bytes.Buffer
+	assert.Nil(t, p.Apply(bytes.NewReader(readBytes(t, filepath.Join(dir, "file"))), &expected))
+
+	// Action
+	err := p.ApplyInPlace(filepath.Join(dir, "file"), filepath.Join(dir, "journal"))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, expected.Bytes(), readBytes(t, filepath.Join(dir, "file")))
+	assert.Less(t, allocatedBytes(t, filepath.Join(dir, "file")), int64(1<<20))
+}
+
+// writeSparseFile create a file with the size that contains the data at their offsets and holes between them.
+// The test is skipped when the file system doesn't create holes.
+func writeSparseFile(t *testing.T, file string, size int64, data map[int64][]byte) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	for offset, d := range data {
+		if _, err = f.WriteAt(d, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allocatedBytes(t, file) >= size {
+		t.Skip("the file system doesn't support sparse files")
+	}
+}
+
+// allocatedBytes return the number of bytes that are stored on the disk for the file.
+func allocatedBytes(t *testing.T, file string) int64 {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(file, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat.Blocks * 512
+}
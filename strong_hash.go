@@ -0,0 +1,136 @@
+package fdiff
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// StrongHashSHA1 is the name of SHA-1. It was the default strong hash, so the
+	// signature files without the name of the strong hash are created with it.
+	StrongHashSHA1 = "sha1"
+
+	// StrongHashSHA256 is the name of SHA-256.
+	StrongHashSHA256 = "sha256"
+
+	// StrongHashSHA512_256 is the name of SHA-512/256.
+	StrongHashSHA512_256 = "sha512-256"
+
+	// StrongHashBLAKE2b256 is the name of BLAKE2b with 256 bits digest.
+	StrongHashBLAKE2b256 = "blake2b-256"
+
+	// DefaultStrongHash is the strong hash of the new signatures when the configuration
+	// doesn't set one. SHA-1 is not used, because its collisions can be created.
+	DefaultStrongHash = StrongHashSHA256
+)
+
+// tags of the strong hashes in the binary format of the signatures. See Signature.MarshalBinary.
+const (
+	tagSHA1       = 1
+	tagSHA256     = 2
+	tagSHA512_256 = 3
+	tagBLAKE2b256 = 4
+)
+
+// strongHash is a registered strong hash.
+type strongHash struct {
+	tag  byte
+	size int
+	new  func() hash.Hash
+}
+
+var (
+	strongHashesMu sync.RWMutex
+	strongHashes   = map[string]strongHash{}
+	strongHashTags = map[byte]string{}
+)
+
+func init() {
+	RegisterStrongHash(StrongHashSHA1, tagSHA1, sha1.New)
+	RegisterStrongHash(StrongHashSHA256, tagSHA256, sha256.New)
+	RegisterStrongHash(StrongHashSHA512_256, tagSHA512_256, sha512.New512_256)
+	RegisterStrongHash(StrongHashBLAKE2b256, tagBLAKE2b256, func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	})
+}
+
+// RegisterStrongHash make the strong hash available for the signatures of the chunks. The name
+// is stored in the signature files and the tag is stored in the binary format of the signatures,
+// so both must be unique. The tags from 1 to 4 are used by the predefined hashes. If a hash with
+// the same name or tag is already registered it is replaced. It panics if the tag is 0 or 255 (the
+// tag of the holes of sparse files) or the digest of the hash is longer than MaxSignatureSize.
+func RegisterStrongHash(name string, tag byte, new func() hash.Hash) {
+	size := new().Size()
+	if tag == 0 || tag == tagHole || size > MaxSignatureSize {
+		panic(fmt.Sprintf("invalid strong hash %q: tag %d, size %d", name, tag, size))
+	}
+
+	strongHashesMu.Lock()
+	defer strongHashesMu.Unlock()
+	if old, ok := strongHashes[name]; ok {
+		delete(strongHashTags, old.tag)
+	}
+	if old, ok := strongHashTags[tag]; ok {
+		delete(strongHashes, old)
+	}
+	strongHashes[name] = strongHash{tag: tag, size: size, new: new}
+	strongHashTags[tag] = name
+}
+
+// GetStrongHash return the function that creates the registered strong hash with the name.
+// The empty name is DefaultStrongHash.
+func GetStrongHash(name string) (func() hash.Hash, error) {
+	h, err := getStrongHash(name)
+	return h.new, err
+}
+
+func getStrongHash(name string) (strongHash, error) {
+	strongHashesMu.RLock()
+	defer strongHashesMu.RUnlock()
+	h, ok := strongHashes[strongHashName(name)]
+	if !ok {
+		return strongHash{}, fmt.Errorf("unknown strong hash %q", name)
+	}
+	return h, nil
+}
+
+// strongHashTag return the tag and the size of the digest of the registered strong hash with the name.
+func strongHashTag(name string) (byte, int, error) {
+	h, err := getStrongHash(name)
+	return h.tag, h.size, err
+}
+
+// strongHashByTag return the name of the registered strong hash with the tag or empty string.
+func strongHashByTag(tag byte) string {
+	strongHashesMu.RLock()
+	defer strongHashesMu.RUnlock()
+	return strongHashTags[tag]
+}
+
+// StrongHashNames return the names of all registered strong hashes sorted alphabetically.
+func StrongHashNames() []string {
+	strongHashesMu.RLock()
+	defer strongHashesMu.RUnlock()
+	var names []string
+	for name := range strongHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// strongHashName return the name of the strong hash. The empty name is DefaultStrongHash.
+func strongHashName(name string) string {
+	if name == "" {
+		return DefaultStrongHash
+	}
+	return name
+}
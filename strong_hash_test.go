@@ -0,0 +1,98 @@
+package fdiff_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/rollinghash"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestGetStrongHash(t *testing.T) {
+	data := []byte("The Low Bandwidth Network File system")
+	sha1Sum := sha1.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	sha512Sum := sha512.Sum512_256(data)
+	blake2bSum := blake2b.Sum256(data)
+	cases := map[string][]byte{
+		"":                         sha256Sum[:],
+		fdiff.StrongHashSHA1:       sha1Sum[:],
+		fdiff.StrongHashSHA256:     sha256Sum[:],
+		fdiff.StrongHashSHA512_256: sha512Sum[:],
+		fdiff.StrongHashBLAKE2b256: blake2bSum[:],
+	}
+
+	for name, expected := range cases {
+		t.Run(name, func(t *testing.T) {
+			// Action
+			new, err := fdiff.GetStrongHash(name)
+
+			// Assert
+			assert.Nil(t, err)
+			h := new()
+			h.Write(data)
+			assert.Equal(t, expected, h.Sum(nil))
+		})
+	}
+}
+
+func TestGetStrongHash_WhenHashIsUnknown(t *testing.T) {
+	// Action
+	_, err := fdiff.GetStrongHash("md4")
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func TestRegisterStrongHash(t *testing.T) {
+	// Action
+	fdiff.RegisterStrongHash("md5", 200, md5.New)
+
+	// Assert
+	assert.Contains(t, fdiff.StrongHashNames(), "md5")
+	new, err := fdiff.GetStrongHash("md5")
+	assert.Nil(t, err)
+	assert.Equal(t, md5.Size, new().Size())
+}
+
+func TestChunker_WithStrongHash(t *testing.T) {
+	// SetUp
+	cfg := testChunkConfig
+	cfg.StrongHash = fdiff.StrongHashSHA256
+	data := randomData(100000, 8)
+
+	// Action
+	chunks, err := fdiff.ChunkReader(rollinghash.NewRabinFingerprint, cfg, bytes.NewReader(data))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.NotEmpty(t, chunks)
+	for _, ch := range chunks {
+		sum := sha256.Sum256(ch.Data)
+		assert.Equal(t, hex.EncodeToString(sum[:]), ch.Signature.String())
+		assert.Equal(t, fdiff.StrongHashSHA256, ch.Signature.StrongHash())
+	}
+}
+
+func TestNewChunker_WhenStrongHashIsUnknown(t *testing.T) {
+	// SetUp
+	cfg := testChunkConfig
+	cfg.StrongHash = "unknown"
+
+	// Action
+	c, err := fdiff.NewChunker(rollinghash.NewRabinFingerprint, cfg, make(chan byte), make(chan fdiff.Chunk))
+	pc, parallelErr := fdiff.NewParallelChunker(rollinghash.NewRabinFingerprint, cfg, 4)
+
+	// Assert
+	assert.Nil(t, c)
+	assert.ErrorContains(t, err, "unknown strong hash")
+	assert.Nil(t, pc)
+	assert.ErrorContains(t, parallelErr, "unknown strong hash")
+}
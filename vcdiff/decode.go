@@ -0,0 +1,253 @@
+package vcdiff
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"math"
+
+	"github.com/EmilGeorgiev/fdiff"
+)
+
+// maxDecodedWindowSize is the maximum size of a target window that is decoded. It is
+// the hard limit of xdelta3, so larger sizes are only found in malformed streams.
+const maxDecodedWindowSize = 1 << 26
+
+// Decode read a VCDIFF stream and return the operations that create the target data
+// from the source data. COPY from the source segment is returned as OpCopy. ADD, RUN
+// and COPY from the target window are returned as OpData. The source is needed to
+// create the target window, because COPY may refer to bytes of the target window that
+// are copied from the source.
+func Decode(r io.Reader, source io.ReaderAt) ([]fdiff.Op, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, Magic) {
+		return nil, errors.New("the data is not a VCDIFF stream")
+	}
+
+	indicator, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if indicator&vcdDecompress != 0 {
+		return nil, errors.New("secondary compression is not supported")
+	}
+	if indicator&vcdCodeTable != 0 {
+		return nil, errors.New("custom code tables are not supported")
+	}
+	if indicator&vcdAppHeader != 0 {
+		n, err := readInt(br)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = br.Discard(int(n)); err != nil {
+			return nil, err
+		}
+	}
+
+	var ops []fdiff.Op
+	for {
+		if _, err = br.Peek(1); err == io.EOF {
+			return ops, nil
+		}
+		if ops, err = decodeWindow(br, source, ops); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// window contains the sections of a window and the state of its decoding.
+type window struct {
+	segmentStart  uint64
+	segmentLength uint64
+
+	data         *bytes.Reader
+	instructions *bufio.Reader
+	addresses    *bufio.Reader
+	cache        addressCache
+
+	// target contains the decoded bytes of the target window.
+	target []byte
+}
+
+// decodeWindow decode one window and append its operations to 'ops'.
+func decodeWindow(br *bufio.Reader, source io.ReaderAt, ops []fdiff.Op) ([]fdiff.Op, error) {
+	indicator, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if indicator&vcdTarget != 0 {
+		return nil, errors.New("VCD_TARGET windows are not supported")
+	}
+
+	var w window
+	if indicator&vcdSource != 0 {
+		if w.segmentLength, err = readInt(br); err != nil {
+			return nil, err
+		}
+		if w.segmentStart, err = readInt(br); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields [5]uint64 // length of the encoding, target size, data, instructions and addresses
+	for i := range fields {
+		if fields[i], err = readInt(br); err != nil {
+			return nil, err
+		}
+		if i == 1 {
+			// Delta_Indicator
+			if deltaIndicator, err := br.ReadByte(); err != nil {
+				return nil, err
+			} else if deltaIndicator != 0 {
+				return nil, errors.New("compressed sections are not supported")
+			}
+		}
+	}
+	targetSize := fields[1]
+	if targetSize > maxDecodedWindowSize {
+		return nil, fmt.Errorf("the size of the window %d is bigger than %d", targetSize, maxDecodedWindowSize)
+	}
+
+	var checksum []byte
+	if indicator&vcdAdler32 != 0 {
+		checksum = make([]byte, 4)
+		if _, err = io.ReadFull(br, checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	sections := make([][]byte, 3)
+	for i := range sections {
+		if sections[i], err = readSection(br, fields[2+i]); err != nil {
+			return nil, err
+		}
+	}
+	w.data = bytes.NewReader(sections[0])
+	w.instructions = bufio.NewReader(bytes.NewReader(sections[1]))
+	w.addresses = bufio.NewReader(bytes.NewReader(sections[2]))
+	w.target = make([]byte, 0, targetSize)
+
+	for {
+		code, err := w.instructions.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, inst := range defaultCodeTable[code] {
+			if inst.typ == noop {
+				continue
+			}
+			if ops, err = w.execute(inst, source, ops); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if uint64(len(w.target)) != targetSize {
+		return nil, fmt.Errorf("the window creates %d bytes, expected %d", len(w.target), targetSize)
+	}
+	if checksum != nil && adler32.Checksum(w.target) != uint32(checksum[0])<<24|uint32(checksum[1])<<16|uint32(checksum[2])<<8|uint32(checksum[3]) {
+		return nil, errors.New("the checksum of the window does not match")
+	}
+	return ops, nil
+}
+
+// readSection read a section of a window with the length 'n'. The length is read from the
+// stream, so the section grows with the data that is really read instead of being allocated
+// before it.
+func readSection(r io.Reader, n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("the length of the section %d is too big", n)
+	}
+	var buf bytes.Buffer
+	if m, err := buf.ReadFrom(io.LimitReader(r, int64(n))); err != nil {
+		return nil, err
+	} else if uint64(m) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+// execute decode the instruction, append its bytes to the target window and its operation to 'ops'.
+func (w *window) execute(inst instruction, source io.ReaderAt, ops []fdiff.Op) ([]fdiff.Op, error) {
+	size := uint64(inst.size)
+	if size == 0 {
+		var err error
+		if size, err = readInt(w.instructions); err != nil {
+			return nil, err
+		}
+	}
+	if size > uint64(cap(w.target)-len(w.target)) {
+		return nil, errors.New("the instructions create more bytes than the size of the window")
+	}
+	start := len(w.target)
+
+	switch inst.typ {
+	case add:
+		data := make([]byte, size)
+		if _, err := io.ReadFull(w.data, data); err != nil {
+			return nil, err
+		}
+		w.target = append(w.target, data...)
+	case run:
+		b, err := w.data.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < size; i++ {
+			w.target = append(w.target, b)
+		}
+	case cp:
+		here := w.segmentLength + uint64(len(w.target))
+		addr, err := w.cache.decode(w.addresses, inst.mode, here)
+		if err != nil {
+			return nil, err
+		}
+		w.cache.update(addr)
+
+		if addr < w.segmentLength {
+			if size > w.segmentLength-addr {
+				return nil, errors.New("COPY is outside the source segment")
+			}
+			data := make([]byte, size)
+			if n, err := source.ReadAt(data, int64(w.segmentStart+addr)); uint64(n) != size {
+				return nil, fmt.Errorf("read source from offset %d: %v", w.segmentStart+addr, err)
+			}
+			w.target = append(w.target, data...)
+			return appendOp(ops, fdiff.Op{Type: fdiff.OpCopy, SourceOffset: w.segmentStart + addr, Length: size}), nil
+		}
+
+		// the copy can overlap with the bytes that it creates, so it is done byte by byte
+		for i := addr - w.segmentLength; i < addr-w.segmentLength+size; i++ {
+			if i >= uint64(len(w.target)) {
+				return nil, errors.New("COPY is outside the target window")
+			}
+			w.target = append(w.target, w.target[i])
+		}
+	}
+	return appendOp(ops, fdiff.Op{Type: fdiff.OpData, Length: size, Data: w.target[start:len(w.target):len(w.target)]}), nil
+}
+
+// appendOp append the operation to 'ops'. If it continues the last operation, both are merged.
+func appendOp(ops []fdiff.Op, op fdiff.Op) []fdiff.Op {
+	if n := len(ops); n > 0 {
+		last := &ops[n-1]
+		if last.Type == fdiff.OpCopy && op.Type == fdiff.OpCopy && last.SourceOffset+last.Length == op.SourceOffset {
+			last.Length += op.Length
+			return ops
+		}
+		if last.Type == fdiff.OpData && op.Type == fdiff.OpData {
+			last.Data = append(last.Data[:last.Length:last.Length], op.Data...)
+			last.Length += op.Length
+			return ops
+		}
+	}
+	return append(ops, op)
+}
@@ -0,0 +1,207 @@
+package vcdiff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/EmilGeorgiev/fdiff"
+)
+
+const (
+	// maxWindowSize is the maximum number of target bytes in one window.
+	maxWindowSize = 1 << 20
+
+	// minRunLength is the minimum number of equal bytes of OpData that are encoded as RUN.
+	minRunLength = 8
+)
+
+// Encode write the operations as a VCDIFF stream to 'w'. OpCopy is encoded as
+// COPY from the source segment of the window and OpData as ADD, or as RUN when
+// the data contains a sequence of equal bytes. OpZero is encoded as RUN of zeros,
+// so the holes of sparse files are written as zeros when the delta is applied.
+func Encode(w io.Writer, ops []fdiff.Op) error {
+	bw := bufio.NewWriter(w)
+	_, _ = bw.Write(Magic)
+	_ = bw.WriteByte(0) // Hdr_Indicator
+
+	for len(ops) > 0 {
+		var window []fdiff.Op
+		window, ops = nextWindow(ops)
+		if err := encodeWindow(bw, window); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// nextWindow split the operations to the ones that create at most maxWindowSize
+// bytes and the rest. An operation may be split between two windows.
+func nextWindow(ops []fdiff.Op) ([]fdiff.Op, []fdiff.Op) {
+	var window []fdiff.Op
+	var size uint64
+	for len(ops) > 0 {
+		op := ops[0]
+		if size+op.Length <= maxWindowSize {
+			window = append(window, op)
+			size += op.Length
+			ops = ops[1:]
+			continue
+		}
+
+		n := maxWindowSize - size
+		first, rest := op, op
+		first.Length, rest.Length = n, op.Length-n
+		switch op.Type {
+		case fdiff.OpCopy:
+			rest.SourceOffset += n
+		case fdiff.OpData:
+			first.Data, rest.Data = op.Data[:n], op.Data[n:]
+		}
+		window = append(window, first)
+		ops = append([]fdiff.Op{rest}, ops[1:]...)
+		break
+	}
+	return window, ops
+}
+
+// encodeWindow write one window. The source segment of the window contains
+// all bytes of the source that are copied by the operations of the window.
+func encodeWindow(w *bufio.Writer, ops []fdiff.Op) error {
+	var segmentStart, segmentEnd, targetSize uint64
+	hasSource := false
+	for _, op := range ops {
+		targetSize += op.Length
+		if op.Type != fdiff.OpCopy || op.Length == 0 {
+			continue
+		}
+		if !hasSource || op.SourceOffset < segmentStart {
+			segmentStart = op.SourceOffset
+		}
+		if !hasSource || op.SourceOffset+op.Length > segmentEnd {
+			segmentEnd = op.SourceOffset + op.Length
+		}
+		hasSource = true
+	}
+
+	var data, instructions, addresses []byte
+	var cache addressCache
+	here := segmentEnd - segmentStart
+	for _, op := range ops {
+		switch op.Type {
+		case fdiff.OpCopy:
+			if op.Length == 0 {
+				continue
+			}
+			addr := op.SourceOffset - segmentStart
+			mode, value := cache.encode(addr, here)
+			instructions = appendInstruction(instructions, cp, op.Length, mode)
+			if mode >= 2+sNear {
+				addresses = append(addresses, byte(value))
+			} else {
+				addresses = appendInt(addresses, value)
+			}
+			cache.update(addr)
+		case fdiff.OpData:
+			for _, part := range splitRuns(op.Data) {
+				if len(part) >= minRunLength && isRun(part) {
+					instructions = appendInstruction(instructions, run, uint64(len(part)), 0)
+					data = append(data, part[0])
+					continue
+				}
+				instructions = appendInstruction(instructions, add, uint64(len(part)), 0)
+				data = append(data, part...)
+			}
+		case fdiff.OpZero:
+			if op.Length == 0 {
+				continue
+			}
+			instructions = appendInstruction(instructions, run, op.Length, 0)
+			data = append(data, 0)
+		default:
+			return fmt.Errorf("unknown type of operation %d", op.Type)
+		}
+		here += op.Length
+	}
+
+	var indicator byte
+	var header []byte
+	if hasSource {
+		indicator |= vcdSource
+		header = appendInt(header, segmentEnd-segmentStart)
+		header = appendInt(header, segmentStart)
+	}
+
+	var encoding []byte
+	encoding = appendInt(encoding, targetSize)
+	encoding = append(encoding, 0) // Delta_Indicator
+	encoding = appendInt(encoding, uint64(len(data)))
+	encoding = appendInt(encoding, uint64(len(instructions)))
+	encoding = appendInt(encoding, uint64(len(addresses)))
+	length := uint64(len(encoding) + len(data) + len(instructions) + len(addresses))
+
+	_ = w.WriteByte(indicator)
+	_, _ = w.Write(header)
+	_, _ = w.Write(appendInt(nil, length))
+	_, _ = w.Write(encoding)
+	_, _ = w.Write(data)
+	_, _ = w.Write(instructions)
+	_, err := w.Write(addresses)
+	return err
+}
+
+// appendInstruction append the code of a single instruction and its size, if the size
+// is not part of the code. Codes with two instructions are not used by the encoder.
+func appendInstruction(buf []byte, typ byte, size uint64, mode byte) []byte {
+	var code byte
+	switch typ {
+	case run:
+		code = 0
+	case add:
+		code = 1
+		if size <= 17 {
+			return append(buf, code+byte(size))
+		}
+	case cp:
+		code = 19 + 16*mode
+		if size >= 4 && size <= 18 {
+			return append(buf, code+byte(size)-3)
+		}
+	}
+	return appendInt(append(buf, code), size)
+}
+
+// splitRuns split the data to parts, so every sequence of at least
+// minRunLength equal bytes is in a separate part.
+func splitRuns(data []byte) [][]byte {
+	var parts [][]byte
+	start := 0
+	for i := 0; i < len(data); {
+		j := i + 1
+		for j < len(data) && data[j] == data[i] {
+			j++
+		}
+		if j-i >= minRunLength {
+			if start < i {
+				parts = append(parts, data[start:i])
+			}
+			parts = append(parts, data[i:j])
+			start = j
+		}
+		i = j
+	}
+	if start < len(data) {
+		parts = append(parts, data[start:])
+	}
+	return parts
+}
+
+// isRun return true if all bytes of the data are equal.
+func isRun(data []byte) bool {
+	for _, b := range data {
+		if b != data[0] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,8 @@
+#!/bin/sh
+# generate.sh create the VCDIFF deltas of the sources and the targets in this directory with xdelta3.
+set -e
+cd "$(dirname "$0")"
+for source in *.source; do
+	name="${source%.source}"
+	xdelta3 -e -S none -f -s "$source" "$name.target" "$name.xdelta3.vcdiff"
+done
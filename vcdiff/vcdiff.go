@@ -0,0 +1,200 @@
+// Package vcdiff implements the VCDIFF generic differencing and compression
+// data format (RFC 3284), so the deltas of fdiff can be exchanged with other
+// tools that speak VCDIFF, like xdelta3 and open-vcdiff.
+//
+// A VCDIFF stream contains a header and windows. Every window creates a part of
+// the target data with three types of instructions: ADD (add new bytes), RUN
+// (repeat a byte) and COPY (copy bytes from the source segment of the window
+// or from the already created part of the target window). The instructions
+// are encoded with the default code table of the RFC and the addresses of
+// COPY with the default address cache (s_near = 4, s_same = 3).
+//
+// Secondary compressors, custom code tables and VCD_TARGET windows are not
+// supported. The Adler-32 checksum of the windows, an extension of xdelta3,
+// is verified when it exists.
+package vcdiff
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// Magic is written in the beginning of every VCDIFF stream: 'V', 'C', 'D' with
+// their most significant bits set, followed by the version of the format.
+var Magic = []byte{0xd6, 0xc3, 0xc4, 0x00}
+
+// bits of Hdr_Indicator.
+const (
+	vcdDecompress = 0x01
+	vcdCodeTable  = 0x02
+	vcdAppHeader  = 0x04
+)
+
+// bits of Win_Indicator.
+const (
+	vcdSource  = 0x01
+	vcdTarget  = 0x02
+	vcdAdler32 = 0x04
+)
+
+// types of instructions.
+const (
+	noop byte = iota
+	add
+	run
+	cp
+)
+
+// instruction is one half of an entry of the code table.
+type instruction struct {
+	typ  byte
+	size byte
+	mode byte
+}
+
+// codeTable contains the instructions of every code. A code
+// is a byte in the instructions section of a window.
+type codeTable [256][2]instruction
+
+// defaultCodeTable is the default code table that is defined in section 5.6 of RFC 3284.
+var defaultCodeTable = newDefaultCodeTable()
+
+func newDefaultCodeTable() *codeTable {
+	var t codeTable
+	i := 0
+	next := func(first, second instruction) {
+		t[i] = [2]instruction{first, second}
+		i++
+	}
+
+	next(instruction{typ: run}, instruction{})
+	for size := byte(0); size <= 17; size++ {
+		next(instruction{typ: add, size: size}, instruction{})
+	}
+	for mode := byte(0); mode <= 8; mode++ {
+		next(instruction{typ: cp, mode: mode}, instruction{})
+		for size := byte(4); size <= 18; size++ {
+			next(instruction{typ: cp, size: size, mode: mode}, instruction{})
+		}
+	}
+	for mode := byte(0); mode <= 5; mode++ {
+		for addSize := byte(1); addSize <= 4; addSize++ {
+			for copySize := byte(4); copySize <= 6; copySize++ {
+				next(instruction{typ: add, size: addSize}, instruction{typ: cp, size: copySize, mode: mode})
+			}
+		}
+	}
+	for mode := byte(6); mode <= 8; mode++ {
+		for addSize := byte(1); addSize <= 4; addSize++ {
+			next(instruction{typ: add, size: addSize}, instruction{typ: cp, size: 4, mode: mode})
+		}
+	}
+	for mode := byte(0); mode <= 8; mode++ {
+		next(instruction{typ: cp, size: 4, mode: mode}, instruction{typ: add, size: 1})
+	}
+	return &t
+}
+
+// modes of the addresses of COPY.
+const (
+	modeSelf = 0
+	modeHere = 1
+
+	// sNear is the number of the addresses in the "near" cache.
+	sNear = 4
+
+	// sSame is the number of the blocks of 256 addresses in the "same" cache.
+	sSame = 3
+)
+
+// addressCache contains recently used addresses of COPY. It is used
+// to encode the addresses with fewer bytes (section 5.1 of RFC 3284).
+type addressCache struct {
+	near     [sNear]uint64
+	nextSlot int
+	same     [sSame * 256]uint64
+}
+
+// update add the address to the cache.
+func (c *addressCache) update(addr uint64) {
+	c.near[c.nextSlot] = addr
+	c.nextSlot = (c.nextSlot + 1) % sNear
+	c.same[addr%(sSame*256)] = addr
+}
+
+// encode return the mode and the value with which the address is encoded. If
+// the mode is a "same" mode, the value is one byte. 'here' is the current
+// position in the address space of the window.
+func (c *addressCache) encode(addr, here uint64) (byte, uint64) {
+	if c.same[addr%(sSame*256)] == addr {
+		m := addr % (sSame * 256)
+		return byte(2 + sNear + m/256), m % 256
+	}
+
+	mode, value := byte(modeSelf), addr
+	if d := here - addr; d < value {
+		mode, value = modeHere, d
+	}
+	for i, n := range c.near {
+		if addr >= n && addr-n < value {
+			mode, value = byte(2+i), addr-n
+		}
+	}
+	return mode, value
+}
+
+// decode read the address that is encoded with the mode.
+func (c *addressCache) decode(r *bufio.Reader, mode byte, here uint64) (uint64, error) {
+	switch {
+	case mode == modeSelf:
+		return readInt(r)
+	case mode == modeHere:
+		d, err := readInt(r)
+		if err != nil {
+			return 0, err
+		}
+		if d > here {
+			return 0, errors.New("invalid address of COPY")
+		}
+		return here - d, nil
+	case mode < 2+sNear:
+		d, err := readInt(r)
+		return c.near[mode-2] + d, err
+	case mode < 2+sNear+sSame:
+		b, err := r.ReadByte()
+		return c.same[uint64(mode-2-sNear)*256+uint64(b)], err
+	default:
+		return 0, errors.New("invalid mode of COPY")
+	}
+}
+
+// appendInt append the integer in the format of RFC 3284: base 128,
+// most significant digit first, all digits except the last one have
+// their most significant bit set.
+func appendInt(buf []byte, v uint64) []byte {
+	var digits [10]byte
+	i := len(digits) - 1
+	digits[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		digits[i] = byte(v&0x7f) | 0x80
+	}
+	return append(buf, digits[i:]...)
+}
+
+// readInt read an integer in the format of RFC 3284.
+func readInt(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for i := 0; i < 10; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("the integer is too big")
+}
@@ -0,0 +1,335 @@
+package vcdiff_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/adler32"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EmilGeorgiev/fdiff"
+	"github.com/EmilGeorgiev/fdiff/vcdiff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	// SetUp
+	ops := []fdiff.Op{
+		{Type: fdiff.OpCopy, SourceOffset: 100, Length: 10},
+		{Type: fdiff.OpData, Length: 5, Data: []byte("hello")},
+		{Type: fdiff.OpCopy, SourceOffset: 100, Length: 4},
+		{Type: fdiff.OpData, Length: 16, Data: bytes.Repeat([]byte("z"), 16)},
+	}
+
+	// Action
+	var actual bytes.Buffer
+	err := vcdiff.Encode(&actual, ops)
+
+	// Assert
+	expected := []byte{
+		0xd6, 0xc3, 0xc4, 0x00, 0x00, // magic and Hdr_Indicator
+		0x01, 10, 100, // VCD_SOURCE, length and position of the source segment
+		18,      // length of the delta encoding
+		35,      // size of the target window
+		0x00,    // Delta_Indicator
+		6, 5, 2, // length of data, instructions and addresses
+		'h', 'e', 'l', 'l', 'o', 'z', // data
+		122,   // COPY 10 with mode 6 (same cache)
+		6,     // ADD 5
+		116,   // COPY 4 with mode 6 (same cache)
+		0, 16, // RUN 16
+		0, 0, // addresses
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual.Bytes())
+}
+
+func TestDecode(t *testing.T) {
+	// SetUp
+	source := []byte("0123456789")
+	target := []byte("2345xxxxxxx345")
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, adler32.Checksum(target))
+	stream := []byte{
+		0xd6, 0xc3, 0xc4, 0x00, 0x04, // magic and Hdr_Indicator with VCD_APPHEADER
+		3, 'a', 'p', 'p', // application header
+		0x05, 4, 2, // VCD_SOURCE and VCD_ADLER32, length and position of the source segment
+		17,      // length of the delta encoding
+		14,      // size of the target window
+		0x00,    // Delta_Indicator
+		1, 4, 3, // length of data, instructions and addresses
+	}
+	stream = append(stream, checksum...)
+	stream = append(stream,
+		'x',   // data
+		247,   // COPY 4 with mode 0 (self) and ADD 1
+		38,    // COPY 6 with mode 1 (here) that overlaps with the bytes it creates
+		51, 3, // COPY 3 with mode 2 (near)
+		0, 1, 1, // addresses
+	)
+
+	// Action
+	ops, err := vcdiff.Decode(bytes.NewReader(stream), bytes.NewReader(source))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, []fdiff.Op{
+		{Type: fdiff.OpCopy, SourceOffset: 2, Length: 4},
+		{Type: fdiff.OpData, Length: 7, Data: []byte("xxxxxxx")},
+		{Type: fdiff.OpCopy, SourceOffset: 3, Length: 3},
+	}, ops)
+	assert.Equal(t, target, apply(t, ops, source))
+}
+
+func TestDecode_WhenChecksumDoesNotMatch(t *testing.T) {
+	// SetUp
+	stream := []byte{
+		0xd6, 0xc3, 0xc4, 0x00, 0x00, // magic and Hdr_Indicator
+		0x04, 12, 2, 0x00, 2, 1, 0, // VCD_ADLER32, length of the delta encoding, sizes of the window and sections
+		0, 0, 0, 0, // wrong checksum
+		'a', 'b', // data
+		3, // ADD 2
+	}
+
+	// Action
+	_, err := vcdiff.Decode(bytes.NewReader(stream), bytes.NewReader(nil))
+
+	// Assert
+	assert.EqualError(t, err, "the checksum of the window does not match")
+}
+
+func TestDecode_WhenWindowIsMalformed(t *testing.T) {
+	huge := []byte{0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}      // 2^62
+	max := []byte{0x81, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f} // 2^64-1
+	cases := map[string]struct {
+		window   []byte
+		expected string
+	}{
+		"data section is too long": {
+			window:   concat([]byte{0x00, 20, 2, 0x00}, huge, []byte{1, 0, 'a', 'b', 3}),
+			expected: "unexpected EOF",
+		},
+		"data section is longer than any slice": {
+			window:   concat([]byte{0x00, 20, 2, 0x00}, max, []byte{1, 0, 'a', 'b', 3}),
+			expected: "the length of the section 18446744073709551615 is too big",
+		},
+		"window is too big": {
+			window:   concat([]byte{0x00, 20}, huge, []byte{0x00, 2, 1, 0, 'a', 'b', 3}),
+			expected: "the size of the window 4611686018427387904 is bigger than 67108864",
+		},
+		"size of ADD overflows": {
+			window:   concat([]byte{0x00, 20, 2, 0x00, 2, 12, 0, 'a', 'b', 2, 1}, max),
+			expected: "the instructions create more bytes than the size of the window",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			stream := concat([]byte{0xd6, 0xc3, 0xc4, 0x00, 0x00}, c.window)
+
+			// Action
+			_, err := vcdiff.Decode(bytes.NewReader(stream), bytes.NewReader(nil))
+
+			// Assert
+			assert.EqualError(t, err, c.expected)
+		})
+	}
+}
+
+func TestDecode_WhenDataIsNotVCDIFF(t *testing.T) {
+	// Action
+	_, err := vcdiff.Decode(bytes.NewReader([]byte("fdiff-patch/1\n")), bytes.NewReader(nil))
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func TestEncodeAndDecode(t *testing.T) {
+	// SetUp
+	r := rand.New(rand.NewSource(1))
+	source := make([]byte, 3<<20)
+	r.Read(source)
+	var ops []fdiff.Op
+	for i := 0; i < 200; i++ {
+		length := uint64(r.Intn(40000))
+		if i%3 == 0 {
+			data := make([]byte, length)
+			r.Read(data)
+			if i%2 == 0 {
+				data = append(data, bytes.Repeat([]byte{0}, 100)...)
+			}
+			ops = append(ops, fdiff.Op{Type: fdiff.OpData, Length: uint64(len(data)), Data: data})
+			continue
+		}
+		ops = append(ops, fdiff.Op{Type: fdiff.OpCopy, SourceOffset: uint64(r.Intn(len(source) - int(length))), Length: length})
+	}
+	expected := apply(t, ops, source)
+
+	// Action
+	var stream bytes.Buffer
+	err := vcdiff.Encode(&stream, ops)
+	decoded, decodeErr := vcdiff.Decode(&stream, bytes.NewReader(source))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, decodeErr)
+	assert.Greater(t, len(expected), 2<<20)
+	assert.Equal(t, expected, apply(t, decoded, source))
+}
+
+func TestEncode_WithZeroOps(t *testing.T) {
+	// SetUp
+	source := []byte("the source data")
+	ops := []fdiff.Op{
+		{Type: fdiff.OpZero, Length: 100000},
+		{Type: fdiff.OpCopy, SourceOffset: 4, Length: 6},
+		{Type: fdiff.OpZero, Length: 3},
+	}
+	expected := apply(t, ops, source)
+
+	// Action
+	var stream bytes.Buffer
+	err := vcdiff.Encode(&stream, ops)
+	size := stream.Len()
+	decoded, decodeErr := vcdiff.Decode(&stream, bytes.NewReader(source))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Nil(t, decodeErr)
+	assert.Less(t, size, 100)
+	assert.Equal(t, expected, apply(t, decoded, source))
+}
+
+func TestDecode_WithPatchOfXdelta3(t *testing.T) {
+	// SetUp
+	dir, source, target := xdelta3Files(t)
+	cmd := exec.Command("xdelta3", "-e", "-S", "none", "-f", "-s", filepath.Join(dir, "old"), filepath.Join(dir, "new"), filepath.Join(dir, "patch"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("xdelta3: %v: %s", err, out)
+	}
+	stream, err := os.ReadFile(filepath.Join(dir, "patch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Action
+	ops, err := vcdiff.Decode(bytes.NewReader(stream), bytes.NewReader(source))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, target, apply(t, ops, source))
+}
+
+func TestEncode_WhenPatchIsAppliedByXdelta3(t *testing.T) {
+	// SetUp
+	dir, source, target := xdelta3Files(t)
+	ops := []fdiff.Op{
+		{Type: fdiff.OpCopy, SourceOffset: 0, Length: 50000},
+		{Type: fdiff.OpData, Length: 1000, Data: target[50000:51000]},
+		{Type: fdiff.OpCopy, SourceOffset: 51000, Length: uint64(len(source)) - 51000},
+		{Type: fdiff.OpData, Length: uint64(len(target) - len(source)), Data: target[len(source):]},
+	}
+	var stream bytes.Buffer
+	if err := vcdiff.Encode(&stream, ops); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "patch"), stream.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Action
+	out, err := exec.Command("xdelta3", "-d", "-f", "-s", filepath.Join(dir, "old"), filepath.Join(dir, "patch"), filepath.Join(dir, "actual")).CombinedOutput()
+
+	// Assert
+	assert.Nil(t, err, string(out))
+	actual, _ := os.ReadFile(filepath.Join(dir, "actual"))
+	assert.Equal(t, target, actual)
+}
+
+func TestDecode_WithGoldenFiles(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "*.source"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, sources)
+
+	for _, s := range sources {
+		name := strings.TrimSuffix(filepath.Base(s), ".source")
+		t.Run(name, func(t *testing.T) {
+			// SetUp
+			stream, err := os.ReadFile(filepath.Join("testdata", name+".xdelta3.vcdiff"))
+			if os.IsNotExist(err) {
+				t.Skip("the delta is not created, see testdata/generate.sh")
+			}
+			assert.NoError(t, err)
+			source := readFile(t, name+".source")
+
+			// Action
+			ops, err := vcdiff.Decode(bytes.NewReader(stream), bytes.NewReader(source))
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, readFile(t, name+".target"), apply(t, ops, source))
+		})
+	}
+}
+
+// requireTool skip the test when the tool is not installed. In CI the tools are installed,
+// so there the test fails instead of being skipped.
+func requireTool(t *testing.T, name string) {
+	if _, err := exec.LookPath(name); err != nil {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("%s is not installed", name)
+		}
+		t.Skipf("%s is not installed", name)
+	}
+}
+
+// xdelta3Files skip the test when xdelta3 is not installed. Otherwise it write an old
+// and a new file in a temporary directory and return the directory and their data.
+func xdelta3Files(t *testing.T) (string, []byte, []byte) {
+	requireTool(t, "xdelta3")
+	r := rand.New(rand.NewSource(1))
+	source := make([]byte, 200000)
+	r.Read(source)
+	target := append([]byte{}, source...)
+	r.Read(target[50000:51000])
+	extra := make([]byte, 3000)
+	r.Read(extra)
+	target = append(target, extra...)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "old"), source, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new"), target, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, source, target
+}
+
+// concat return the bytes of all slices one after another.
+func concat(slices ...[]byte) []byte {
+	var result []byte
+	for _, s := range slices {
+		result = append(result, s...)
+	}
+	return result
+}
+
+func readFile(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	assert.NoError(t, err)
+	return data
+}
+
+func apply(t *testing.T, ops []fdiff.Op, source []byte) []byte {
+	var buf bytes.Buffer
+	if err := (fdiff.Patch{Ops: ops}).Apply(bytes.NewReader(source), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}